@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/netip"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+// Query is what an Answerer gets to see of a request.
+type Query struct {
+	Question parser.Question
+	// the EDNS Client Subnet of the request, nil if the client sent none
+	ClientSubnet *parser.ClientSubnet
+	// the client asked for signatures with the DO bit
+	DNSSECOK bool
+}
+
+type Result struct {
+	Answers []parser.Answer
+	// the SOA record of negative answers or the NS records of a referral,
+	// with the DS records or denial proofs for clients that asked for
+	// DNSSEC
+	Authority []parser.Answer
+	// the addresses of the name servers of a referral
+	Additional []parser.Answer
+	// the name is delegated to other servers, the answer isn't
+	// authoritative
+	Referral bool
+	// the prefix length of the client subnet the answers are valid for, 0 if
+	// they are the same for every client
+	Scope        uint8
+	ResponseCode parser.RCODE
+	// why the question couldn't be answered normally, sent to clients that
+	// support EDNS
+	Error *parser.ExtendedError
+	// the answer validated as secure from a trust anchor
+	Authentic bool
+}
+
+type Answerer func(query Query) Result
+
+var refusedClass = Result{
+	ResponseCode: parser.REFUSED,
+	Error: &parser.ExtendedError{
+		InfoCode:  parser.EDE_NOT_SUPPORTED,
+		ExtraText: "only class IN is served",
+	},
+}
+
+// fixedAnswer answers every A question in class IN with 1.1.1.1.
+func fixedAnswer(query Query) Result {
+	question := query.Question
+	if question.Class != parser.IN {
+		return refusedClass
+	}
+	if question.Type != parser.A {
+		return Result{}
+	}
+	return Result{
+		Answers: []parser.Answer{
+			{
+				Name:  question.Name,
+				Type:  question.Type,
+				Class: question.Class,
+				TTL:   3600,
+				Data:  parser.ARecord{Addr: netip.AddrFrom4([4]byte{1, 1, 1, 1})},
+			},
+		},
+	}
+}
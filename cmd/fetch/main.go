@@ -1,17 +1,33 @@
 package main
 
 import (
-	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"time"
 
+	"github.com/pascal-sochacki/dns/internal/cookie"
+	"github.com/pascal-sochacki/dns/internal/dnssec"
 	"github.com/pascal-sochacki/dns/internal/parser"
 )
 
+const (
+	udpSize  = 1232
+	upstream = "192.203.230.10:53"
+	// a lost UDP datagram is sent again after timeout, at most attempts times
+	timeout  = 2 * time.Second
+	attempts = 3
+)
+
 func main() {
+	validate := flag.Bool("dnssec", false, "ask for signatures and validate the response from the root trust anchors")
+	flag.Parse()
 
-	udpAddr, err := net.ResolveUDPAddr("udp", "192.203.230.10:53")
+	udpAddr, err := net.ResolveUDPAddr("udp", upstream)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -22,35 +38,131 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	header := parser.Header{
-		ID:            12,
-		IsQuery:       true,
-		QuestionCount: 1,
+	jar := cookie.NewJar()
+	resolver := &upstreamResolver{conn: conn, jar: jar, dnssecOK: *validate}
+	message, err := resolver.Lookup(parser.Name{"eu"}, parser.A)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	question := parser.Question{
-		Labels: []string{"eu"},
-		Type:   parser.A,
-		Class:  parser.IN,
+
+	fmt.Println(message)
+	for _, ede := range message.ExtendedErrors() {
+		fmt.Println("extended error:", ede)
+	}
+	if *validate {
+		result := dnssec.NewValidator(resolver, dnssec.RootAnchors()).Validate(message)
+		if result.Err != nil {
+			fmt.Println("dnssec:", result.Status, result.Err)
+		} else {
+			fmt.Println("dnssec:", result.Status)
+		}
 	}
-	request := new(bytes.Buffer)
+}
 
-	buf, _ := header.ToBinary()
-	request.Write(buf)
+// upstreamResolver asks the upstream server, it also fetches the DS and
+// DNSKEY records for validation.
+type upstreamResolver struct {
+	conn     *net.UDPConn
+	jar      *cookie.Jar
+	dnssecOK bool
+}
 
-	buf, _ = question.ToBinary()
-	request.Write(buf)
+func (r *upstreamResolver) Lookup(name parser.Name, t parser.QType) (parser.Message, error) {
+	message, err := exchange(r.conn, r.jar, name, t, r.dnssecOK)
+	// the server sent a fresh server cookie along with BADCOOKIE, RFC 7873
+	// section 5.3
+	if err == nil && message.Header.ResponseCode == parser.BADCOOKIE {
+		message, err = exchange(r.conn, r.jar, name, t, r.dnssecOK)
+	}
+	return message, err
+}
 
-	_, err = conn.Write(request.Bytes())
+func exchange(conn *net.UDPConn, jar *cookie.Jar, name parser.Name, t parser.QType, dnssecOK bool) (parser.Message, error) {
+	server := conn.RemoteAddr().String()
+	option, err := jar.Cookie(server).Option()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return parser.Message{}, err
 	}
-	reponse := make([]byte, 1024)
-	_, _, _ = conn.ReadFrom(reponse)
-	println("RAW")
-	for _, v := range reponse {
-		fmt.Printf("%08b\n", v)
+	request := parser.NewQuery(name, t).SetEDNS(parser.EDNS{UDPSize: udpSize, DNSSECOK: dnssecOK, Options: []parser.EDNSOption{option}})
+	buf, err := request.Pack()
+	if err != nil {
+		return parser.Message{}, err
 	}
-	println("RAW")
 
+	reponse, err := exchangeUDP(conn, buf)
+	if err != nil {
+		return parser.Message{}, err
+	}
+	message, err := parser.Parse(reponse)
+	if err != nil {
+		return parser.Message{}, err
+	}
+	// the full answer didn't fit in a datagram, RFC 7766 section 5
+	if message.Header.TrunCation {
+		if reponse, err = exchangeTCP(server, buf); err != nil {
+			return parser.Message{}, err
+		}
+		if message, err = parser.Parse(reponse); err != nil {
+			return parser.Message{}, err
+		}
+	}
+	if message.Header.ID != request.Header.ID {
+		return parser.Message{}, fmt.Errorf("response id %d does not match request id %d", message.Header.ID, request.Header.ID)
+	}
+	if err := jar.Update(server, message); err != nil {
+		return parser.Message{}, err
+	}
+	return message, nil
+}
+
+// exchangeUDP sends the request and waits for the response, sending it again
+// when nothing arrives in time.
+func exchangeUDP(conn *net.UDPConn, request []byte) ([]byte, error) {
+	reponse := make([]byte, udpSize)
+	for range attempts {
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+		n, err := conn.Read(reponse)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return reponse[:n], nil
+	}
+	return nil, fmt.Errorf("no response from %s after %d attempts of %s", conn.RemoteAddr(), attempts, timeout)
+}
+
+// exchangeTCP sends the request over a new TCP connection, every message is
+// preceded by its length, RFC 1035 section 4.2.2.
+func exchangeTCP(server string, request []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(request)))); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, fmt.Errorf("reading response length from %s: %w", server, err)
+	}
+	reponse := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, reponse); err != nil {
+		return nil, fmt.Errorf("reading response from %s: %w", server, err)
+	}
+	return reponse, nil
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+	"github.com/pascal-sochacki/dns/internal/pcap"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "write one RFC 8427 JSON object per message")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: pcap [-json] file...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, path := range flag.Args() {
+		if err := dump(path, *asJSON); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func dump(path string, asJSON bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := pcap.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for {
+		message, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if asJSON {
+			line, err := json.Marshal(toJSON(message))
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(line))
+			continue
+		}
+		fmt.Printf(";; %s %s -> %s %s\n", message.Time.Format(time.RFC3339Nano), message.Src, message.Dst, message.Transport)
+		if message.Err != nil {
+			fmt.Printf(";; malformed message: %s\n;; %s\n\n", message.Err, strings.ToUpper(hex.EncodeToString(message.Raw)))
+			continue
+		}
+		fmt.Println(message.Message)
+		fmt.Println()
+	}
+}
+
+type messageJSON struct {
+	Time      time.Time       `json:"time"`
+	Transport string          `json:"transport"`
+	Src       string          `json:"src"`
+	Dst       string          `json:"dst"`
+	Message   *parser.Message `json:"message,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	// only for messages that can't be decoded
+	MessageOctetsHEX string `json:"messageOctetsHEX,omitempty"`
+}
+
+func toJSON(message pcap.Message) messageJSON {
+	j := messageJSON{
+		Time:      message.Time,
+		Transport: message.Transport,
+		Src:       message.Src.String(),
+		Dst:       message.Dst.String(),
+	}
+	if message.Err != nil {
+		j.Error = message.Err.Error()
+		j.MessageOctetsHEX = strings.ToUpper(hex.EncodeToString(message.Raw))
+		return j
+	}
+	j.Message = &message.Message
+	return j
+}
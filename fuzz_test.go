@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+// seedMessages adds the hand written vectors of main_test.go and the
+// responses in testdata/responses to the corpus of f.
+func seedMessages(f *testing.F) {
+	f.Add(euReferral)
+	for _, message := range []parser.Message{
+		*parser.NewQuery(parser.Name{"example", "com"}, parser.A),
+		*parser.NewQuery(parser.Name{"example", "com"}, parser.TXT).SetEDNS(parser.EDNS{UDPSize: 1232, DNSSECOK: true}),
+	} {
+		buf, err := message.Pack()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf)
+	}
+	files, err := filepath.Glob(filepath.Join("testdata", "responses", "*.bin"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf)
+	}
+}
+
+func FuzzParseHeader(f *testing.F) {
+	seedMessages(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		header, err := parser.ParseHeader(parser.NewLookBackBuffer(data))
+		if err != nil {
+			if len(data) >= headerLength {
+				t.Fatalf("a full header should parse: %s", err)
+			}
+			return
+		}
+		buf, err := header.ToBinary()
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		// every bit of the header is decoded, so it has to come back as is
+		CompareBytes(t, buf, data[:headerLength])
+	})
+}
+
+func FuzzParseQuestion(f *testing.F) {
+	f.Add([]byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1})
+	f.Add([]byte{0, 0, 255, 0, 3})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		question, err := parser.ParseQuestion(parser.NewLookBackBuffer(data))
+		if err != nil {
+			return
+		}
+		buf, err := question.ToBinary()
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		again, err := parser.ParseQuestion(parser.NewLookBackBuffer(buf))
+		if err != nil {
+			t.Fatalf("packed question should parse: %s", err)
+		}
+		if !reflect.DeepEqual(again, question) {
+			t.Fatalf("round trip dont match is %#v want %#v", again, question)
+		}
+	})
+}
+
+func FuzzParseAnswer(f *testing.F) {
+	for _, record := range []string{
+		"example.com. 60 IN A 192.0.2.1",
+		"example.com. 60 IN MX 10 mail.example.com.",
+		`example.com. 60 IN TXT "v=spf1 -all" ""`,
+		"example.com. 60 IN SOA ns.example.com. hostmaster.example.com. 1 2 3 4 5",
+		`example.com. 60 IN TYPE12345 \# 2 abcd`,
+	} {
+		answer, err := parser.ParseRecord(record)
+		if err != nil {
+			f.Fatal(err)
+		}
+		buf, err := answer.ToBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		answer, err := parser.ParseAnswer(parser.NewLookBackBuffer(data))
+		if err != nil {
+			return
+		}
+		buf, err := answer.ToBinary()
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		again, err := parser.ParseAnswer(parser.NewLookBackBuffer(buf))
+		if err != nil {
+			t.Fatalf("packed record should parse: %s", err)
+		}
+		if !reflect.DeepEqual(again, answer) {
+			t.Fatalf("round trip dont match is %#v want %#v", again, answer)
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	seedMessages(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		message, err := parser.Parse(data)
+		if err != nil {
+			var parseError *parser.ParseError
+			if !errors.As(err, &parseError) {
+				t.Fatalf("errors should carry an offset: %s", err)
+			}
+			return
+		}
+		buf, err := message.Pack()
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		again, err := parser.Parse(buf)
+		if err != nil {
+			t.Fatalf("packed message should parse: %s", err)
+		}
+		if !reflect.DeepEqual(again, message) {
+			t.Fatalf("round trip dont match is\n%s\nwant\n%s", again, message)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package cookie
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+var ErrCookieMismatch = errors.New("response does not echo our client cookie")
+
+// Jar is the client side: it derives a client cookie per upstream server and
+// remembers the server cookie each upstream handed out.
+type Jar struct {
+	mu      sync.Mutex
+	secret  [16]byte
+	servers map[string][]byte
+}
+
+func NewJar() *Jar {
+	jar := &Jar{servers: map[string][]byte{}}
+	rand.Read(jar.secret[:])
+	return jar
+}
+
+// Cookie returns the cookie to send to server, which is any string that
+// identifies the upstream, like its address.
+func (jar *Jar) Cookie(server string) parser.Cookie {
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	cookie := parser.Cookie{Client: jar.clientCookie(server)}
+	cookie.Server = append([]byte{}, jar.servers[server]...)
+	return cookie
+}
+
+// different upstreams must not be able to link our cookies, RFC 7873
+// section 4.1
+func (jar *Jar) clientCookie(server string) [8]byte {
+	var client [8]byte
+	binary.LittleEndian.PutUint64(client[:], sipHash(jar.secret, []byte(server)))
+	return client
+}
+
+// Update learns the server cookie from a response of server. A response
+// with a cookie that doesn't echo our client cookie has to be discarded, a
+// response without cookie is accepted since the server may not support them.
+func (jar *Jar) Update(server string, response parser.Message) error {
+	edns, ok := response.EDNS()
+	if !ok {
+		return nil
+	}
+	option, ok := edns.Option(parser.COOKIE)
+	if !ok {
+		return nil
+	}
+	cookie, err := parser.ParseCookie(option)
+	if err != nil {
+		return err
+	}
+
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	if cookie.Client != jar.clientCookie(server) {
+		return ErrCookieMismatch
+	}
+	if len(cookie.Server) > 0 {
+		jar.servers[server] = cookie.Server
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package cookie implements the server and client side of DNS Cookies
+// (RFC 7873) with the interoperable server cookies of RFC 9018.
+package cookie
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+const (
+	version = 1
+	// server cookies of RFC 9018 are version, reserved, timestamp and hash
+	serverCookieLength = 16
+
+	// RFC 9018 section 4.3
+	maxAge       = time.Hour
+	refreshAfter = 30 * time.Minute
+	maxClockSkew = 5 * time.Minute
+)
+
+// Server creates and verifies server cookies. The secret is replaced every
+// rotation interval; cookies made with the previous secret stay valid until
+// the next rotation.
+type Server struct {
+	mu       sync.Mutex
+	rotation time.Duration
+	rotated  time.Time
+	current  [16]byte
+	previous *[16]byte
+}
+
+func NewServer(rotation time.Duration) *Server {
+	server := &Server{rotation: rotation}
+	server.Rotate(time.Now())
+	return server
+}
+
+// Rotate replaces the secret, keeping the old one for verification.
+func (s *Server) Rotate(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate(now)
+}
+
+// SetSecret replaces the secret with a given one, for operators that share
+// one secret between several servers as RFC 9018 intends.
+func (s *Server) SetSecret(secret [16]byte, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.current
+	s.previous = &previous
+	s.current = secret
+	s.rotated = now
+}
+
+func (s *Server) rotate(now time.Time) {
+	if !s.rotated.IsZero() {
+		previous := s.current
+		s.previous = &previous
+	}
+	rand.Read(s.current[:])
+	s.rotated = now
+}
+
+func (s *Server) secrets(now time.Time) ([16]byte, *[16]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rotation > 0 && now.Sub(s.rotated) >= s.rotation {
+		s.rotate(now)
+	}
+	return s.current, s.previous
+}
+
+// Generate returns a fresh server cookie for the client cookie and address.
+func (s *Server) Generate(client [8]byte, addr netip.Addr, now time.Time) []byte {
+	current, _ := s.secrets(now)
+	return serverCookie(current, client, addr, uint32(now.Unix()))
+}
+
+// Verify reports whether cookie carries a server cookie that this server
+// handed out to addr. If the cookie is valid but should be replaced, the
+// second result is true.
+func (s *Server) Verify(cookie parser.Cookie, addr netip.Addr, now time.Time) (valid bool, refresh bool) {
+	if len(cookie.Server) != serverCookieLength || cookie.Server[0] != version {
+		return false, false
+	}
+	timestamp := binary.BigEndian.Uint32(cookie.Server[4:])
+	issued := time.Unix(int64(timestamp), 0)
+	if now.Sub(issued) > maxAge || issued.Sub(now) > maxClockSkew {
+		return false, false
+	}
+
+	current, previous := s.secrets(now)
+	for _, secret := range []*[16]byte{&current, previous} {
+		if secret == nil {
+			continue
+		}
+		expect := serverCookie(*secret, cookie.Client, addr, timestamp)
+		if string(expect) == string(cookie.Server) {
+			return true, secret != &current || now.Sub(issued) > refreshAfter
+		}
+	}
+	return false, false
+}
+
+// serverCookie builds the cookie of RFC 9018 section 4.
+func serverCookie(secret [16]byte, client [8]byte, addr netip.Addr, timestamp uint32) []byte {
+	cookie := []byte{version, 0, 0, 0}
+	cookie = binary.BigEndian.AppendUint32(cookie, timestamp)
+
+	input := append(client[:], cookie...)
+	input = append(input, addr.Unmap().AsSlice()...)
+	return binary.LittleEndian.AppendUint64(cookie, sipHash(secret, input))
+}
@@ -0,0 +1,160 @@
+package dnssec
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+// Key is a DNSKEY with its private key, as generated by dnssec-keygen.
+type Key struct {
+	Owner  parser.Name
+	TTL    uint32
+	DNSKEY parser.DNSKEYRecord
+	signer crypto.Signer
+}
+
+// LoadKey reads the key pair of the files prefix.key and prefix.private,
+// for example Kexample.+013+12345. Only ECDSA P-256 and Ed25519 keys can
+// sign.
+func LoadKey(prefix string) (Key, error) {
+	public, err := os.ReadFile(prefix + ".key")
+	if err != nil {
+		return Key{}, err
+	}
+	// the record of a key file usually has no TTL
+	records, err := parser.ParseZone("$TTL 3600\n"+string(public), prefix+".key", parser.Name{})
+	if err != nil {
+		return Key{}, err
+	}
+	if len(records) != 1 || records[0].Type != parser.DNSKEY {
+		return Key{}, fmt.Errorf("%w: %s.key has no single DNSKEY record", ErrBadKey, prefix)
+	}
+	key := Key{Owner: records[0].Name, TTL: records[0].TTL, DNSKEY: records[0].Data.(parser.DNSKEYRecord)}
+
+	private, err := os.ReadFile(prefix + ".private")
+	if err != nil {
+		return Key{}, err
+	}
+	key.signer, err = ParsePrivateKey(string(private), key.DNSKEY)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s.private: %w", prefix, err)
+	}
+	return key, nil
+}
+
+// ParsePrivateKey reads the private key format of BIND, fields like
+// "Algorithm: 13 (ECDSAP256SHA256)" and "PrivateKey: <base64>". The key has
+// to belong to dnskey.
+func ParsePrivateKey(text string, dnskey parser.DNSKEYRecord) (crypto.Signer, error) {
+	fields := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if ok {
+			fields[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	algorithm, _, _ := strings.Cut(fields["Algorithm"], " ")
+	number, err := strconv.ParseUint(algorithm, 10, 8)
+	if err != nil || parser.Algorithm(number) != dnskey.Algorithm {
+		return nil, fmt.Errorf("%w: algorithm %q doesn't match the DNSKEY", ErrBadKey, fields["Algorithm"])
+	}
+	private, err := base64.StdEncoding.DecodeString(fields["PrivateKey"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadKey, err)
+	}
+
+	var signer crypto.Signer
+	var public []byte
+	switch dnskey.Algorithm {
+	case parser.ECDSAP256SHA256:
+		// ecdh checks that the scalar is in range
+		checked, err := ecdh.P256().NewPrivateKey(private)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadKey, err)
+		}
+		public = checked.PublicKey().Bytes()[1:]
+		signer = &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[:32]),
+				Y:     new(big.Int).SetBytes(public[32:]),
+			},
+			D: new(big.Int).SetBytes(private),
+		}
+	case parser.ED25519:
+		if len(private) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%w: Ed25519 key of %d octets", ErrBadKey, len(private))
+		}
+		key := ed25519.NewKeyFromSeed(private)
+		public, signer = key.Public().(ed25519.PublicKey), key
+	default:
+		return nil, fmt.Errorf("%w: can't sign with %s", ErrUnsupportedAlgorithm, dnskey.Algorithm)
+	}
+	if string(public) != string(dnskey.PublicKey) {
+		return nil, fmt.Errorf("%w: private key doesn't match the DNSKEY", ErrBadKey)
+	}
+	return signer, nil
+}
+
+// Record returns the DNSKEY record of the key.
+func (key Key) Record() parser.Answer {
+	return parser.Answer{Name: key.Owner, Type: parser.DNSKEY, Class: parser.IN, TTL: key.TTL, Data: key.DNSKEY}
+}
+
+// Sign returns the RRSIG of rrset, valid from inception to expiration, RFC
+// 4035 section 2.2.
+func (key Key) Sign(rrset []parser.Answer, inception time.Time, expiration time.Time) (parser.Answer, error) {
+	if len(rrset) == 0 {
+		return parser.Answer{}, fmt.Errorf("%w: empty RRset", ErrBadSignature)
+	}
+	owner := rrset[0].Name
+	// the labels field doesn't count a leading wildcard, RFC 4034 section
+	// 3.1.3
+	labels := len(owner)
+	if labels > 0 && owner[0] == "*" {
+		labels--
+	}
+	sig := parser.RRSIGRecord{
+		TypeCovered: rrset[0].Type,
+		Algorithm:   key.DNSKEY.Algorithm,
+		Labels:      uint8(labels),
+		OriginalTTL: rrset[0].TTL,
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      key.DNSKEY.KeyTag(),
+		SignerName:  key.Owner,
+	}
+	data, err := parser.SignedData(sig, rrset)
+	if err != nil {
+		return parser.Answer{}, err
+	}
+	switch signer := key.signer.(type) {
+	case ed25519.PrivateKey:
+		sig.Signature = ed25519.Sign(signer, data)
+	case *ecdsa.PrivateKey:
+		// r and s of 32 octets each, RFC 6605 section 4
+		hash := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, signer, hash[:])
+		if err != nil {
+			return parser.Answer{}, err
+		}
+		sig.Signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		return parser.Answer{}, fmt.Errorf("%w: key %d has no private key", ErrBadKey, sig.KeyTag)
+	}
+	return parser.Answer{Name: owner, Type: parser.RRSIG, Class: rrset[0].Class, TTL: rrset[0].TTL, Data: sig}, nil
+}
@@ -0,0 +1,372 @@
+package dnssec
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+// NSEC3 records with more iterations are not worth the work of hashing,
+// answers depending on them are insecure, RFC 9276 section 3.2
+const maxIterations = 150
+
+// denial holds the validated NSEC and NSEC3 records of a response.
+type denial struct {
+	nsec  []nsecRecord
+	nsec3 []nsec3Record
+}
+
+type nsecRecord struct {
+	owner parser.Name
+	parser.NSECRecord
+}
+
+type nsec3Record struct {
+	// the owner name without the hash
+	zone parser.Name
+	hash []byte
+	parser.NSEC3Record
+}
+
+func (record nsec3Record) owner() parser.Name {
+	return append(parser.Name{strings.ToLower(base32Hex.EncodeToString(record.hash))}, record.zone...)
+}
+
+// add collects the NSEC and NSEC3 records of answers. NSEC3 records with an
+// owner that isn't a hash or an unknown hash algorithm are ignored, RFC 5155
+// section 8.1.
+func (d *denial) add(answers []parser.Answer) {
+	for _, answer := range answers {
+		switch data := answer.Data.(type) {
+		case parser.NSECRecord:
+			d.nsec = append(d.nsec, nsecRecord{owner: answer.Name, NSECRecord: data})
+		case parser.NSEC3Record:
+			if len(answer.Name) == 0 || data.HashAlgorithm != parser.NSEC3_SHA1 {
+				continue
+			}
+			hash, err := base32Hex.DecodeString(strings.ToUpper(answer.Name[0]))
+			if err != nil || len(hash) != sha1.Size {
+				continue
+			}
+			d.nsec3 = append(d.nsec3, nsec3Record{zone: answer.Name.Parent(), hash: hash, NSEC3Record: data})
+		}
+	}
+}
+
+// Proof picks the NSEC or NSEC3 records with their signatures that a
+// negative answer for name needs out of the records of a signed zone: the
+// one of name if it exists, or else the ones matching or covering name, its
+// ancestors up to apex and the wildcards below them.
+func Proof(records []parser.Answer, apex parser.Name, name parser.Name) []parser.Answer {
+	d := denial{}
+	d.add(records)
+	owners := []parser.Name{}
+	if record, ok := d.nsecMatching(name); ok {
+		owners = append(owners, record.owner)
+	} else if record, ok := d.nsec3Matching(name); ok {
+		owners = append(owners, record.owner())
+	}
+	exists := len(owners) > 0
+	for i := 0; !exists && i <= len(name)-len(apex); i++ {
+		wildcard, _ := name[i:].Child("*")
+		for _, n := range []parser.Name{name[i:], wildcard} {
+			if record, ok := d.nsecMatching(n); ok {
+				owners = append(owners, record.owner)
+			}
+			if record, ok := d.nsecCovering(n); ok {
+				owners = append(owners, record.owner)
+			}
+			if record, ok := d.nsec3Matching(n); ok {
+				owners = append(owners, record.owner())
+			}
+			if record, ok := d.nsec3Covering(n); ok {
+				owners = append(owners, record.owner())
+			}
+		}
+	}
+	proof := []parser.Answer{}
+	for _, record := range records {
+		t := record.Type
+		if sig, ok := record.Data.(parser.RRSIGRecord); ok {
+			t = sig.TypeCovered
+		}
+		if (t == parser.NSEC || t == parser.NSEC3) && slices.ContainsFunc(owners, record.Name.Equal) {
+			proof = append(proof, record)
+		}
+	}
+	return proof
+}
+
+// noData proves that name exists but has no records of type t, RFC 4035
+// section 5.4 and RFC 5155 section 8.5 to 8.7. It is insecure if the proof
+// depends on an opt-out span or NSEC3 parameters that are not validated.
+func (d denial) noData(name parser.Name, t parser.QType) (insecure bool, err error) {
+	if len(d.nsec) > 0 {
+		return false, d.nsecNoData(name, t)
+	}
+	return d.nsec3NoData(name, t)
+}
+
+// nxDomain proves that name doesn't exist and no wildcard could have
+// created it.
+func (d denial) nxDomain(name parser.Name) (insecure bool, err error) {
+	if len(d.nsec) > 0 {
+		return false, d.nsecNXDomain(name)
+	}
+	ce, insecure, err := d.closestEncloser(name)
+	if err != nil || insecure {
+		return insecure, err
+	}
+	wildcard, _ := ce.Child("*")
+	if _, ok := d.nsec3Covering(wildcard); !ok {
+		return false, fmt.Errorf("%w: no NSEC3 covers %s", ErrNoDenial, wildcard)
+	}
+	return false, nil
+}
+
+// noDS proves that there is no DS record at name. cut tells whether name
+// is an unsigned delegation rather than a name inside the zone.
+func (d denial) noDS(name parser.Name) (cut bool, err error) {
+	if len(d.nsec) > 0 {
+		if record, ok := d.nsecMatching(name); ok {
+			if record.HasType(parser.DS) || record.HasType(parser.SOA) && len(name) > 0 {
+				return false, fmt.Errorf("%w: the NSEC of %s doesn't deny DS", ErrNoDenial, name)
+			}
+			return record.HasType(parser.NS), nil
+		}
+		return false, d.nsecNoData(name, parser.DS)
+	}
+	if record, ok := d.nsec3Matching(name); ok {
+		if record.HasType(parser.DS) || record.HasType(parser.SOA) && len(name) > 0 {
+			return false, fmt.Errorf("%w: the NSEC3 of %s doesn't deny DS", ErrNoDenial, name)
+		}
+		return record.HasType(parser.NS), nil
+	}
+	// an unsigned delegation in an opt-out span, RFC 5155 section 8.6
+	_, insecure, err := d.closestEncloser(name)
+	if err != nil {
+		return false, err
+	}
+	if !insecure {
+		return false, fmt.Errorf("%w: %s is not in an opt-out span", ErrNoDenial, name)
+	}
+	return true, nil
+}
+
+// wildcard proves that name didn't exist, so that an answer could be
+// expanded from the wildcard below ce, RFC 4035 section 5.3.4 and RFC 5155
+// section 8.8.
+func (d denial) wildcard(name parser.Name, ce parser.Name) (insecure bool, err error) {
+	if len(d.nsec) > 0 {
+		if _, ok := d.nsecCovering(name); !ok {
+			return false, fmt.Errorf("%w: no NSEC covers %s", ErrNoDenial, name)
+		}
+		return false, nil
+	}
+	nextCloser := name[len(name)-len(ce)-1:]
+	record, ok := d.nsec3Covering(nextCloser)
+	if !ok {
+		return false, fmt.Errorf("%w: no NSEC3 covers %s", ErrNoDenial, nextCloser)
+	}
+	return record.Flags&parser.NSEC3_OPT_OUT != 0, nil
+}
+
+func (d denial) nsecNoData(name parser.Name, t parser.QType) error {
+	if record, ok := d.nsecMatching(name); ok {
+		if record.HasType(t) || record.HasType(parser.CNAME) {
+			return fmt.Errorf("%w: the NSEC of %s has %s", ErrNoDenial, name, t)
+		}
+		// the NSEC of a delegation in the parent says nothing about the
+		// child, RFC 6840 section 4.1
+		if t != parser.DS && record.HasType(parser.NS) && !record.HasType(parser.SOA) {
+			return fmt.Errorf("%w: %s is a delegation", ErrNoDenial, name)
+		}
+		return nil
+	}
+	covering, ok := d.nsecCovering(name)
+	if !ok {
+		return fmt.Errorf("%w: no NSEC for %s", ErrNoDenial, name)
+	}
+	// an empty non-terminal, RFC 4035 section 5.4
+	if covering.NextDomain.IsSubdomainOf(name) {
+		return nil
+	}
+	// or a wildcard without the type
+	wildcard, _ := closestEncloser(name, covering).Child("*")
+	record, ok := d.nsecMatching(wildcard)
+	if !ok || record.HasType(t) || record.HasType(parser.CNAME) {
+		return fmt.Errorf("%w: no NSEC proves %s has no %s", ErrNoDenial, name, t)
+	}
+	return nil
+}
+
+func (d denial) nsecNXDomain(name parser.Name) error {
+	covering, ok := d.nsecCovering(name)
+	if !ok {
+		return fmt.Errorf("%w: no NSEC covers %s", ErrNoDenial, name)
+	}
+	if covering.NextDomain.IsSubdomainOf(name) {
+		return fmt.Errorf("%w: %s is an empty non-terminal", ErrNoDenial, name)
+	}
+	ce := closestEncloser(name, covering)
+	wildcard, _ := ce.Child("*")
+	if _, ok := d.nsecCovering(wildcard); !ok {
+		return fmt.Errorf("%w: no NSEC covers %s", ErrNoDenial, wildcard)
+	}
+	return nil
+}
+
+func (d denial) nsecMatching(name parser.Name) (nsecRecord, bool) {
+	for _, record := range d.nsec {
+		if record.owner.Equal(name) {
+			return record, true
+		}
+	}
+	return nsecRecord{}, false
+}
+
+// nsecCovering returns the NSEC with name between its owner and the next
+// name. Names below a delegation or DNAME are not covered by the NSEC of
+// their ancestor, RFC 6840 section 4.1.
+func (d denial) nsecCovering(name parser.Name) (nsecRecord, bool) {
+	for _, record := range d.nsec {
+		if !covers(record.owner.Compare(name), name.Compare(record.NextDomain), record.owner.Compare(record.NextDomain)) {
+			continue
+		}
+		if name.IsSubdomainOf(record.owner) && (record.HasType(parser.DNAME) || record.HasType(parser.NS) && !record.HasType(parser.SOA)) {
+			continue
+		}
+		return record, true
+	}
+	return nsecRecord{}, false
+}
+
+// covers tells whether a name lies between owner and next, given how they
+// compare. The last record of a zone wraps around to the apex.
+func covers(ownerName int, nameNext int, ownerNext int) bool {
+	if ownerNext < 0 {
+		return ownerName < 0 && nameNext < 0
+	}
+	return ownerName < 0 || nameNext < 0
+}
+
+// closestEncloser returns the longest ancestor of name that exists by the
+// NSEC covering it.
+func closestEncloser(name parser.Name, covering nsecRecord) parser.Name {
+	ce := commonAncestor(name, covering.owner)
+	if next := commonAncestor(name, covering.NextDomain); len(next) > len(ce) {
+		ce = next
+	}
+	return ce
+}
+
+func commonAncestor(a parser.Name, b parser.Name) parser.Name {
+	n := 0
+	for n < min(len(a), len(b)) && a[len(a)-1-n:].Equal(b[len(b)-1-n:]) {
+		n++
+	}
+	return a[len(a)-n:]
+}
+
+func (d denial) nsec3NoData(name parser.Name, t parser.QType) (bool, error) {
+	if record, ok := d.nsec3Matching(name); ok {
+		if record.HasType(t) || record.HasType(parser.CNAME) {
+			return false, fmt.Errorf("%w: the NSEC3 of %s has %s", ErrNoDenial, name, t)
+		}
+		if t != parser.DS && record.HasType(parser.NS) && !record.HasType(parser.SOA) {
+			return false, fmt.Errorf("%w: %s is a delegation", ErrNoDenial, name)
+		}
+		return false, nil
+	}
+	if t == parser.DS {
+		cut, err := d.noDS(name)
+		return cut, err
+	}
+	// a wildcard without the type, RFC 5155 section 8.7
+	ce, insecure, err := d.closestEncloser(name)
+	if err != nil || insecure {
+		return insecure, err
+	}
+	wildcard, _ := ce.Child("*")
+	record, ok := d.nsec3Matching(wildcard)
+	if !ok || record.HasType(t) || record.HasType(parser.CNAME) {
+		return false, fmt.Errorf("%w: no NSEC3 proves %s has no %s", ErrNoDenial, name, t)
+	}
+	return false, nil
+}
+
+// closestEncloser finds the closest encloser proof of RFC 5155 section
+// 8.3: the longest existing ancestor of name and an NSEC3 covering the next
+// closer name below it. It is insecure if the covering NSEC3 has opt-out
+// set, or if the records take too many iterations to check.
+func (d denial) closestEncloser(name parser.Name) (parser.Name, bool, error) {
+	for _, record := range d.nsec3 {
+		if record.Iterations > maxIterations {
+			return nil, true, nil
+		}
+	}
+	for i := 1; i <= len(name); i++ {
+		ce := name[i:]
+		record, ok := d.nsec3Matching(ce)
+		if !ok {
+			continue
+		}
+		if record.HasType(parser.DNAME) || record.HasType(parser.NS) && !record.HasType(parser.SOA) {
+			return nil, false, fmt.Errorf("%w: closest encloser %s is a delegation", ErrNoDenial, ce)
+		}
+		covering, ok := d.nsec3Covering(name[i-1:])
+		if !ok {
+			return nil, false, fmt.Errorf("%w: no NSEC3 covers %s", ErrNoDenial, name[i-1:])
+		}
+		return ce, covering.Flags&parser.NSEC3_OPT_OUT != 0, nil
+	}
+	return nil, false, fmt.Errorf("%w: no closest encloser of %s", ErrNoDenial, name)
+}
+
+func (d denial) nsec3Matching(name parser.Name) (nsec3Record, bool) {
+	for _, record := range d.nsec3 {
+		if name.IsSubdomainOf(record.zone) && bytes.Equal(record.hash, d.hashFor(name, record)) {
+			return record, true
+		}
+	}
+	return nsec3Record{}, false
+}
+
+func (d denial) nsec3Covering(name parser.Name) (nsec3Record, bool) {
+	for _, record := range d.nsec3 {
+		if !name.IsSubdomainOf(record.zone) {
+			continue
+		}
+		hash := d.hashFor(name, record)
+		if covers(bytes.Compare(record.hash, hash), bytes.Compare(hash, record.NextHashed), bytes.Compare(record.hash, record.NextHashed)) {
+			return record, true
+		}
+	}
+	return nsec3Record{}, false
+}
+
+func (d denial) hashFor(name parser.Name, record nsec3Record) []byte {
+	if record.Iterations > maxIterations {
+		return nil
+	}
+	return HashName(name, record.Salt, record.Iterations)
+}
+
+// HashName returns the NSEC3 hash of name, RFC 5155 section 5.
+func HashName(name parser.Name, salt []byte, iterations uint16) []byte {
+	wire := []byte{}
+	for _, label := range name.Canonical() {
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+	}
+	wire = append(wire, 0)
+	hash := sha1.Sum(append(wire, salt...))
+	for range iterations {
+		hash = sha1.Sum(append(hash[:], salt...))
+	}
+	return hash[:]
+}
@@ -0,0 +1,359 @@
+package dnssec
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+const (
+	// how long a new signature is valid
+	signatureValidity = 7 * 24 * time.Hour
+	// signatures are replaced once they expire in less than this
+	resignBefore = 2 * 24 * time.Hour
+	// the inception lies in the past for resolvers whose clock is behind
+	clockSkew = time.Hour
+	// how often the cache is swept for signatures that are due for renewal
+	sweepInterval = time.Hour
+)
+
+// Signer signs the RRsets of a zone as they are served and synthesizes the
+// NSEC or NSEC3 records of negative answers. Signatures are kept until
+// they are close to expiring. A Signer is not safe for concurrent use.
+type Signer struct {
+	apex parser.Name
+	keys []Key
+	// nil for NSEC
+	nsec3 *parser.NSEC3PARAMRecord
+	// the TTL of NSEC and NSEC3 records, RFC 9077
+	negativeTTL uint32
+	// the names of the zone in canonical order for NSEC, in hash order for
+	// NSEC3
+	chain       []chainName
+	delegations []parser.Name
+	cache       map[string]cachedSignatures
+	nextSweep   time.Time
+	// Now is the time signatures are made at
+	Now func() time.Time
+}
+
+type chainName struct {
+	name  parser.Name
+	hash  []byte
+	types []parser.QType
+}
+
+type cachedSignatures struct {
+	sigs    []parser.Answer
+	refresh time.Time
+}
+
+// NewSigner prepares signing the zone at apex with keys. The records are
+// the zone's data, they have to include the SOA record.
+// Denial uses NSEC3 with the parameters of nsec3, or NSEC if it is nil.
+func NewSigner(apex parser.Name, keys []Key, records []parser.Answer, nsec3 *parser.NSEC3PARAMRecord) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys for %s", ErrBadKey, apex)
+	}
+	for _, key := range keys {
+		if !key.Owner.Equal(apex) || key.signer == nil {
+			return nil, fmt.Errorf("%w: key %d can't sign %s", ErrBadKey, key.DNSKEY.KeyTag(), apex)
+		}
+	}
+	if nsec3 != nil && (nsec3.HashAlgorithm != parser.NSEC3_SHA1 || nsec3.Iterations > maxIterations) {
+		return nil, fmt.Errorf("%w: NSEC3 parameters %s", ErrUnsupportedAlgorithm, nsec3)
+	}
+	s := &Signer{apex: apex, keys: keys, nsec3: nsec3, cache: map[string]cachedSignatures{}, Now: time.Now}
+
+	hasSOA := false
+	for _, record := range records {
+		if soa, ok := record.Data.(parser.SOARecord); ok && record.Name.Equal(apex) {
+			s.negativeTTL = min(record.TTL, soa.Minimum)
+			hasSOA = true
+		}
+		if record.Type == parser.NS && !record.Name.Equal(apex) && record.Name.IsSubdomainOf(apex) {
+			s.delegations = append(s.delegations, record.Name)
+		}
+	}
+	if !hasSOA {
+		return nil, fmt.Errorf("%w: %s has no SOA record", ErrBadKey, apex)
+	}
+
+	types := map[string]*chainName{}
+	add := func(name parser.Name, t parser.QType) {
+		key := name.Canonical().String()
+		if types[key] == nil {
+			types[key] = &chainName{name: name}
+		}
+		if t != 0 {
+			types[key].types = append(types[key].types, t)
+		}
+	}
+	for _, record := range slices.Concat(records, s.ApexRecords()) {
+		if record.Type == parser.RRSIG || record.Type == parser.NSEC || record.Type == parser.NSEC3 || !s.authoritative(record.Name) {
+			continue
+		}
+		add(record.Name, record.Type)
+	}
+	for _, entry := range types {
+		// a delegation without DS has nothing signed, RFC 5155 section 7.1
+		if nsec3 == nil || slices.Contains(entry.types, parser.DS) || !s.delegation(entry.name) {
+			entry.types = append(entry.types, parser.RRSIG)
+		}
+		if nsec3 == nil {
+			entry.types = append(entry.types, parser.NSEC)
+		}
+	}
+	if nsec3 != nil {
+		// empty non-terminals get an NSEC3 record too, RFC 5155 section 7.1
+		for _, entry := range types {
+			for name := entry.name.Parent(); len(name) > len(apex); name = name.Parent() {
+				add(name, 0)
+			}
+		}
+	}
+
+	for _, entry := range types {
+		slices.Sort(entry.types)
+		entry.types = slices.Compact(entry.types)
+		if nsec3 != nil {
+			entry.hash = HashName(entry.name, nsec3.Salt, nsec3.Iterations)
+		}
+		s.chain = append(s.chain, *entry)
+	}
+	slices.SortFunc(s.chain, func(a, b chainName) int {
+		if nsec3 != nil {
+			return bytes.Compare(a.hash, b.hash)
+		}
+		return a.name.Compare(b.name)
+	})
+	return s, nil
+}
+
+// ApexRecords returns the records the signer adds to the zone: the DNSKEY
+// records of its keys and the NSEC3PARAM record.
+func (s *Signer) ApexRecords() []parser.Answer {
+	records := []parser.Answer{}
+	for _, key := range s.keys {
+		records = append(records, key.Record())
+	}
+	if s.nsec3 != nil {
+		params := *s.nsec3
+		params.Flags = 0
+		// with the TTL of the NSEC3 records it describes
+		records = append(records, parser.Answer{Name: s.apex, Type: parser.NSEC3PARAM, Class: parser.IN, TTL: s.negativeTTL, Data: params})
+	}
+	return records
+}
+
+// delegation tells whether name is the owner of a delegation.
+func (s *Signer) delegation(name parser.Name) bool {
+	return slices.ContainsFunc(s.delegations, name.Equal)
+}
+
+// authoritative tells whether the zone holds the data of name, which is not
+// the case outside it and for glue below a delegation.
+func (s *Signer) authoritative(name parser.Name) bool {
+	if !name.IsSubdomainOf(s.apex) {
+		return false
+	}
+	for _, delegation := range s.delegations {
+		if name.IsSubdomainOf(delegation) && !name.Equal(delegation) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sign returns the RRSIG records of rrset, nil for the NS records and glue
+// of delegations which are not signed, RFC 4035 section 2.2.
+func (s *Signer) Sign(rrset []parser.Answer) ([]parser.Answer, error) {
+	if len(rrset) == 0 {
+		return nil, nil
+	}
+	owner, t := rrset[0].Name, rrset[0].Type
+	if !s.authoritative(owner) || t == parser.NS && s.delegation(owner) || t == parser.RRSIG {
+		return nil, nil
+	}
+
+	records := make([]string, 0, len(rrset))
+	for _, record := range rrset {
+		records = append(records, record.String())
+	}
+	slices.Sort(records)
+	key := owner.Canonical().String() + " " + t.String() + "\n" + strings.Join(records, "\n")
+	now := s.Now()
+	if cached, ok := s.cache[key]; ok && now.Before(cached.refresh) {
+		return cached.sigs, nil
+	}
+
+	// the KSKs sign the DNSKEY RRset and the ZSKs everything else, a single
+	// kind of key signs both
+	sep := t == parser.DNSKEY
+	keys := []Key{}
+	for _, key := range s.keys {
+		if (key.DNSKEY.Flags&parser.DNSKEY_SEP != 0) == sep {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		keys = s.keys
+	}
+	inception, expiration := now.Add(-clockSkew), now.Add(signatureValidity)
+	sigs := []parser.Answer{}
+	for _, key := range keys {
+		sig, err := key.Sign(rrset, inception, expiration)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+	s.sweep(now)
+	s.cache[key] = cachedSignatures{sigs: sigs, refresh: expiration.Add(-resignBefore)}
+	return sigs, nil
+}
+
+// Cached returns how many RRsets have signatures in the cache.
+func (s *Signer) Cached() int {
+	return len(s.cache)
+}
+
+// sweep drops the signatures that would be replaced anyway, so RRsets that
+// are no longer asked for don't stay in the cache.
+func (s *Signer) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(sweepInterval)
+	for key, cached := range s.cache {
+		if !now.Before(cached.refresh) {
+			delete(s.cache, key)
+		}
+	}
+}
+
+// Deny returns the signed NSEC or NSEC3 records of a negative answer for
+// name, RFC 4035 section 3.1.3 and RFC 5155 section 7.2: the record of name
+// whose type bitmap shows what is missing, or the proof that name doesn't
+// exist and no wildcard could have created it. If a wildcard matches name,
+// it is the proof that name doesn't exist and the record of the wildcard,
+// for answers expanded from it or missing in it.
+func (s *Signer) Deny(name parser.Name) ([]parser.Answer, error) {
+	if !name.IsSubdomainOf(s.apex) {
+		return nil, fmt.Errorf("%w: %s is not in %s", ErrNoDenial, name, s.apex)
+	}
+	var indices []int
+	if s.nsec3 == nil {
+		indices = s.nsecProof(name)
+	} else {
+		indices = s.nsec3Proof(name)
+	}
+	slices.Sort(indices)
+	indices = slices.Compact(indices)
+
+	proof := []parser.Answer{}
+	for _, i := range indices {
+		record := s.denialRecord(i)
+		sigs, err := s.Sign([]parser.Answer{record})
+		if err != nil {
+			return nil, err
+		}
+		proof = append(proof, record)
+		proof = append(proof, sigs...)
+	}
+	return proof, nil
+}
+
+func (s *Signer) nsecProof(name parser.Name) []int {
+	if i := slices.IndexFunc(s.chain, func(entry chainName) bool { return entry.name.Equal(name) }); i >= 0 {
+		return []int{i}
+	}
+	// an empty non-terminal is covered by the NSEC before its descendants
+	ce := s.closestEncloser(name)
+	if len(ce) == len(name) {
+		return []int{s.nsecCovering(name)}
+	}
+	wildcard, _ := ce.Child("*")
+	if i := slices.IndexFunc(s.chain, func(entry chainName) bool { return entry.name.Equal(wildcard) }); i >= 0 {
+		return []int{s.nsecCovering(name), i}
+	}
+	return []int{s.nsecCovering(name), s.nsecCovering(wildcard)}
+}
+
+func (s *Signer) nsecCovering(name parser.Name) int {
+	i, _ := slices.BinarySearchFunc(s.chain, name, func(entry chainName, name parser.Name) int {
+		return entry.name.Compare(name)
+	})
+	// the apex sorts first and is at or above every name
+	return max(i-1, 0)
+}
+
+func (s *Signer) nsec3Proof(name parser.Name) []int {
+	if i, ok := s.nsec3Matching(name); ok {
+		return []int{i}
+	}
+	// the closest encloser proof, RFC 5155 section 7.2.1
+	ce := s.closestEncloser(name)
+	i, _ := s.nsec3Matching(ce)
+	wildcard, _ := ce.Child("*")
+	nextCloser := s.nsec3Covering(name[len(name)-len(ce)-1:])
+	if j, ok := s.nsec3Matching(wildcard); ok {
+		return []int{i, nextCloser, j}
+	}
+	return []int{i, nextCloser, s.nsec3Covering(wildcard)}
+}
+
+func (s *Signer) nsec3Matching(name parser.Name) (int, bool) {
+	hash := HashName(name, s.nsec3.Salt, s.nsec3.Iterations)
+	return slices.BinarySearchFunc(s.chain, hash, func(entry chainName, hash []byte) int {
+		return bytes.Compare(entry.hash, hash)
+	})
+}
+
+func (s *Signer) nsec3Covering(name parser.Name) int {
+	i, _ := s.nsec3Matching(name)
+	// hashes before the first are covered by the last record
+	if i == 0 {
+		return len(s.chain) - 1
+	}
+	return i - 1
+}
+
+// closestEncloser returns the longest ancestor of name, or name itself,
+// that has records or descendants with records.
+func (s *Signer) closestEncloser(name parser.Name) parser.Name {
+	for i := range len(name) - len(s.apex) {
+		ancestor := name[i:]
+		if slices.ContainsFunc(s.chain, func(entry chainName) bool { return entry.name.IsSubdomainOf(ancestor) }) {
+			return ancestor
+		}
+	}
+	return s.apex
+}
+
+// denialRecord returns the NSEC or NSEC3 record of the i-th name in the
+// chain, pointing to the next one.
+func (s *Signer) denialRecord(i int) parser.Answer {
+	entry, next := s.chain[i], s.chain[(i+1)%len(s.chain)]
+	if s.nsec3 == nil {
+		return parser.Answer{
+			Name: entry.name, Type: parser.NSEC, Class: parser.IN, TTL: s.negativeTTL,
+			Data: parser.NSECRecord{NextDomain: next.name, Types: entry.types},
+		}
+	}
+	owner := append(parser.Name{strings.ToLower(base32Hex.EncodeToString(entry.hash))}, s.apex...)
+	return parser.Answer{
+		Name: owner, Type: parser.NSEC3, Class: parser.IN, TTL: s.negativeTTL,
+		Data: parser.NSEC3Record{
+			HashAlgorithm: s.nsec3.HashAlgorithm,
+			Iterations:    s.nsec3.Iterations,
+			Salt:          s.nsec3.Salt,
+			NextHashed:    next.hash,
+			Types:         entry.types,
+		},
+	}
+}
@@ -0,0 +1,679 @@
+// Package dnssec validates DNS responses, RFC 4033 to 4035 and RFC 5155.
+// The chain of trust is built from configured trust anchors through DS and
+// DNSKEY records down to the signatures of the RRsets in a response.
+package dnssec
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+var (
+	ErrBadSignature         = errors.New("bad signature")
+	ErrSignatureTime        = errors.New("signature not valid at this time")
+	ErrBadKey               = errors.New("malformed public key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrNoSignature          = errors.New("missing signature")
+	ErrNoKey                = errors.New("no key matches the DS records")
+	ErrNoDenial             = errors.New("missing proof of nonexistence")
+	ErrNoTrustAnchor        = errors.New("no trust anchor")
+	ErrLookup               = errors.New("lookup failed")
+)
+
+// results that no signature or TTL bounds, like failures, are checked again
+// after this
+const failureLifetime = time.Minute
+
+// base32 with the extended hex alphabet, RFC 5155 section 3.3
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// Status is the outcome of validation, RFC 4033 section 5 and RFC 4035
+// section 4.3.
+type Status int
+
+const (
+	// there is no trust anchor for the data, or the records needed to
+	// decide could not be fetched
+	Indeterminate Status = iota
+	// the chain of trust reaches the data
+	Secure
+	// a signed parent proves that the data comes from an unsigned zone
+	Insecure
+	// the data should be signed but the signatures or proofs are missing
+	// or wrong
+	Bogus
+)
+
+func (status Status) String() string {
+	switch status {
+	case Secure:
+		return "secure"
+	case Insecure:
+		return "insecure"
+	case Bogus:
+		return "bogus"
+	}
+	return "indeterminate"
+}
+
+// Result is the status of a response together with the reason it isn't
+// secure.
+type Result struct {
+	Status Status
+	Err    error
+	// Expires is when the result has to be checked again: the earliest end
+	// of the signatures and TTLs it depends on
+	Expires time.Time
+}
+
+// worse orders the status by how little can be trusted.
+func (result Result) worse(other Result) bool {
+	rank := map[Status]int{Secure: 0, Insecure: 1, Indeterminate: 2, Bogus: 3}
+	return rank[result.Status] > rank[other.Status]
+}
+
+// combine returns the worst of results, it expires with the first of them.
+func combine(results ...Result) Result {
+	worst := Result{Status: Secure}
+	var expires time.Time
+	for _, result := range results {
+		if result.worse(worst) {
+			worst = result
+		}
+		expires = earliest(expires, result.Expires)
+	}
+	worst.Expires = expires
+	return worst
+}
+
+// earliest returns the earlier of two times, the zero time is no bound.
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || !b.IsZero() && b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// expiry is when an RRset validated with sig has to be checked again: when
+// its TTL or the signature runs out, RFC 4035 section 5.3.3.
+func expiry(set rrset, sig parser.RRSIGRecord, now time.Time) time.Time {
+	ttl := sig.OriginalTTL
+	for _, record := range set.records {
+		ttl = min(ttl, record.TTL)
+	}
+	// serial number arithmetic, RFC 4034 section 3.1.5
+	end := now.Add(time.Duration(int32(sig.Expiration-uint32(now.Unix()))) * time.Second)
+	return earliest(now.Add(time.Duration(ttl)*time.Second), end)
+}
+
+// Resolver looks up the DS and DNSKEY records the validator needs. The
+// response has to include the signatures, and for missing records the
+// NSEC or NSEC3 records proving it.
+type Resolver interface {
+	Lookup(name parser.Name, t parser.QType) (parser.Message, error)
+}
+
+// the DS records of the root KSK-2017 and KSK-2024, as published by IANA
+var rootAnchors = []string{
+	". 0 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 0 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// RootAnchors returns the trust anchors of the root zone.
+func RootAnchors() []parser.Answer {
+	anchors := []parser.Answer{}
+	for _, text := range rootAnchors {
+		anchor, err := parser.ParseRecord(text)
+		if err != nil {
+			panic(err)
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors
+}
+
+// Validator checks responses against its trust anchors. The keys and
+// delegations of every zone are kept until the signatures or TTLs they were
+// validated with run out. A Validator is not safe for concurrent use.
+type Validator struct {
+	resolver Resolver
+	// DS or DNSKEY records, each trusted for its owner
+	anchors []parser.Answer
+	// Now is the time signatures have to be valid at
+	Now func() time.Time
+
+	zones     map[string]zoneKeys
+	cuts      map[string]cut
+	nextSweep time.Time
+}
+
+type zoneKeys struct {
+	keys   []parser.DNSKEYRecord
+	result Result
+}
+
+// cut is what the parent knows about a name: the DS records if it is a
+// signed delegation, or whether it is an unsigned delegation.
+type cut struct {
+	ds       []parser.DSRecord
+	unsigned bool
+	result   Result
+}
+
+// lifetime is how long a result can stay in the cache.
+func (v *Validator) lifetime(result Result) time.Time {
+	if result.Expires.IsZero() {
+		return v.Now().Add(failureLifetime)
+	}
+	return result.Expires
+}
+
+// sweep drops the keys and delegations that expired, so zones that are no
+// longer asked for don't stay in the cache.
+func (v *Validator) sweep(now time.Time) {
+	if now.Before(v.nextSweep) {
+		return
+	}
+	v.nextSweep = now.Add(sweepInterval)
+	for key, keys := range v.zones {
+		if !now.Before(keys.result.Expires) {
+			delete(v.zones, key)
+		}
+	}
+	for key, known := range v.cuts {
+		if !now.Before(known.result.Expires) {
+			delete(v.cuts, key)
+		}
+	}
+}
+
+func NewValidator(resolver Resolver, anchors []parser.Answer) *Validator {
+	return &Validator{
+		resolver: resolver,
+		anchors:  anchors,
+		Now:      time.Now,
+		zones:    map[string]zoneKeys{},
+		cuts:     map[string]cut{},
+	}
+}
+
+// rrset is the records with the same owner, type and class, with the
+// signatures covering them.
+type rrset struct {
+	records []parser.Answer
+	sigs    []parser.RRSIGRecord
+}
+
+func (set rrset) name() parser.Name   { return set.records[0].Name }
+func (set rrset) qtype() parser.QType { return set.records[0].Type }
+
+// rrsets groups answers into RRsets. Signatures without their RRset and
+// the OPT record are left out.
+func rrsets(answers []parser.Answer) []rrset {
+	sets := []rrset{}
+	find := func(name parser.Name, t parser.QType, class parser.QClass) int {
+		for i, set := range sets {
+			if set.name().Equal(name) && set.qtype() == t && set.records[0].Class == class {
+				return i
+			}
+		}
+		return -1
+	}
+	for _, answer := range answers {
+		if answer.Type == parser.RRSIG || answer.Type == parser.OPT {
+			continue
+		}
+		if i := find(answer.Name, answer.Type, answer.Class); i >= 0 {
+			sets[i].records = append(sets[i].records, answer)
+			continue
+		}
+		sets = append(sets, rrset{records: []parser.Answer{answer}})
+	}
+	for _, answer := range answers {
+		sig, ok := answer.Data.(parser.RRSIGRecord)
+		if !ok {
+			continue
+		}
+		if i := find(answer.Name, sig.TypeCovered, answer.Class); i >= 0 {
+			sets[i].sigs = append(sets[i].sigs, sig)
+		}
+	}
+	return sets
+}
+
+func findRRset(sets []rrset, name parser.Name, t parser.QType) (rrset, bool) {
+	for _, set := range sets {
+		if set.name().Equal(name) && set.qtype() == t {
+			return set, true
+		}
+	}
+	return rrset{}, false
+}
+
+// Validate classifies the response to its question. Every RRset of the
+// answer has to validate, and if the answer doesn't end in the type asked
+// for, the authority section has to prove why.
+func (v *Validator) Validate(response parser.Message) Result {
+	v.sweep(v.Now())
+	result := v.validate(response)
+	result.Expires = v.lifetime(result)
+	return result
+}
+
+func (v *Validator) validate(response parser.Message) Result {
+	if len(response.Questions) != 1 {
+		return Result{Status: Indeterminate, Err: fmt.Errorf("%w: response has %d questions", ErrLookup, len(response.Questions))}
+	}
+	question := response.Questions[0]
+	if _, ok := v.anchorAbove(question.Name); !ok {
+		return Result{Status: Indeterminate, Err: fmt.Errorf("%w: for %s", ErrNoTrustAnchor, question.Name)}
+	}
+
+	answers := rrsets(response.Answers)
+	authority := rrsets(response.Authority)
+	results := []Result{}
+	var proven *denial
+	var provenResult Result
+	proofs := func(name parser.Name) (*denial, Result) {
+		if proven == nil {
+			d, result := v.denialFrom(authority, name)
+			proven, provenResult = &d, result
+			results = append(results, result)
+		}
+		return proven, provenResult
+	}
+
+	for _, set := range answers {
+		if set.qtype() == parser.CNAME && len(set.sigs) == 0 && synthesized(answers, set.name()) {
+			continue
+		}
+		result, ce := v.validateRRset(set, false)
+		results = append(results, result)
+		// an answer expanded from a wildcard needs proof that the name
+		// itself doesn't exist
+		if result.Status == Secure && ce != nil {
+			if d, proof := proofs(set.name()); proof.Status == Secure {
+				results = append(results, denialResult(d.wildcard(set.name(), ce)))
+			}
+		}
+	}
+	if question.Type == parser.ALL && len(answers) > 0 {
+		return combine(results...)
+	}
+
+	// follow the CNAMEs to the name the answer is for
+	name := question.Name
+	for range len(answers) + 1 {
+		if _, ok := findRRset(answers, name, question.Type); ok {
+			return combine(results...)
+		}
+		set, ok := findRRset(answers, name, parser.CNAME)
+		if !ok {
+			break
+		}
+		name = set.records[0].Data.(parser.CNAMERecord).Target
+	}
+
+	if response.Header.ResponseCode == parser.NO_ERROR && len(answers) == 0 {
+		if ns, ok := referral(authority); ok {
+			return combine(append(results, v.validateReferral(ns, authority))...)
+		}
+	}
+
+	d, proof := proofs(name)
+	if proof.Status != Secure {
+		return combine(results...)
+	}
+	switch response.Header.ResponseCode {
+	case parser.NAME_ERROR:
+		results = append(results, denialResult(d.nxDomain(name)))
+	case parser.NO_ERROR:
+		results = append(results, denialResult(d.noData(name, question.Type)))
+	}
+	return combine(results...)
+}
+
+func denialResult(insecure bool, err error) Result {
+	switch {
+	case err != nil:
+		return Result{Status: Bogus, Err: err}
+	case insecure:
+		return Result{Status: Insecure, Err: fmt.Errorf("%w: opt-out or too many NSEC3 iterations", ErrNoDenial)}
+	}
+	return Result{Status: Secure}
+}
+
+// synthesized tells whether the CNAME at name was made from a DNAME of the
+// answer, such CNAMEs are not signed, RFC 6672 section 5.3.1.
+func synthesized(answers []rrset, name parser.Name) bool {
+	for _, set := range answers {
+		if set.qtype() == parser.DNAME && name.IsSubdomainOf(set.name()) && !name.Equal(set.name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// referral returns the NS RRset of a delegation in the authority section.
+func referral(authority []rrset) (rrset, bool) {
+	ns := rrset{}
+	found := false
+	for _, set := range authority {
+		switch set.qtype() {
+		case parser.SOA:
+			return rrset{}, false
+		case parser.NS:
+			ns, found = set, true
+		}
+	}
+	return ns, found
+}
+
+// validateReferral checks that a delegation is signed by its DS records or
+// proven to be unsigned.
+func (v *Validator) validateReferral(ns rrset, authority []rrset) Result {
+	if ds, ok := findRRset(authority, ns.name(), parser.DS); ok {
+		result, _ := v.validateRRset(ds, true)
+		return result
+	}
+	d, result := v.denialFrom(authority, ns.name())
+	if result.Status != Secure {
+		return result
+	}
+	if _, err := d.noDS(ns.name()); err != nil {
+		return Result{Status: Bogus, Err: err}
+	}
+	return Result{Status: Insecure, Err: fmt.Errorf("%w: %s is an unsigned delegation", ErrNoSignature, ns.name())}
+}
+
+// denialFrom validates the NSEC and NSEC3 records of the authority section.
+// Without any, the zone of name has to be proven unsigned.
+func (v *Validator) denialFrom(authority []rrset, name parser.Name) (denial, Result) {
+	d := denial{}
+	results := []Result{}
+	for _, set := range authority {
+		if set.qtype() != parser.NSEC && set.qtype() != parser.NSEC3 {
+			continue
+		}
+		result, _ := v.validateRRset(set, false)
+		if result.Status == Secure {
+			d.add(set.records)
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return d, v.unsigned(name)
+	}
+	return d, combine(results...)
+}
+
+// validateRRset checks the signatures of set with the keys of their
+// signers. Unless signed is set, a set without signatures is insecure if it
+// is in a zone below an unsigned delegation. For an answer expanded from a
+// wildcard it returns the name the wildcard was at, without the *.
+func (v *Validator) validateRRset(set rrset, signed bool) (Result, parser.Name) {
+	if len(set.sigs) == 0 {
+		if signed {
+			return Result{Status: Bogus, Err: fmt.Errorf("%w: %s %s", ErrNoSignature, set.name(), set.qtype())}, nil
+		}
+		return v.unsigned(set.name()), nil
+	}
+	result := Result{Status: Bogus, Err: fmt.Errorf("%w: %s %s", ErrNoSignature, set.name(), set.qtype())}
+	for _, sig := range set.sigs {
+		// DS records belong to the parent, RFC 4035 section 5.2
+		if set.qtype() == parser.DS && sig.SignerName.Equal(set.name()) || !set.name().IsSubdomainOf(sig.SignerName) {
+			continue
+		}
+		keys := v.zoneKeys(sig.SignerName)
+		if keys.result.Status == Insecure || keys.result.Status == Indeterminate {
+			return keys.result, nil
+		}
+		if keys.result.Status != Secure {
+			result = keys.result
+			continue
+		}
+		for _, key := range keys.keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			err := Verify(set.records, sig, key, v.Now())
+			if err == nil {
+				expires := earliest(expiry(set, sig, v.Now()), keys.result.Expires)
+				return Result{Status: Secure, Expires: expires}, wildcardEncloser(set.name(), sig)
+			}
+			result = Result{Status: Bogus, Err: fmt.Errorf("%s %s: %w", set.name(), set.qtype(), err)}
+		}
+	}
+	return result, nil
+}
+
+func wildcardEncloser(name parser.Name, sig parser.RRSIGRecord) parser.Name {
+	labels := len(name)
+	if labels > 0 && name[0] == "*" {
+		labels--
+	}
+	if int(sig.Labels) >= labels {
+		return nil
+	}
+	return name[len(name)-int(sig.Labels):]
+}
+
+// anchorAbove returns the owner of the closest trust anchor at or above
+// name.
+func (v *Validator) anchorAbove(name parser.Name) (parser.Name, bool) {
+	var closest parser.Name
+	found := false
+	for _, anchor := range v.anchors {
+		if name.IsSubdomainOf(anchor.Name) && (!found || len(anchor.Name) > len(closest)) {
+			closest, found = anchor.Name, true
+		}
+	}
+	return closest, found
+}
+
+// zoneKeys returns the trusted keys of zone, RFC 4035 section 5.2.
+func (v *Validator) zoneKeys(zone parser.Name) zoneKeys {
+	key := zone.Canonical().String()
+	if keys, ok := v.zones[key]; ok && v.Now().Before(keys.result.Expires) {
+		return keys
+	}
+	// a chain that comes back to this zone is broken
+	v.zones[key] = zoneKeys{result: Result{Status: Bogus, Err: fmt.Errorf("%w: loop at %s", ErrNoKey, zone), Expires: v.Now().Add(failureLifetime)}}
+	keys := v.fetchZoneKeys(zone)
+	keys.result.Expires = v.lifetime(keys.result)
+	v.zones[key] = keys
+	return keys
+}
+
+func (v *Validator) fetchZoneKeys(zone parser.Name) zoneKeys {
+	ds := []parser.DSRecord{}
+	anchorKeys := []parser.DNSKEYRecord{}
+	anchored := false
+	// the keys can't be trusted longer than the DS records they match
+	var dsExpires time.Time
+	for _, anchor := range v.anchors {
+		if !anchor.Name.Equal(zone) {
+			continue
+		}
+		anchored = true
+		switch data := anchor.Data.(type) {
+		case parser.DSRecord:
+			ds = append(ds, data)
+		case parser.DNSKEYRecord:
+			anchorKeys = append(anchorKeys, data)
+		}
+	}
+	if !anchored {
+		if _, ok := v.anchorAbove(zone); !ok {
+			return zoneKeys{result: Result{Status: Indeterminate, Err: fmt.Errorf("%w: for %s", ErrNoTrustAnchor, zone)}}
+		}
+		delegation := v.delegation(zone)
+		switch {
+		case delegation.result.Status != Secure:
+			return zoneKeys{result: delegation.result}
+		case delegation.unsigned:
+			return zoneKeys{result: Result{Status: Insecure, Err: fmt.Errorf("%w: %s is an unsigned delegation", ErrNoSignature, zone), Expires: delegation.result.Expires}}
+		case len(delegation.ds) == 0:
+			return zoneKeys{result: Result{Status: Bogus, Err: fmt.Errorf("%w: %s is not a zone", ErrNoKey, zone)}}
+		}
+		ds = delegation.ds
+		dsExpires = delegation.result.Expires
+	}
+
+	// DS records of algorithms we can't check make the zone insecure, RFC
+	// 4035 section 5.2
+	usable := []parser.DSRecord{}
+	for _, record := range ds {
+		if Supported(record.Algorithm) && (record.DigestType == parser.SHA1 || record.DigestType == parser.SHA256 || record.DigestType == parser.SHA384) {
+			usable = append(usable, record)
+		}
+	}
+	if len(usable) == 0 && len(anchorKeys) == 0 {
+		return zoneKeys{result: Result{Status: Insecure, Err: fmt.Errorf("%w: no DS record of %s can be used", ErrUnsupportedAlgorithm, zone)}}
+	}
+
+	response, err := v.resolver.Lookup(zone, parser.DNSKEY)
+	if err != nil {
+		return zoneKeys{result: Result{Status: Indeterminate, Err: fmt.Errorf("%w: %s DNSKEY: %w", ErrLookup, zone, err)}}
+	}
+	set, ok := findRRset(rrsets(response.Answers), zone, parser.DNSKEY)
+	if !ok {
+		return zoneKeys{result: Result{Status: Bogus, Err: fmt.Errorf("%w: %s has no DNSKEY", ErrNoKey, zone)}}
+	}
+	keys := []parser.DNSKEYRecord{}
+	for _, record := range set.records {
+		key := record.Data.(parser.DNSKEYRecord)
+		if key.Flags&parser.DNSKEY_ZONE != 0 && key.Flags&parser.DNSKEY_REVOKE == 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	result := Result{Status: Bogus, Err: fmt.Errorf("%w: %s", ErrNoKey, zone)}
+	for _, key := range keys {
+		if !matches(zone, key, usable, anchorKeys) {
+			continue
+		}
+		// the key signing key has to sign the DNSKEY RRset
+		for _, sig := range set.sigs {
+			if !sig.SignerName.Equal(zone) || sig.KeyTag != key.KeyTag() || sig.Algorithm != key.Algorithm {
+				continue
+			}
+			err := Verify(set.records, sig, key, v.Now())
+			if err == nil {
+				return zoneKeys{keys: keys, result: Result{Status: Secure, Expires: earliest(expiry(set, sig, v.Now()), dsExpires)}}
+			}
+			result = Result{Status: Bogus, Err: fmt.Errorf("%s DNSKEY: %w", zone, err)}
+		}
+	}
+	return zoneKeys{result: result}
+}
+
+// matches tells whether key is one of the anchor keys or has one of the DS
+// records.
+func matches(zone parser.Name, key parser.DNSKEYRecord, ds []parser.DSRecord, anchorKeys []parser.DNSKEYRecord) bool {
+	for _, anchor := range anchorKeys {
+		if anchor.Algorithm == key.Algorithm && string(anchor.PublicKey) == string(key.PublicKey) {
+			return true
+		}
+	}
+	for _, record := range ds {
+		if record.KeyTag != key.KeyTag() || record.Algorithm != key.Algorithm {
+			continue
+		}
+		digest, err := key.DS(zone, record.DigestType)
+		if err == nil && hex.EncodeToString(digest.Digest) == hex.EncodeToString(record.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// delegation asks the parent of name for its DS records. The answer or the
+// proof that there are none has to be signed.
+func (v *Validator) delegation(name parser.Name) cut {
+	key := name.Canonical().String()
+	if known, ok := v.cuts[key]; ok && v.Now().Before(known.result.Expires) {
+		return known
+	}
+	result := v.fetchDelegation(name)
+	result.result.Expires = v.lifetime(result.result)
+	v.cuts[key] = result
+	return result
+}
+
+func (v *Validator) fetchDelegation(name parser.Name) cut {
+	response, err := v.resolver.Lookup(name, parser.DS)
+	if err != nil {
+		return cut{result: Result{Status: Indeterminate, Err: fmt.Errorf("%w: %s DS: %w", ErrLookup, name, err)}}
+	}
+	answers := rrsets(response.Answers)
+	if set, ok := findRRset(answers, name, parser.DS); ok {
+		result, _ := v.validateRRset(set, true)
+		if result.Status != Secure {
+			return cut{result: result}
+		}
+		ds := []parser.DSRecord{}
+		for _, record := range set.records {
+			ds = append(ds, record.Data.(parser.DSRecord))
+		}
+		return cut{ds: ds, result: result}
+	}
+
+	d := denial{}
+	results := []Result{}
+	for _, set := range rrsets(response.Authority) {
+		if set.qtype() != parser.NSEC && set.qtype() != parser.NSEC3 {
+			continue
+		}
+		// the proof comes from the parent, not the zone at name
+		for _, sig := range set.sigs {
+			if sig.SignerName.Equal(name) && len(name) > 0 {
+				return cut{result: Result{Status: Bogus, Err: fmt.Errorf("%w: DS of %s denied by itself", ErrNoDenial, name)}}
+			}
+		}
+		result, _ := v.validateRRset(set, true)
+		if result.Status == Secure {
+			d.add(set.records)
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return cut{result: Result{Status: Bogus, Err: fmt.Errorf("%w: no DS for %s", ErrNoDenial, name)}}
+	}
+	proof := combine(results...)
+	if proof.Status != Secure {
+		return cut{result: proof}
+	}
+	// the denial holds as long as the NSEC or NSEC3 records it comes from
+	if response.Header.ResponseCode == parser.NAME_ERROR {
+		insecure, err := d.nxDomain(name)
+		return cut{unsigned: insecure, result: combine(proof, denialResult(false, err))}
+	}
+	unsigned, err := d.noDS(name)
+	return cut{unsigned: unsigned, result: combine(proof, denialResult(false, err))}
+}
+
+// unsigned decides about data without signatures: it is insecure if an
+// unsigned delegation between the trust anchor and name is proven, bogus
+// otherwise.
+func (v *Validator) unsigned(name parser.Name) Result {
+	anchor, ok := v.anchorAbove(name)
+	if !ok {
+		return Result{Status: Indeterminate, Err: fmt.Errorf("%w: for %s", ErrNoTrustAnchor, name)}
+	}
+	for i := len(name) - len(anchor) - 1; i >= 0; i-- {
+		delegation := v.delegation(name[i:])
+		if delegation.result.Status != Secure {
+			return delegation.result
+		}
+		if delegation.unsigned {
+			return Result{Status: Insecure, Err: fmt.Errorf("%w: %s is an unsigned delegation", ErrNoSignature, name[i:])}
+		}
+	}
+	return Result{Status: Bogus, Err: fmt.Errorf("%w: %s", ErrNoSignature, name)}
+}
@@ -0,0 +1,160 @@
+package dnssec
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+// Supported tells whether signatures of algorithm can be verified. RSAMD5,
+// DSA and GOST are no longer to be validated, RFC 8624 section 3.1, Ed448
+// is not in the standard library.
+func Supported(algorithm parser.Algorithm) bool {
+	switch algorithm {
+	case parser.RSASHA1, parser.RSASHA1_NSEC3_SHA1, parser.RSASHA256, parser.RSASHA512,
+		parser.ECDSAP256SHA256, parser.ECDSAP384SHA384, parser.ED25519:
+		return true
+	}
+	return false
+}
+
+// Verify checks that sig is a valid signature of rrset by key at the time
+// now, RFC 4035 section 5.3.
+func Verify(rrset []parser.Answer, sig parser.RRSIGRecord, key parser.DNSKEYRecord, now time.Time) error {
+	if len(rrset) == 0 {
+		return fmt.Errorf("%w: empty RRset", ErrBadSignature)
+	}
+	if sig.TypeCovered != rrset[0].Type {
+		return fmt.Errorf("%w: signature covers %s, not %s", ErrBadSignature, sig.TypeCovered, rrset[0].Type)
+	}
+	if !rrset[0].Name.IsSubdomainOf(sig.SignerName) {
+		return fmt.Errorf("%w: %s can't sign %s", ErrBadSignature, sig.SignerName, rrset[0].Name)
+	}
+	if key.Flags&parser.DNSKEY_ZONE == 0 || key.Flags&parser.DNSKEY_REVOKE != 0 || key.Protocol != 3 {
+		return fmt.Errorf("%w: key %d is not a zone key", ErrBadSignature, key.KeyTag())
+	}
+	if sig.Algorithm != key.Algorithm || sig.KeyTag != key.KeyTag() {
+		return fmt.Errorf("%w: signature is not by key %d", ErrBadSignature, key.KeyTag())
+	}
+	// serial number arithmetic, RFC 4034 section 3.1.5
+	t := uint32(now.Unix())
+	if int32(t-sig.Inception) < 0 {
+		return fmt.Errorf("%w: valid from %d", ErrSignatureTime, sig.Inception)
+	}
+	if int32(sig.Expiration-t) < 0 {
+		return fmt.Errorf("%w: expired at %d", ErrSignatureTime, sig.Expiration)
+	}
+
+	data, err := parser.SignedData(sig, rrset)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadSignature, err)
+	}
+	return verifySignature(key, data, sig.Signature)
+}
+
+func verifySignature(key parser.DNSKEYRecord, data []byte, signature []byte) error {
+	switch key.Algorithm {
+	case parser.RSASHA1, parser.RSASHA1_NSEC3_SHA1, parser.RSASHA256, parser.RSASHA512:
+		public, err := rsaPublicKey(key.PublicKey)
+		if err != nil {
+			return err
+		}
+		hash := crypto.SHA1
+		switch key.Algorithm {
+		case parser.RSASHA256:
+			hash = crypto.SHA256
+		case parser.RSASHA512:
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(data)
+		if err := rsa.VerifyPKCS1v15(public, hash, h.Sum(nil), signature); err != nil {
+			return fmt.Errorf("%w: %w", ErrBadSignature, err)
+		}
+		return nil
+	case parser.ECDSAP256SHA256, parser.ECDSAP384SHA384:
+		public, hash, err := ecdsaPublicKey(key.Algorithm, key.PublicKey)
+		if err != nil {
+			return err
+		}
+		// r and s of the size of the curve, RFC 6605 section 4
+		size := public.Curve.Params().BitSize / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("%w: signature of %d octets", ErrBadSignature, len(signature))
+		}
+		h := hash.New()
+		h.Write(data)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(public, h.Sum(nil), r, s) {
+			return ErrBadSignature
+		}
+		return nil
+	case parser.ED25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("%w: Ed25519 key of %d octets", ErrBadKey, len(key.PublicKey))
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key.PublicKey), data, signature) {
+			return ErrBadSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, key.Algorithm)
+}
+
+// rsaPublicKey decodes the exponent and modulus of RFC 3110 section 2.
+func rsaPublicKey(key []byte) (*rsa.PublicKey, error) {
+	if len(key) < 1 {
+		return nil, fmt.Errorf("%w: empty RSA key", ErrBadKey)
+	}
+	length := int(key[0])
+	key = key[1:]
+	if length == 0 {
+		if len(key) < 2 {
+			return nil, fmt.Errorf("%w: short RSA key", ErrBadKey)
+		}
+		length = int(key[0])<<8 | int(key[1])
+		key = key[2:]
+	}
+	if length == 0 || length > 4 || len(key) <= length {
+		return nil, fmt.Errorf("%w: RSA exponent of %d octets", ErrBadKey, length)
+	}
+	exponent := 0
+	for _, b := range key[:length] {
+		exponent = exponent<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(key[length:]), E: exponent}, nil
+}
+
+// ecdsaPublicKey decodes the point of RFC 6605 section 4, x and y without
+// the uncompressed point prefix.
+func ecdsaPublicKey(algorithm parser.Algorithm, key []byte) (*ecdsa.PublicKey, crypto.Hash, error) {
+	curve, check, hash := elliptic.P256(), ecdh.P256(), crypto.SHA256
+	if algorithm == parser.ECDSAP384SHA384 {
+		curve, check, hash = elliptic.P384(), ecdh.P384(), crypto.SHA384
+	}
+	size := curve.Params().BitSize / 8
+	if len(key) != 2*size {
+		return nil, 0, fmt.Errorf("%w: ECDSA key of %d octets", ErrBadKey, len(key))
+	}
+	// ecdh makes sure the point is on the curve
+	if _, err := check.NewPublicKey(append([]byte{4}, key...)); err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrBadKey, err)
+	}
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(key[:size]),
+		Y:     new(big.Int).SetBytes(key[size:]),
+	}, hash, nil
+}
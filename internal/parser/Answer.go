@@ -1,82 +1,65 @@
 package parser
 
-import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	"strings"
-)
+import "fmt"
 
 type Answer struct {
-	Labels []string
-	Type   QType
-	Class  QClass
-	TTL    uint32
-	Data   []byte
+	Name  Name
+	Type  QType
+	Class QClass
+	TTL   uint32
+	Data  RData
 }
 
-func ParseAnswer(buffer *MessageBuffer) Answer {
-	labels, _ := buffer.ReadLabels()
-	t := QType(buffer.ReadUint16())
-	class := buffer.ReadUint16()
-	ttl := buffer.ReadUint32()
-	length := buffer.ReadUint16()
-	var data []byte
-
-	switch t {
-	case NS:
-		l, _ := buffer.ReadLabels()
-		buf := new(bytes.Buffer)
-		for i := 0; i < len(l); i++ {
-			size := byte(len(l[i]))
-			binary.Write(buf, binary.BigEndian, size)
-			buf.Write([]byte(l[i]))
-		}
-		buf.WriteByte(0)
-		data = buf.Bytes()
-
-	default:
-		data = make([]byte, length)
-		buffer.Read(data)
+func ParseAnswer(buffer *MessageBuffer) (Answer, error) {
+	name, err := buffer.ReadName()
+	if err != nil {
+		return Answer{}, err
 	}
-	return Answer{
-		Labels: labels,
-		Type:   t,
-		Class:  QClass(class),
-		TTL:    ttl,
-		Data:   data,
+	var fields struct {
+		Type   uint16
+		Class  uint16
+		TTL    uint32
+		Length uint16
 	}
-}
-
-func (answer Answer) ToBinary() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	for i := 0; i < len(answer.Labels); i++ {
-		size := byte(len(answer.Labels[i]))
-		if err := binary.Write(buf, binary.BigEndian, size); err != nil {
-			return nil, err
-		}
-		buf.Write([]byte(answer.Labels[i]))
+	if fields.Type, err = buffer.ReadUint16(); err != nil {
+		return Answer{}, err
 	}
-	buf.WriteByte(0)
-	if err := binary.Write(buf, binary.BigEndian, answer.Type); err != nil {
-		return nil, err
+	if fields.Class, err = buffer.ReadUint16(); err != nil {
+		return Answer{}, err
 	}
-	if err := binary.Write(buf, binary.BigEndian, answer.Class); err != nil {
-		return nil, err
+	if fields.TTL, err = buffer.ReadUint32(); err != nil {
+		return Answer{}, err
 	}
-	if err := binary.Write(buf, binary.BigEndian, answer.TTL); err != nil {
-		return nil, err
+	if fields.Length, err = buffer.ReadUint16(); err != nil {
+		return Answer{}, err
+	}
+	data, err := parseRData(QType(fields.Type), buffer, int(fields.Length))
+	if err != nil {
+		return Answer{}, err
 	}
-	length := uint16(len(answer.Data))
-	if err := binary.Write(buf, binary.BigEndian, length); err != nil {
+	return Answer{
+		Name:  name,
+		Type:  QType(fields.Type),
+		Class: QClass(fields.Class),
+		TTL:   fields.TTL,
+		Data:  data,
+	}, nil
+}
+
+// ToBinary encodes the record on its own, so no name is compressed.
+func (answer Answer) ToBinary() ([]byte, error) {
+	encoder := &messageEncoder{}
+	if err := encoder.writeAnswer(answer); err != nil {
 		return nil, err
 	}
-	buf.Write(answer.Data)
-	return buf.Bytes(), nil
+	return encoder.buf, nil
 }
 
+// String returns the record in presentation format, RFC 1035 section 5.1.
 func (answer Answer) String() string {
-	full := strings.Join(answer.Labels, ".")
-	return fmt.Sprintf("%s ttl: %d type: %d class: %d data: %s", full, answer.TTL, answer.Type, answer.Class, string(answer.Data))
-
+	data := `\# 0`
+	if answer.Data != nil {
+		data = answer.Data.String()
+	}
+	return fmt.Sprintf("%s %d %s %s %s", answer.Name, answer.TTL, answer.Class, answer.Type, data)
 }
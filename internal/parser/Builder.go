@@ -0,0 +1,69 @@
+package parser
+
+import "math/rand/v2"
+
+// NewQuery starts a query for name and t in class IN with a random ID. The
+// methods below can be chained to fill in the rest.
+func NewQuery(name Name, t QType) *Message {
+	message := &Message{
+		Header: Header{
+			ID:      uint16(rand.Uint32()),
+			IsQuery: true,
+		},
+	}
+	return message.AddQuestion(Question{Name: name, Type: t, Class: IN})
+}
+
+// Reply starts the response to message. ID, OPCODE, questions and the RD
+// and CD flags are taken from the request.
+func (message Message) Reply() *Message {
+	reply := &Message{
+		Header: Header{
+			ID:               message.Header.ID,
+			OPCODE:           message.Header.OPCODE,
+			RecursionDesired: message.Header.RecursionDesired,
+			CheckingDisabled: message.Header.CheckingDisabled,
+		},
+	}
+	return reply.AddQuestion(message.Questions...)
+}
+
+func (message *Message) AddQuestion(questions ...Question) *Message {
+	message.Questions = append(message.Questions, questions...)
+	message.syncCounts()
+	return message
+}
+
+func (message *Message) AddAnswer(answers ...Answer) *Message {
+	message.Answers = append(message.Answers, answers...)
+	message.syncCounts()
+	return message
+}
+
+func (message *Message) AddAuthority(answers ...Answer) *Message {
+	message.Authority = append(message.Authority, answers...)
+	message.syncCounts()
+	return message
+}
+
+func (message *Message) AddAdditional(answers ...Answer) *Message {
+	message.Additional = append(message.Additional, answers...)
+	message.syncCounts()
+	return message
+}
+
+// SetRcode sets the full response code, Pack moves the upper bits into the
+// OPT record.
+func (message *Message) SetRcode(rcode RCODE) *Message {
+	message.Header.ResponseCode = rcode
+	return message
+}
+
+// syncCounts makes the header counts agree with the sections. Pack does the
+// same, this only keeps a message that is still being built consistent.
+func (message *Message) syncCounts() {
+	message.Header.QuestionCount = uint16(len(message.Questions))
+	message.Header.AnswerCount = uint16(len(message.Answers))
+	message.Header.NSCount = uint16(len(message.Authority))
+	message.Header.ARCount = uint16(len(message.Additional))
+}
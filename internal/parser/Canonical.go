@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// the types whose names are lower cased in canonical form, RFC 4034
+// section 6.2 without NSEC, RFC 6840 section 5.1
+var lowercaseTypes = map[QType]bool{
+	NS:    true,
+	MD:    true,
+	MF:    true,
+	CNAME: true,
+	SOA:   true,
+	MB:    true,
+	MG:    true,
+	MR:    true,
+	PTR:   true,
+	MX:    true,
+	SRV:   true,
+	DNAME: true,
+	RRSIG: true,
+}
+
+// CanonicalRData returns the RDATA in canonical form, RFC 4034 section 6.2:
+// uncompressed and with the names of older types in lower case.
+func CanonicalRData(data RData) ([]byte, error) {
+	e := &messageEncoder{lowercase: lowercaseTypes[data.Type()]}
+	if err := data.pack(e); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// SignedData returns the octets the signature of sig covers, RFC 4034
+// section 3.1.8.1: the RRSIG RDATA without the signature, followed by the
+// records of rrset in canonical form and order. The owner of a record that
+// was expanded from a wildcard is replaced by the wildcard, RFC 4035 section
+// 5.3.2.
+func SignedData(sig RRSIGRecord, rrset []Answer) ([]byte, error) {
+	if len(rrset) == 0 {
+		return nil, fmt.Errorf("%w: empty RRset", ErrSyntax)
+	}
+	e := &messageEncoder{lowercase: true}
+	if err := sig.packUnsigned(e); err != nil {
+		return nil, err
+	}
+
+	owner := rrset[0].Name.Canonical()
+	// the labels field doesn't count a leading wildcard
+	labels := len(owner)
+	if labels > 0 && owner[0] == "*" {
+		labels--
+	}
+	if int(sig.Labels) > labels {
+		return nil, fmt.Errorf("%w: RRSIG has %d labels, the owner %d", ErrSyntax, sig.Labels, labels)
+	}
+	if int(sig.Labels) < labels {
+		owner = append(Name{"*"}, owner[len(owner)-int(sig.Labels):]...)
+	}
+
+	rdatas := make([][]byte, 0, len(rrset))
+	for _, record := range rrset {
+		if !record.Name.Equal(rrset[0].Name) || record.Type != rrset[0].Type || record.Class != rrset[0].Class {
+			return nil, fmt.Errorf("%w: %s is not part of the RRset", ErrSyntax, record.Name)
+		}
+		if record.Data == nil {
+			rdatas = append(rdatas, nil)
+			continue
+		}
+		rdata, err := CanonicalRData(record.Data)
+		if err != nil {
+			return nil, err
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	// RDATA sorts as left justified octets, duplicates count once, RFC 4034
+	// section 6.3
+	slices.SortFunc(rdatas, bytes.Compare)
+	rdatas = slices.CompactFunc(rdatas, bytes.Equal)
+
+	for _, rdata := range rdatas {
+		if len(rdata) > 0xffff {
+			return nil, ErrBadRDataLength
+		}
+		if err := e.writeUncompressedName(owner); err != nil {
+			return nil, err
+		}
+		e.writeUint16(uint16(rrset[0].Type))
+		e.writeUint16(uint16(rrset[0].Class))
+		e.writeUint32(sig.OriginalTTL)
+		e.writeUint16(uint16(len(rdata)))
+		e.buf = append(e.buf, rdata...)
+	}
+	return e.buf, nil
+}
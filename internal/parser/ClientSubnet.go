@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	CLIENT_SUBNET EDNSOptionCode = 8
+)
+
+// address families from the IANA registry used by RFC 7871
+const (
+	familyIPv4 = 1
+	familyIPv6 = 2
+)
+
+// ClientSubnet is the EDNS Client Subnet option of RFC 7871. Prefix holds
+// the address together with the source prefix length.
+type ClientSubnet struct {
+	Prefix netip.Prefix
+	Scope  uint8
+}
+
+func ParseClientSubnet(option EDNSOption) (ClientSubnet, error) {
+	data := option.Data
+	if option.Code != CLIENT_SUBNET || len(data) < 4 {
+		return ClientSubnet{}, ErrBadClientSubnet
+	}
+	family := binary.BigEndian.Uint16(data)
+	source := int(data[2])
+	scope := data[3]
+	address := data[4:]
+
+	var full []byte
+	switch family {
+	case familyIPv4:
+		full = make([]byte, 4)
+	case familyIPv6:
+		full = make([]byte, 16)
+	default:
+		return ClientSubnet{}, ErrBadClientSubnet
+	}
+	// the address must be cut to exactly the octets the prefix covers
+	if source > len(full)*8 || int(scope) > len(full)*8 || len(address) != (source+7)/8 {
+		return ClientSubnet{}, ErrBadClientSubnet
+	}
+	copy(full, address)
+	addr, _ := netip.AddrFromSlice(full)
+	prefix := netip.PrefixFrom(addr, source)
+	// and bits beyond the prefix must be zero
+	if prefix.Masked() != prefix {
+		return ClientSubnet{}, ErrBadClientSubnet
+	}
+	return ClientSubnet{Prefix: prefix, Scope: scope}, nil
+}
+
+func (subnet ClientSubnet) Option() (EDNSOption, error) {
+	if !subnet.Prefix.IsValid() || int(subnet.Scope) > subnet.Prefix.Addr().BitLen() {
+		return EDNSOption{}, ErrBadClientSubnet
+	}
+	prefix := subnet.Prefix.Masked()
+	addr := prefix.Addr().Unmap()
+	family := familyIPv6
+	if addr.Is4() {
+		family = familyIPv4
+		if prefix.Addr().Is4In6() {
+			// a v4 mapped prefix counts the bits of the v6 form
+			prefix = netip.PrefixFrom(addr, max(prefix.Bits()-96, 0))
+		}
+	}
+
+	data := binary.BigEndian.AppendUint16(nil, uint16(family))
+	data = append(data, byte(prefix.Bits()), subnet.Scope)
+	data = append(data, addr.AsSlice()[:(prefix.Bits()+7)/8]...)
+	return EDNSOption{Code: CLIENT_SUBNET, Data: data}, nil
+}
@@ -0,0 +1,37 @@
+package parser
+
+const (
+	COOKIE EDNSOptionCode = 10
+)
+
+// Cookie is the DNS Cookie option of RFC 7873. Server is empty when the
+// client doesn't know a server cookie yet.
+type Cookie struct {
+	Client [8]byte
+	Server []byte
+}
+
+func ParseCookie(option EDNSOption) (Cookie, error) {
+	data := option.Data
+	if option.Code != COOKIE {
+		return Cookie{}, ErrBadCookie
+	}
+	// a server cookie has between 8 and 32 octets
+	if len(data) != 8 && (len(data) < 16 || len(data) > 40) {
+		return Cookie{}, ErrBadCookie
+	}
+	cookie := Cookie{}
+	copy(cookie.Client[:], data)
+	if len(data) > 8 {
+		cookie.Server = append([]byte{}, data[8:]...)
+	}
+	return cookie, nil
+}
+
+func (cookie Cookie) Option() (EDNSOption, error) {
+	if len(cookie.Server) != 0 && (len(cookie.Server) < 8 || len(cookie.Server) > 32) {
+		return EDNSOption{}, ErrBadCookie
+	}
+	data := append(cookie.Client[:], cookie.Server...)
+	return EDNSOption{Code: COOKIE, Data: data}, nil
+}
@@ -0,0 +1,763 @@
+package parser
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Algorithm is a DNSSEC algorithm number of the IANA registry, RFC 8624.
+type Algorithm uint8
+
+const (
+	RSAMD5             Algorithm = 1
+	DSA                Algorithm = 3
+	RSASHA1            Algorithm = 5
+	DSA_NSEC3_SHA1     Algorithm = 6
+	RSASHA1_NSEC3_SHA1 Algorithm = 7
+	RSASHA256          Algorithm = 8
+	RSASHA512          Algorithm = 10
+	ECC_GOST           Algorithm = 12
+	ECDSAP256SHA256    Algorithm = 13
+	ECDSAP384SHA384    Algorithm = 14
+	ED25519            Algorithm = 15
+	ED448              Algorithm = 16
+)
+
+var algorithmNames = map[Algorithm]string{
+	RSAMD5:             "RSAMD5",
+	DSA:                "DSA",
+	RSASHA1:            "RSASHA1",
+	DSA_NSEC3_SHA1:     "DSA-NSEC3-SHA1",
+	RSASHA1_NSEC3_SHA1: "RSASHA1-NSEC3-SHA1",
+	RSASHA256:          "RSASHA256",
+	RSASHA512:          "RSASHA512",
+	ECC_GOST:           "ECC-GOST",
+	ECDSAP256SHA256:    "ECDSAP256SHA256",
+	ECDSAP384SHA384:    "ECDSAP384SHA384",
+	ED25519:            "ED25519",
+	ED448:              "ED448",
+}
+
+func (algorithm Algorithm) String() string {
+	if name, ok := algorithmNames[algorithm]; ok {
+		return name
+	}
+	return strconv.Itoa(int(algorithm))
+}
+
+// DigestType is the hash of a DS record, RFC 4509 and RFC 6605.
+type DigestType uint8
+
+const (
+	SHA1   DigestType = 1
+	SHA256 DigestType = 2
+	SHA384 DigestType = 4
+)
+
+// flags of the DNSKEY record, RFC 4034 section 2.1.1 and RFC 5011
+const (
+	DNSKEY_ZONE   uint16 = 0x0100
+	DNSKEY_REVOKE uint16 = 0x0080
+	DNSKEY_SEP    uint16 = 0x0001
+)
+
+// NSEC3 hash algorithm and flags, RFC 5155 section 11
+const (
+	NSEC3_SHA1    uint8 = 1
+	NSEC3_OPT_OUT uint8 = 0x01
+)
+
+// base32 with the extended hex alphabet, RFC 5155 section 3.3
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// DNSKEYRecord is the public key of a zone, RFC 4034 section 2.
+type DNSKEYRecord struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm Algorithm
+	PublicKey []byte
+}
+
+func parseDNSKEYRecord(buffer *MessageBuffer, length int) (RData, error) {
+	flags, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	protocol, err := buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	return DNSKEYRecord{
+		Flags:     flags,
+		Protocol:  protocol,
+		Algorithm: Algorithm(algorithm),
+		PublicKey: buffer.rest(),
+	}, nil
+}
+
+func (record DNSKEYRecord) Type() QType { return DNSKEY }
+
+func (record DNSKEYRecord) pack(e *messageEncoder) error {
+	e.writeUint16(record.Flags)
+	e.buf = append(e.buf, record.Protocol, byte(record.Algorithm))
+	e.buf = append(e.buf, record.PublicKey...)
+	return nil
+}
+
+func (record DNSKEYRecord) String() string {
+	return fmt.Sprintf("%d %d %d %s", record.Flags, record.Protocol, record.Algorithm, base64.StdEncoding.EncodeToString(record.PublicKey))
+}
+
+func parseDNSKEYRecordText(fields []string, origin Name) (RData, error) {
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("%w: DNSKEY needs at least 4 fields, got %d", ErrSyntax, len(fields))
+	}
+	flags, err := parseUint16(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	protocol, err := parseUint8(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := parseUint8(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseBase64(fields[3:])
+	if err != nil {
+		return nil, err
+	}
+	return DNSKEYRecord{Flags: flags, Protocol: protocol, Algorithm: Algorithm(algorithm), PublicKey: key}, nil
+}
+
+// KeyTag identifies the key in RRSIG and DS records, RFC 4034 appendix B.
+func (record DNSKEYRecord) KeyTag() uint16 {
+	if record.Algorithm == RSAMD5 {
+		// the third to last and second to last octet of the modulus
+		key := record.PublicKey
+		if len(key) < 3 {
+			return 0
+		}
+		return uint16(key[len(key)-3])<<8 | uint16(key[len(key)-2])
+	}
+	e := &messageEncoder{}
+	record.pack(e)
+	sum := uint32(0)
+	for i, b := range e.buf {
+		if i%2 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16 & 0xffff
+	return uint16(sum)
+}
+
+// DS returns the DS record for the key with the given owner name, RFC 4034
+// section 5.1.4: the digest covers the canonical owner name and the RDATA.
+func (record DNSKEYRecord) DS(owner Name, digestType DigestType) (DSRecord, error) {
+	e := &messageEncoder{}
+	if err := e.writeUncompressedName(owner.Canonical()); err != nil {
+		return DSRecord{}, err
+	}
+	record.pack(e)
+	var digest []byte
+	switch digestType {
+	case SHA1:
+		sum := sha1.Sum(e.buf)
+		digest = sum[:]
+	case SHA256:
+		sum := sha256.Sum256(e.buf)
+		digest = sum[:]
+	case SHA384:
+		sum := sha512.Sum384(e.buf)
+		digest = sum[:]
+	default:
+		return DSRecord{}, fmt.Errorf("%w: %d", ErrUnknownDigest, digestType)
+	}
+	return DSRecord{
+		KeyTag:     record.KeyTag(),
+		Algorithm:  record.Algorithm,
+		DigestType: digestType,
+		Digest:     digest,
+	}, nil
+}
+
+// DSRecord refers to a DNSKEY of the child zone, RFC 4034 section 5.
+type DSRecord struct {
+	KeyTag     uint16
+	Algorithm  Algorithm
+	DigestType DigestType
+	Digest     []byte
+}
+
+func parseDSRecord(buffer *MessageBuffer, length int) (RData, error) {
+	tag, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	digestType, err := buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	return DSRecord{
+		KeyTag:     tag,
+		Algorithm:  Algorithm(algorithm),
+		DigestType: DigestType(digestType),
+		Digest:     buffer.rest(),
+	}, nil
+}
+
+func (record DSRecord) Type() QType { return DS }
+
+func (record DSRecord) pack(e *messageEncoder) error {
+	e.writeUint16(record.KeyTag)
+	e.buf = append(e.buf, byte(record.Algorithm), byte(record.DigestType))
+	e.buf = append(e.buf, record.Digest...)
+	return nil
+}
+
+func (record DSRecord) String() string {
+	return fmt.Sprintf("%d %d %d %X", record.KeyTag, record.Algorithm, record.DigestType, record.Digest)
+}
+
+func parseDSRecordText(fields []string, origin Name) (RData, error) {
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("%w: DS needs at least 4 fields, got %d", ErrSyntax, len(fields))
+	}
+	tag, err := parseUint16(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := parseUint8(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	digestType, err := parseUint8(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	digest, err := hex.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad DS digest: %s", ErrSyntax, err)
+	}
+	return DSRecord{KeyTag: tag, Algorithm: Algorithm(algorithm), DigestType: DigestType(digestType), Digest: digest}, nil
+}
+
+// RRSIGRecord is the signature of an RRset, RFC 4034 section 3. Expiration
+// and Inception are seconds since the epoch in serial number arithmetic.
+type RRSIGRecord struct {
+	TypeCovered QType
+	Algorithm   Algorithm
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  Name
+	Signature   []byte
+}
+
+func parseRRSIGRecord(buffer *MessageBuffer, length int) (RData, error) {
+	covered, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	labels, err := buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var numbers [3]uint32
+	for i := range numbers {
+		if numbers[i], err = buffer.ReadUint32(); err != nil {
+			return nil, err
+		}
+	}
+	tag, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := buffer.ReadName()
+	if err != nil {
+		return nil, err
+	}
+	return RRSIGRecord{
+		TypeCovered: QType(covered),
+		Algorithm:   Algorithm(algorithm),
+		Labels:      labels,
+		OriginalTTL: numbers[0],
+		Expiration:  numbers[1],
+		Inception:   numbers[2],
+		KeyTag:      tag,
+		SignerName:  signer,
+		Signature:   buffer.rest(),
+	}, nil
+}
+
+func (record RRSIGRecord) Type() QType { return RRSIG }
+
+// RFC 4034 section 3.1.7 forbids compressing the signer name
+func (record RRSIGRecord) pack(e *messageEncoder) error {
+	if err := record.packUnsigned(e); err != nil {
+		return err
+	}
+	e.buf = append(e.buf, record.Signature...)
+	return nil
+}
+
+// packUnsigned writes the RDATA without the signature, the first part of
+// the data that is signed, RFC 4034 section 3.1.8.1.
+func (record RRSIGRecord) packUnsigned(e *messageEncoder) error {
+	e.writeUint16(uint16(record.TypeCovered))
+	e.buf = append(e.buf, byte(record.Algorithm), record.Labels)
+	e.writeUint32(record.OriginalTTL)
+	e.writeUint32(record.Expiration)
+	e.writeUint32(record.Inception)
+	e.writeUint16(record.KeyTag)
+	return e.writeUncompressedName(record.SignerName)
+}
+
+func (record RRSIGRecord) String() string {
+	return fmt.Sprintf("%s %d %d %d %s %s %d %s %s",
+		record.TypeCovered, record.Algorithm, record.Labels, record.OriginalTTL,
+		formatSignatureTime(record.Expiration), formatSignatureTime(record.Inception),
+		record.KeyTag, record.SignerName.String(), base64.StdEncoding.EncodeToString(record.Signature))
+}
+
+func parseRRSIGRecordText(fields []string, origin Name) (RData, error) {
+	if len(fields) < 9 {
+		return nil, fmt.Errorf("%w: RRSIG needs at least 9 fields, got %d", ErrSyntax, len(fields))
+	}
+	covered, err := ParseQType(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := parseUint8(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	labels, err := parseUint8(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := parseTTL(fields[3])
+	if err != nil {
+		return nil, err
+	}
+	expiration, err := parseSignatureTime(fields[4])
+	if err != nil {
+		return nil, err
+	}
+	inception, err := parseSignatureTime(fields[5])
+	if err != nil {
+		return nil, err
+	}
+	tag, err := parseUint16(fields[6])
+	if err != nil {
+		return nil, err
+	}
+	signer, err := parseName(fields[7], origin)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := parseBase64(fields[8:])
+	if err != nil {
+		return nil, err
+	}
+	return RRSIGRecord{
+		TypeCovered: covered,
+		Algorithm:   Algorithm(algorithm),
+		Labels:      labels,
+		OriginalTTL: ttl,
+		Expiration:  expiration,
+		Inception:   inception,
+		KeyTag:      tag,
+		SignerName:  signer,
+		Signature:   signature,
+	}, nil
+}
+
+// signatureTimeFormat is YYYYMMDDHHmmSS in UTC, RFC 4034 section 3.2
+const signatureTimeFormat = "20060102150405"
+
+func formatSignatureTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format(signatureTimeFormat)
+}
+
+// parseSignatureTime reads the date form or plain seconds since the epoch.
+func parseSignatureTime(s string) (uint32, error) {
+	if len(s) == len(signatureTimeFormat) {
+		t, err := time.Parse(signatureTimeFormat, s)
+		if err != nil || t.Unix() < 0 || t.Unix() > 0xffffffff {
+			return 0, fmt.Errorf("%w: bad signature time %q", ErrSyntax, s)
+		}
+		return uint32(t.Unix()), nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad signature time %q", ErrSyntax, s)
+	}
+	return uint32(n), nil
+}
+
+// NSECRecord names the next owner in the zone and the types at its own,
+// RFC 4034 section 4.
+type NSECRecord struct {
+	NextDomain Name
+	Types      []QType
+}
+
+func parseNSECRecord(buffer *MessageBuffer, length int) (RData, error) {
+	next, err := buffer.ReadName()
+	if err != nil {
+		return nil, err
+	}
+	types, err := readTypeBitmap(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return NSECRecord{NextDomain: next, Types: types}, nil
+}
+
+func (record NSECRecord) Type() QType { return NSEC }
+
+// RFC 4034 section 4.1.1 forbids compressing the next domain name
+func (record NSECRecord) pack(e *messageEncoder) error {
+	if err := e.writeUncompressedName(record.NextDomain); err != nil {
+		return err
+	}
+	e.writeTypeBitmap(record.Types)
+	return nil
+}
+
+func (record NSECRecord) String() string {
+	return strings.TrimSuffix(record.NextDomain.String()+" "+formatTypes(record.Types), " ")
+}
+
+func parseNSECRecordText(fields []string, origin Name) (RData, error) {
+	if len(fields) < 1 {
+		return nil, fmt.Errorf("%w: NSEC needs a next domain name", ErrSyntax)
+	}
+	next, err := parseName(fields[0], origin)
+	if err != nil {
+		return nil, err
+	}
+	types, err := parseTypes(fields[1:])
+	if err != nil {
+		return nil, err
+	}
+	return NSECRecord{NextDomain: next, Types: types}, nil
+}
+
+// HasType tells if the type bitmap contains t.
+func (record NSECRecord) HasType(t QType) bool {
+	return slices.Contains(record.Types, t)
+}
+
+// NSEC3Record is the hashed form of NSEC, RFC 5155 section 3.
+type NSEC3Record struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+	NextHashed    []byte
+	Types         []QType
+}
+
+func parseNSEC3Record(buffer *MessageBuffer, length int) (RData, error) {
+	params, err := readNSEC3Params(buffer)
+	if err != nil {
+		return nil, err
+	}
+	size, err := buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	// RFC 5155 section 3.2 requires a hash
+	if size == 0 {
+		return nil, buffer.errorAt(buffer.off-1, ErrBadRDataLength)
+	}
+	next := make([]byte, size)
+	if _, err := buffer.Read(next); err != nil {
+		return nil, err
+	}
+	types, err := readTypeBitmap(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return NSEC3Record{
+		HashAlgorithm: params.HashAlgorithm,
+		Flags:         params.Flags,
+		Iterations:    params.Iterations,
+		Salt:          params.Salt,
+		NextHashed:    next,
+		Types:         types,
+	}, nil
+}
+
+func (record NSEC3Record) Type() QType { return NSEC3 }
+
+func (record NSEC3Record) pack(e *messageEncoder) error {
+	params := NSEC3PARAMRecord{HashAlgorithm: record.HashAlgorithm, Flags: record.Flags, Iterations: record.Iterations, Salt: record.Salt}
+	if err := params.pack(e); err != nil {
+		return err
+	}
+	if len(record.NextHashed) == 0 || len(record.NextHashed) > 255 {
+		return ErrBadRDataLength
+	}
+	e.buf = append(e.buf, byte(len(record.NextHashed)))
+	e.buf = append(e.buf, record.NextHashed...)
+	e.writeTypeBitmap(record.Types)
+	return nil
+}
+
+func (record NSEC3Record) String() string {
+	s := fmt.Sprintf("%d %d %d %s %s %s", record.HashAlgorithm, record.Flags, record.Iterations,
+		formatSalt(record.Salt), strings.ToLower(base32Hex.EncodeToString(record.NextHashed)), formatTypes(record.Types))
+	return strings.TrimSuffix(s, " ")
+}
+
+func parseNSEC3RecordText(fields []string, origin Name) (RData, error) {
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("%w: NSEC3 needs at least 5 fields, got %d", ErrSyntax, len(fields))
+	}
+	params, err := parseNSEC3Params(fields[:4])
+	if err != nil {
+		return nil, err
+	}
+	next, err := base32Hex.DecodeString(strings.ToUpper(fields[4]))
+	if err != nil || len(next) == 0 || len(next) > 255 {
+		return nil, fmt.Errorf("%w: bad next hashed owner %q", ErrSyntax, fields[4])
+	}
+	types, err := parseTypes(fields[5:])
+	if err != nil {
+		return nil, err
+	}
+	return NSEC3Record{
+		HashAlgorithm: params.HashAlgorithm,
+		Flags:         params.Flags,
+		Iterations:    params.Iterations,
+		Salt:          params.Salt,
+		NextHashed:    next,
+		Types:         types,
+	}, nil
+}
+
+// HasType tells if the type bitmap contains t.
+func (record NSEC3Record) HasType(t QType) bool {
+	return slices.Contains(record.Types, t)
+}
+
+// NSEC3PARAMRecord holds the parameters used to hash the names of a zone,
+// RFC 5155 section 4.
+type NSEC3PARAMRecord struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+}
+
+func parseNSEC3PARAMRecord(buffer *MessageBuffer, length int) (RData, error) {
+	params, err := readNSEC3Params(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func readNSEC3Params(buffer *MessageBuffer) (NSEC3PARAMRecord, error) {
+	var fixed [5]byte
+	if _, err := buffer.Read(fixed[:]); err != nil {
+		return NSEC3PARAMRecord{}, err
+	}
+	salt := make([]byte, fixed[4])
+	if _, err := buffer.Read(salt); err != nil {
+		return NSEC3PARAMRecord{}, err
+	}
+	return NSEC3PARAMRecord{
+		HashAlgorithm: fixed[0],
+		Flags:         fixed[1],
+		Iterations:    uint16(fixed[2])<<8 | uint16(fixed[3]),
+		Salt:          salt,
+	}, nil
+}
+
+func (record NSEC3PARAMRecord) Type() QType { return NSEC3PARAM }
+
+func (record NSEC3PARAMRecord) pack(e *messageEncoder) error {
+	if len(record.Salt) > 255 {
+		return ErrBadRDataLength
+	}
+	e.buf = append(e.buf, record.HashAlgorithm, record.Flags)
+	e.writeUint16(record.Iterations)
+	e.buf = append(e.buf, byte(len(record.Salt)))
+	e.buf = append(e.buf, record.Salt...)
+	return nil
+}
+
+func (record NSEC3PARAMRecord) String() string {
+	return fmt.Sprintf("%d %d %d %s", record.HashAlgorithm, record.Flags, record.Iterations, formatSalt(record.Salt))
+}
+
+func parseNSEC3PARAMRecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(NSEC3PARAM, fields, 4); err != nil {
+		return nil, err
+	}
+	params, err := parseNSEC3Params(fields)
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func parseNSEC3Params(fields []string) (NSEC3PARAMRecord, error) {
+	algorithm, err := parseUint8(fields[0])
+	if err != nil {
+		return NSEC3PARAMRecord{}, err
+	}
+	flags, err := parseUint8(fields[1])
+	if err != nil {
+		return NSEC3PARAMRecord{}, err
+	}
+	iterations, err := parseUint16(fields[2])
+	if err != nil {
+		return NSEC3PARAMRecord{}, err
+	}
+	// an empty salt is written as -, RFC 5155 section 3.3
+	salt := []byte{}
+	if fields[3] != "-" {
+		salt, err = hex.DecodeString(fields[3])
+		if err != nil || len(salt) == 0 || len(salt) > 255 {
+			return NSEC3PARAMRecord{}, fmt.Errorf("%w: bad salt %q", ErrSyntax, fields[3])
+		}
+	}
+	return NSEC3PARAMRecord{HashAlgorithm: algorithm, Flags: flags, Iterations: iterations, Salt: salt}, nil
+}
+
+func formatSalt(salt []byte) string {
+	if len(salt) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%X", salt)
+}
+
+// readTypeBitmap reads the type bitmap that ends NSEC and NSEC3 RDATA, RFC
+// 4034 section 4.1.2. Windows have to be in increasing order and may not
+// have trailing zero octets, so every bitmap has one encoding.
+func readTypeBitmap(buffer *MessageBuffer) ([]QType, error) {
+	types := []QType{}
+	last := -1
+	for buffer.off < len(buffer.buf) {
+		start := buffer.off
+		window, err := buffer.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := buffer.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if int(window) <= last || size == 0 || size > 32 {
+			return nil, buffer.errorAt(start, ErrBadTypeBitmap)
+		}
+		last = int(window)
+		bitmap := buffer.buf[buffer.off:min(buffer.off+int(size), len(buffer.buf))]
+		if len(bitmap) < int(size) {
+			return nil, buffer.errorAt(buffer.off, ErrTruncatedMessage)
+		}
+		if bitmap[size-1] == 0 {
+			return nil, buffer.errorAt(start, ErrBadTypeBitmap)
+		}
+		buffer.off += int(size)
+		for i, b := range bitmap {
+			for bit := range 8 {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, QType(int(window)<<8|i*8+bit))
+				}
+			}
+		}
+	}
+	return types, nil
+}
+
+// writeTypeBitmap writes the types in any order as a type bitmap.
+func (e *messageEncoder) writeTypeBitmap(types []QType) {
+	sorted := slices.Clone(types)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+	for len(sorted) > 0 {
+		window := sorted[0] >> 8
+		var bitmap [32]byte
+		size := 0
+		for len(sorted) > 0 && sorted[0]>>8 == window {
+			low := int(sorted[0] & 0xff)
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			size = low/8 + 1
+			sorted = sorted[1:]
+		}
+		e.buf = append(e.buf, byte(window), byte(size))
+		e.buf = append(e.buf, bitmap[:size]...)
+	}
+}
+
+func formatTypes(types []QType) string {
+	names := make([]string, 0, len(types))
+	for _, t := range types {
+		names = append(names, t.String())
+	}
+	return strings.Join(names, " ")
+}
+
+// parseTypes reads the types of a bitmap, sorted and without duplicates.
+func parseTypes(fields []string) ([]QType, error) {
+	types := make([]QType, 0, len(fields))
+	for _, field := range fields {
+		t, err := ParseQType(field)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return slices.Compact(types), nil
+}
+
+// parseBase64 reads base64 that may be split into several fields.
+func parseBase64(fields []string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(fields, ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad base64: %s", ErrSyntax, err)
+	}
+	return data, nil
+}
+
+func parseUint8(s string) (uint8, error) {
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad number %q", ErrSyntax, s)
+	}
+	return uint8(n), nil
+}
@@ -0,0 +1,166 @@
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type EDNSOptionCode uint16
+
+type EDNSOption struct {
+	Code EDNSOptionCode
+	Data []byte
+}
+
+// OPTRecord is the RDATA of the OPT pseudo-record, RFC 6891 section 6.1.2.
+type OPTRecord struct {
+	Options []EDNSOption
+}
+
+func parseOPTRecord(buffer *MessageBuffer, length int) (RData, error) {
+	record := OPTRecord{}
+	for buffer.off < len(buffer.buf) {
+		code, err := buffer.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		size, err := buffer.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size)
+		if _, err := buffer.Read(data); err != nil {
+			return nil, err
+		}
+		record.Options = append(record.Options, EDNSOption{Code: EDNSOptionCode(code), Data: data})
+	}
+	return record, nil
+}
+
+func (record OPTRecord) Type() QType { return OPT }
+
+func (record OPTRecord) pack(e *messageEncoder) error {
+	for _, option := range record.Options {
+		if len(option.Data) > 0xffff {
+			return ErrBadRDataLength
+		}
+		e.writeUint16(uint16(option.Code))
+		e.writeUint16(uint16(len(option.Data)))
+		e.buf = append(e.buf, option.Data...)
+	}
+	return nil
+}
+
+// parseOPTRecordText reads the options in the form of OPTRecord.String.
+func parseOPTRecordText(fields []string, origin Name) (RData, error) {
+	record := OPTRecord{}
+	for _, field := range fields {
+		code, data, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: option %q is not code:hex", ErrSyntax, field)
+		}
+		n, err := parseUint16(code)
+		if err != nil {
+			return nil, err
+		}
+		b, err := hex.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad option data %q", ErrSyntax, data)
+		}
+		record.Options = append(record.Options, EDNSOption{Code: EDNSOptionCode(n), Data: b})
+	}
+	return record, nil
+}
+
+func (record OPTRecord) String() string {
+	options := []string{}
+	for _, option := range record.Options {
+		options = append(options, fmt.Sprintf("%d:%x", option.Code, option.Data))
+	}
+	return strings.Join(options, " ")
+}
+
+// EDNS is the content of the OPT pseudo-record. The extended part of the
+// response code is not part of it, Parse and Pack move it in and out of
+// Header.ResponseCode.
+type EDNS struct {
+	UDPSize  uint16
+	Version  uint8
+	DNSSECOK bool
+	// the flag bits after DO, must be zero
+	Z       uint16
+	Options []EDNSOption
+}
+
+const dnssecOK = 1 << 15
+
+func ednsFromAnswer(answer Answer) EDNS {
+	edns := EDNS{
+		UDPSize:  uint16(answer.Class),
+		Version:  uint8(answer.TTL >> 16),
+		DNSSECOK: answer.TTL&dnssecOK != 0,
+		Z:        uint16(answer.TTL) &^ dnssecOK,
+	}
+	if record, ok := answer.Data.(OPTRecord); ok {
+		edns.Options = record.Options
+	}
+	return edns
+}
+
+func (edns EDNS) answer() Answer {
+	ttl := uint32(edns.Version)<<16 | uint32(edns.Z&^dnssecOK)
+	if edns.DNSSECOK {
+		ttl |= dnssecOK
+	}
+	return Answer{
+		Name:  Name{},
+		Type:  OPT,
+		Class: QClass(edns.UDPSize),
+		TTL:   ttl,
+		Data:  OPTRecord{Options: edns.Options},
+	}
+}
+
+// Option returns the first option with the given code.
+func (edns EDNS) Option(code EDNSOptionCode) (EDNSOption, bool) {
+	for _, option := range edns.Options {
+		if option.Code == code {
+			return option, true
+		}
+	}
+	return EDNSOption{}, false
+}
+
+// EDNS returns the content of the OPT record of the message, if it has one.
+func (message Message) EDNS() (EDNS, bool) {
+	for _, answer := range message.Additional {
+		if answer.Type == OPT {
+			return ednsFromAnswer(answer), true
+		}
+	}
+	return EDNS{}, false
+}
+
+// SetEDNS adds an OPT record to the message or replaces the existing one.
+func (message *Message) SetEDNS(edns EDNS) *Message {
+	for i, answer := range message.Additional {
+		if answer.Type == OPT {
+			message.Additional[i] = edns.answer()
+			return message
+		}
+	}
+	return message.AddAdditional(edns.answer())
+}
+
+// RemoveEDNS drops the OPT record of the message.
+func (message *Message) RemoveEDNS() {
+	additional := []Answer{}
+	for _, answer := range message.Additional {
+		if answer.Type != OPT {
+			additional = append(additional, answer)
+		}
+	}
+	message.Additional = additional
+	message.syncCounts()
+}
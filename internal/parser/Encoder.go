@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// pointers can only address the first 16 KiB of a message
+const maxPointerOffset = 0b00111111_11111111
+
+// messageEncoder writes a message while remembering where each name was
+// written, so that later names can point to it. Unless compress is set
+// nothing is compressed.
+type messageEncoder struct {
+	buf []byte
+	// where the message starts in buf, pointers are relative to it
+	base     int
+	compress bool
+	// names are written in lower case, for the canonical form of RDATA
+	lowercase bool
+	// where the labels of earlier names start, each is the start of a
+	// suffix a later name can point to
+	names []int
+}
+
+// encoders are reused, so that packing into a large enough buffer doesn't
+// allocate at all
+var encoders = sync.Pool{
+	New: func() any {
+		return &messageEncoder{names: make([]int, 0, 64)}
+	},
+}
+
+func newMessageEncoder(dst []byte) *messageEncoder {
+	e := encoders.Get().(*messageEncoder)
+	e.buf = dst
+	e.base = len(dst)
+	e.compress = true
+	e.lowercase = false
+	e.names = e.names[:0]
+	return e
+}
+
+// release hands the encoder back to the pool, e.buf must no longer be used
+// afterwards.
+func (e *messageEncoder) release() {
+	e.buf = nil
+	encoders.Put(e)
+}
+
+func (e *messageEncoder) writeUint16(n uint16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, n)
+}
+
+func (e *messageEncoder) writeUint32(n uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, n)
+}
+
+// writeName writes labels, replacing the longest suffix that was written
+// before by a pointer to it. Suffixes are matched exactly, so the case of a
+// name is preserved.
+func (e *messageEncoder) writeName(labels Name) error {
+	if err := labels.Validate(); err != nil {
+		return err
+	}
+	if !e.compress {
+		return e.writeUncompressedName(labels)
+	}
+
+	// the suffixes of this name can only be pointed to once it is complete
+	known := e.names
+	for i := range labels {
+		if offset, ok := e.findSuffix(known, labels[i:]); ok {
+			e.writeUint16(0b11000000_00000000 | uint16(offset-e.base))
+			return nil
+		}
+		if len(e.buf)-e.base <= maxPointerOffset {
+			e.names = append(e.names, len(e.buf))
+		}
+		e.buf = append(e.buf, byte(len(labels[i])))
+		e.buf = append(e.buf, labels[i]...)
+	}
+	e.buf = append(e.buf, 0)
+	return nil
+}
+
+// findSuffix returns where in buf an earlier name that is exactly suffix
+// starts.
+func (e *messageEncoder) findSuffix(names []int, suffix Name) (int, bool) {
+	for _, offset := range names {
+		if e.nameAt(offset, suffix) {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// nameAt tells whether the name written at offset is labels. The name was
+// written by us, so it is well formed and its pointers go backwards.
+func (e *messageEncoder) nameAt(offset int, labels Name) bool {
+	for _, label := range labels {
+		for e.buf[offset]&0b11000000 == 0b11000000 {
+			offset = e.base + int(binary.BigEndian.Uint16(e.buf[offset:])&maxPointerOffset)
+		}
+		length := int(e.buf[offset])
+		if length != len(label) || string(e.buf[offset+1:offset+1+length]) != label {
+			return false
+		}
+		offset += 1 + length
+	}
+	for e.buf[offset]&0b11000000 == 0b11000000 {
+		offset = e.base + int(binary.BigEndian.Uint16(e.buf[offset:])&maxPointerOffset)
+	}
+	return e.buf[offset] == 0
+}
+
+func (e *messageEncoder) writeUncompressedName(labels Name) error {
+	if err := labels.Validate(); err != nil {
+		return err
+	}
+	for _, label := range labels {
+		e.buf = append(e.buf, byte(len(label)))
+		start := len(e.buf)
+		e.buf = append(e.buf, label...)
+		if e.lowercase {
+			for i := start; i < len(e.buf); i++ {
+				if c := e.buf[i]; c >= 'A' && c <= 'Z' {
+					e.buf[i] = c + ('a' - 'A')
+				}
+			}
+		}
+	}
+	e.buf = append(e.buf, 0)
+	return nil
+}
+
+func (e *messageEncoder) writeHeader(header Header) error {
+	flags, err := header.flags()
+	if err != nil {
+		return err
+	}
+	e.writeUint16(header.ID)
+	e.writeUint16(flags)
+	e.writeUint16(header.QuestionCount)
+	e.writeUint16(header.AnswerCount)
+	e.writeUint16(header.NSCount)
+	e.writeUint16(header.ARCount)
+	return nil
+}
+
+func (e *messageEncoder) writeQuestion(question Question) error {
+	if err := e.writeName(question.Name); err != nil {
+		return err
+	}
+	e.writeUint16(uint16(question.Type))
+	e.writeUint16(uint16(question.Class))
+	return nil
+}
+
+func (e *messageEncoder) writeAnswer(answer Answer) error {
+	if err := e.writeName(answer.Name); err != nil {
+		return err
+	}
+	e.writeUint16(uint16(answer.Type))
+	e.writeUint16(uint16(answer.Class))
+	e.writeUint32(answer.TTL)
+
+	lengthAt := len(e.buf)
+	e.writeUint16(0)
+	if answer.Data != nil {
+		if err := answer.Data.pack(e); err != nil {
+			return err
+		}
+	}
+	length := len(e.buf) - lengthAt - 2
+	if length > 0xffff {
+		return ErrBadRDataLength
+	}
+	binary.BigEndian.PutUint16(e.buf[lengthAt:], uint16(length))
+	return nil
+}
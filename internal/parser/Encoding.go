@@ -0,0 +1,255 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The types of a message implement encoding.BinaryMarshaler and
+// encoding.TextMarshaler along with their Unmarshaler counterparts. The binary
+// form is the wire format, the text form the presentation format that String
+// returns.
+
+func (header Header) MarshalBinary() ([]byte, error) {
+	return header.ToBinary()
+}
+
+func (header *Header) UnmarshalBinary(data []byte) error {
+	buffer := NewLookBackBuffer(data)
+	parsed, err := ParseHeader(buffer)
+	if err != nil {
+		return err
+	}
+	if err := buffer.checkEnd(); err != nil {
+		return err
+	}
+	*header = parsed
+	return nil
+}
+
+func (question Question) MarshalBinary() ([]byte, error) {
+	return question.ToBinary()
+}
+
+func (question *Question) UnmarshalBinary(data []byte) error {
+	buffer := NewLookBackBuffer(data)
+	parsed, err := ParseQuestion(buffer)
+	if err != nil {
+		return err
+	}
+	if err := buffer.checkEnd(); err != nil {
+		return err
+	}
+	*question = parsed
+	return nil
+}
+
+func (answer Answer) MarshalBinary() ([]byte, error) {
+	return answer.ToBinary()
+}
+
+func (answer *Answer) UnmarshalBinary(data []byte) error {
+	buffer := NewLookBackBuffer(data)
+	parsed, err := ParseAnswer(buffer)
+	if err != nil {
+		return err
+	}
+	if err := buffer.checkEnd(); err != nil {
+		return err
+	}
+	*answer = parsed
+	return nil
+}
+
+func (message Message) MarshalBinary() ([]byte, error) {
+	return message.Pack()
+}
+
+func (message *Message) UnmarshalBinary(data []byte) error {
+	parsed, end, err := parse(data)
+	if err != nil {
+		return err
+	}
+	if end != len(data) {
+		return &ParseError{Offset: end, Err: ErrTrailingData}
+	}
+	*message = parsed
+	return nil
+}
+
+func (header Header) MarshalText() ([]byte, error) {
+	return []byte(header.String()), nil
+}
+
+// UnmarshalText reads the form of Header.String.
+func (header *Header) UnmarshalText(text []byte) error {
+	parsed := Header{IsQuery: true}
+	for _, pair := range strings.Split(string(text), ", ") {
+		key, value, ok := strings.Cut(pair, ": ")
+		if !ok {
+			return fmt.Errorf("%w: bad header field %q", ErrSyntax, pair)
+		}
+		var n uint64
+		var err error
+		switch key {
+		case "id", "qcount", "acount", "nscount", "arcount":
+			n, err = strconv.ParseUint(value, 10, 16)
+		case "opcode":
+			// four bits in the header, RFC 1035 section 4.1.1
+			n, err = strconv.ParseUint(value, 10, 4)
+		case "rcode":
+			parsed.ResponseCode, err = ParseRCODE(value)
+		case "flags":
+			err = parsed.setFlags(value)
+		default:
+			err = fmt.Errorf("%w: unknown header field %q", ErrSyntax, key)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: bad %s %q", ErrSyntax, key, value)
+		}
+		switch key {
+		case "id":
+			parsed.ID = uint16(n)
+		case "opcode":
+			parsed.OPCODE = OPCODE(n)
+		case "qcount":
+			parsed.QuestionCount = uint16(n)
+		case "acount":
+			parsed.AnswerCount = uint16(n)
+		case "nscount":
+			parsed.NSCount = uint16(n)
+		case "arcount":
+			parsed.ARCount = uint16(n)
+		}
+	}
+	*header = parsed
+	return nil
+}
+
+// setFlags sets the flags named in the form of flagString.
+func (header *Header) setFlags(flags string) error {
+	for _, flag := range strings.Fields(flags) {
+		switch flag {
+		case "qr":
+			header.IsQuery = false
+		case "aa":
+			header.AuthoritativeAnswer = true
+		case "tc":
+			header.TrunCation = true
+		case "rd":
+			header.RecursionDesired = true
+		case "ra":
+			header.RecursionAvailable = true
+		case "z":
+			header.Zero = true
+		case "ad":
+			header.AuthenticData = true
+		case "cd":
+			header.CheckingDisabled = true
+		default:
+			return ErrSyntax
+		}
+	}
+	return nil
+}
+
+func (question Question) MarshalText() ([]byte, error) {
+	return []byte(question.String()), nil
+}
+
+// UnmarshalText reads "name [class] type", the class defaults to IN.
+func (question *Question) UnmarshalText(text []byte) error {
+	entries, err := lex(string(text))
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 || len(entries[0].fields) < 2 || len(entries[0].fields) > 3 {
+		return fmt.Errorf("%w: expected name, class and type", ErrSyntax)
+	}
+	fields := entries[0].fields
+	name, err := ParseName(fields[0])
+	if err != nil {
+		return err
+	}
+	class := IN
+	if len(fields) == 3 {
+		if class, err = ParseQClass(fields[1]); err != nil {
+			return err
+		}
+	}
+	t, err := ParseQType(fields[len(fields)-1])
+	if err != nil {
+		return err
+	}
+	*question = Question{Name: name, Type: t, Class: class}
+	return nil
+}
+
+func (answer Answer) MarshalText() ([]byte, error) {
+	return []byte(answer.String()), nil
+}
+
+func (answer *Answer) UnmarshalText(text []byte) error {
+	parsed, err := ParseRecord(string(text))
+	if err != nil {
+		return err
+	}
+	*answer = parsed
+	return nil
+}
+
+func (message Message) MarshalText() ([]byte, error) {
+	return []byte(message.String()), nil
+}
+
+// UnmarshalText reads the form of Message.String. The counts of the header
+// are taken from the sections.
+func (message *Message) UnmarshalText(text []byte) error {
+	lines := strings.Split(string(text), "\n")
+	parsed := Message{}
+	if err := parsed.Header.UnmarshalText([]byte(lines[0])); err != nil {
+		return &ZoneError{Line: 1, Err: err}
+	}
+	var section *[]Answer
+	questions := false
+	for i, line := range lines[1:] {
+		var err error
+		switch {
+		case strings.TrimSpace(line) == "":
+		case line == questionSection:
+			questions = true
+		case line == answerSection, line == authoritySection, line == additionalSection:
+			questions = false
+			section = map[string]*[]Answer{
+				answerSection:     &parsed.Answers,
+				authoritySection:  &parsed.Authority,
+				additionalSection: &parsed.Additional,
+			}[line]
+		case questions && strings.HasPrefix(line, ";"):
+			question := Question{}
+			err = question.UnmarshalText([]byte(line[1:]))
+			parsed.Questions = append(parsed.Questions, question)
+		case section != nil:
+			answer := Answer{}
+			err = answer.UnmarshalText([]byte(line))
+			*section = append(*section, answer)
+		default:
+			err = fmt.Errorf("%w: line outside of a section", ErrSyntax)
+		}
+		if err != nil {
+			return &ZoneError{Line: i + 2, Err: err}
+		}
+	}
+	parsed.syncCounts()
+	*message = parsed
+	return nil
+}
+
+// checkEnd fails unless everything was read.
+func (r *MessageBuffer) checkEnd() error {
+	if r.off != len(r.buf) {
+		return r.errorAt(r.off, ErrTrailingData)
+	}
+	return nil
+}
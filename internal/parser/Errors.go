@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTruncatedMessage = errors.New("truncated message")
+	ErrBadLabelLength   = errors.New("bad label length")
+	ErrBadPointer       = errors.New("bad compression pointer")
+	ErrBadRDataLength   = errors.New("rdata length does not match its content")
+	ErrPointerLoop      = errors.New("compression pointer does not point backwards")
+	ErrTooManyPointers  = errors.New("too many compression pointers")
+	ErrEmptyLabel       = errors.New("empty label")
+	ErrLabelTooLong     = errors.New("label longer than 63 octets")
+	ErrNameTooLong      = errors.New("name longer than 255 octets")
+	ErrSyntax           = errors.New("syntax error")
+	ErrMultipleOPT      = errors.New("more than one OPT record")
+	ErrExtendedRCODE    = errors.New("extended rcode without OPT record")
+	ErrBadClientSubnet  = errors.New("malformed client subnet option")
+	ErrBadCookie        = errors.New("malformed cookie option")
+	ErrBadExtendedError = errors.New("malformed extended error option")
+	ErrTrailingData     = errors.New("octets after the end of the data")
+	ErrIncludeDepth     = errors.New("$INCLUDE nested too deep")
+	ErrBadSvcParams     = errors.New("malformed SvcParams")
+	ErrBadTypeBitmap    = errors.New("malformed type bitmap")
+	ErrUnknownDigest    = errors.New("unknown digest type")
+	ErrBadOpcode        = errors.New("opcode larger than four bits")
+	ErrTooManyRecords   = errors.New("more than 65535 entries in a section")
+)
+
+// ParseError records where in the message parsing failed. Err is one of the
+// Err* values of this package and can be checked with errors.Is.
+type ParseError struct {
+	Offset int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ZoneError records the file and line of a syntax error in presentation
+// format. File is empty for text that didn't come from a file.
+type ZoneError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ZoneError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+func (e *ZoneError) Unwrap() error {
+	return e.Err
+}
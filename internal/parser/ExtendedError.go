@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	EXTENDED_ERROR EDNSOptionCode = 15
+)
+
+type ExtendedErrorCode uint16
+
+// info codes from RFC 8914 section 4
+const (
+	EDE_OTHER ExtendedErrorCode = iota
+	EDE_UNSUPPORTED_DNSKEY_ALGORITHM
+	EDE_UNSUPPORTED_DS_DIGEST_TYPE
+	EDE_STALE_ANSWER
+	EDE_FORGED_ANSWER
+	EDE_DNSSEC_INDETERMINATE
+	EDE_DNSSEC_BOGUS
+	EDE_SIGNATURE_EXPIRED
+	EDE_SIGNATURE_NOT_YET_VALID
+	EDE_DNSKEY_MISSING
+	EDE_RRSIGS_MISSING
+	EDE_NO_ZONE_KEY_BIT_SET
+	EDE_NSEC_MISSING
+	EDE_CACHED_ERROR
+	EDE_NOT_READY
+	EDE_BLOCKED
+	EDE_CENSORED
+	EDE_FILTERED
+	EDE_PROHIBITED
+	EDE_STALE_NXDOMAIN_ANSWER
+	EDE_NOT_AUTHORITATIVE
+	EDE_NOT_SUPPORTED
+	EDE_NO_REACHABLE_AUTHORITY
+	EDE_NETWORK_ERROR
+	EDE_INVALID_DATA
+)
+
+var extendedErrorNames = map[ExtendedErrorCode]string{
+	EDE_OTHER:                        "Other Error",
+	EDE_UNSUPPORTED_DNSKEY_ALGORITHM: "Unsupported DNSKEY Algorithm",
+	EDE_UNSUPPORTED_DS_DIGEST_TYPE:   "Unsupported DS Digest Type",
+	EDE_STALE_ANSWER:                 "Stale Answer",
+	EDE_FORGED_ANSWER:                "Forged Answer",
+	EDE_DNSSEC_INDETERMINATE:         "DNSSEC Indeterminate",
+	EDE_DNSSEC_BOGUS:                 "DNSSEC Bogus",
+	EDE_SIGNATURE_EXPIRED:            "Signature Expired",
+	EDE_SIGNATURE_NOT_YET_VALID:      "Signature Not Yet Valid",
+	EDE_DNSKEY_MISSING:               "DNSKEY Missing",
+	EDE_RRSIGS_MISSING:               "RRSIGs Missing",
+	EDE_NO_ZONE_KEY_BIT_SET:          "No Zone Key Bit Set",
+	EDE_NSEC_MISSING:                 "NSEC Missing",
+	EDE_CACHED_ERROR:                 "Cached Error",
+	EDE_NOT_READY:                    "Not Ready",
+	EDE_BLOCKED:                      "Blocked",
+	EDE_CENSORED:                     "Censored",
+	EDE_FILTERED:                     "Filtered",
+	EDE_PROHIBITED:                   "Prohibited",
+	EDE_STALE_NXDOMAIN_ANSWER:        "Stale NXDOMAIN Answer",
+	EDE_NOT_AUTHORITATIVE:            "Not Authoritative",
+	EDE_NOT_SUPPORTED:                "Not Supported",
+	EDE_NO_REACHABLE_AUTHORITY:       "No Reachable Authority",
+	EDE_NETWORK_ERROR:                "Network Error",
+	EDE_INVALID_DATA:                 "Invalid Data",
+}
+
+func (code ExtendedErrorCode) String() string {
+	if name, ok := extendedErrorNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("Info Code %d", uint16(code))
+}
+
+// ExtendedError is the Extended DNS Error option of RFC 8914.
+type ExtendedError struct {
+	InfoCode  ExtendedErrorCode
+	ExtraText string
+}
+
+func ParseExtendedError(option EDNSOption) (ExtendedError, error) {
+	data := option.Data
+	if option.Code != EXTENDED_ERROR || len(data) < 2 {
+		return ExtendedError{}, ErrBadExtendedError
+	}
+	text := data[2:]
+	// the text is not NUL terminated, but some servers send one anyway
+	if len(text) > 0 && text[len(text)-1] == 0 {
+		text = text[:len(text)-1]
+	}
+	if !utf8.Valid(text) {
+		return ExtendedError{}, ErrBadExtendedError
+	}
+	return ExtendedError{
+		InfoCode:  ExtendedErrorCode(binary.BigEndian.Uint16(data)),
+		ExtraText: string(text),
+	}, nil
+}
+
+func (ede ExtendedError) Option() EDNSOption {
+	data := binary.BigEndian.AppendUint16(nil, uint16(ede.InfoCode))
+	data = append(data, ede.ExtraText...)
+	return EDNSOption{Code: EXTENDED_ERROR, Data: data}
+}
+
+func (ede ExtendedError) String() string {
+	if ede.ExtraText == "" {
+		return fmt.Sprintf("%d (%s)", ede.InfoCode, ede.InfoCode)
+	}
+	return fmt.Sprintf("%d (%s): %s", ede.InfoCode, ede.InfoCode, ede.ExtraText)
+}
+
+// ExtendedErrors returns every Extended DNS Error of the message. Malformed
+// options are skipped, they are only informational.
+func (message Message) ExtendedErrors() []ExtendedError {
+	edns, ok := message.EDNS()
+	if !ok {
+		return nil
+	}
+	extended := []ExtendedError{}
+	for _, option := range edns.Options {
+		if option.Code != EXTENDED_ERROR {
+			continue
+		}
+		if ede, err := ParseExtendedError(option); err == nil {
+			extended = append(extended, ede)
+		}
+	}
+	return extended
+}
@@ -1,9 +1,9 @@
 package parser
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 type OPCODE uint8
@@ -12,6 +12,9 @@ const (
 	QUERY OPCODE = iota
 	IQUERY
 	STATUS
+	_
+	NOTIFY
+	UPDATE
 )
 
 const (
@@ -21,9 +24,60 @@ const (
 	NAME_ERROR
 	NOT_IMPLEMENTED
 	REFUSED
+	YXDOMAIN
+	YXRRSET
+	NXRRSET
+	NOT_AUTH
+	NOT_ZONE
 )
 
-type RCODE uint8
+// extended response codes need an OPT record, see RFC 6891 section 6.1.3
+const (
+	BADVERS   RCODE = 16
+	BADCOOKIE RCODE = 23
+)
+
+var rcodeNames = map[RCODE]string{
+	NO_ERROR:        "NOERROR",
+	FORMAT_ERROR:    "FORMERR",
+	SERVER_FAILURE:  "SERVFAIL",
+	NAME_ERROR:      "NXDOMAIN",
+	NOT_IMPLEMENTED: "NOTIMP",
+	REFUSED:         "REFUSED",
+	YXDOMAIN:        "YXDOMAIN",
+	YXRRSET:         "YXRRSET",
+	NXRRSET:         "NXRRSET",
+	NOT_AUTH:        "NOTAUTH",
+	NOT_ZONE:        "NOTZONE",
+	BADVERS:         "BADVERS",
+	BADCOOKIE:       "BADCOOKIE",
+}
+
+// ParseRCODE reads the mnemonic of a response code or RCODEnnn.
+func ParseRCODE(s string) (RCODE, error) {
+	for rcode, name := range rcodeNames {
+		if name == s {
+			return rcode, nil
+		}
+	}
+	if n, ok := strings.CutPrefix(s, "RCODE"); ok {
+		if rcode, err := strconv.ParseUint(n, 10, 12); err == nil {
+			return RCODE(rcode), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown rcode %q", ErrSyntax, s)
+}
+
+func (rcode RCODE) String() string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", uint16(rcode))
+}
+
+// RCODE holds the full 12 bit response code. Only the lower 4 bits are part
+// of the header, the rest is carried by the OPT record.
+type RCODE uint16
 
 type Header struct {
 	ID                  uint16
@@ -33,6 +87,9 @@ type Header struct {
 	TrunCation          bool
 	RecursionDesired    bool
 	RecursionAvailable  bool
+	Zero                bool // reserved, must be zero
+	AuthenticData       bool
+	CheckingDisabled    bool
 	ResponseCode        RCODE
 
 	QuestionCount uint16
@@ -41,70 +98,104 @@ type Header struct {
 	ARCount       uint16
 }
 
-func ParseHeader(buffer *MessageBuffer) Header {
-	parsedId := buffer.ReadUint16()
-	flagsNumber := buffer.ReadUint16()
-
-	opcode := QUERY
-
-	if (flagsNumber & (1 << 11)) != 0 {
-		opcode = IQUERY
-	} else if (flagsNumber & (1 << 12)) != 0 {
-		opcode = STATUS
+func ParseHeader(buffer *MessageBuffer) (Header, error) {
+	parsedId, err := buffer.ReadUint16()
+	if err != nil {
+		return Header{}, err
+	}
+	flagsNumber, err := buffer.ReadUint16()
+	if err != nil {
+		return Header{}, err
 	}
 
+	opcode := (flagsNumber >> 11) & 0b1111
 	rcode := flagsNumber & (0b00000000_00001111)
 
-	questionCount := buffer.ReadUint16()
-	answerCount := buffer.ReadUint16()
-	nsCount := buffer.ReadUint16()
-	arCount := buffer.ReadUint16()
+	var counts [4]uint16
+	for i := range counts {
+		counts[i], err = buffer.ReadUint16()
+		if err != nil {
+			return Header{}, err
+		}
+	}
 
 	return Header{
-		ID:            parsedId,
-		OPCODE:        opcode,
-		ResponseCode:  RCODE(rcode),
-		IsQuery:       (flagsNumber & (1 << 15)) == 0,
-		QuestionCount: questionCount,
-		AnswerCount:   answerCount,
-		ARCount:       arCount,
-		NSCount:       nsCount,
-	}
+		ID:                  parsedId,
+		OPCODE:              OPCODE(opcode),
+		ResponseCode:        RCODE(rcode),
+		IsQuery:             (flagsNumber & (1 << 15)) == 0,
+		AuthoritativeAnswer: (flagsNumber & (1 << 10)) != 0,
+		TrunCation:          (flagsNumber & (1 << 9)) != 0,
+		RecursionDesired:    (flagsNumber & (1 << 8)) != 0,
+		RecursionAvailable:  (flagsNumber & (1 << 7)) != 0,
+		Zero:                (flagsNumber & (1 << 6)) != 0,
+		AuthenticData:       (flagsNumber & (1 << 5)) != 0,
+		CheckingDisabled:    (flagsNumber & (1 << 4)) != 0,
+		QuestionCount:       counts[0],
+		AnswerCount:         counts[1],
+		NSCount:             counts[2],
+		ARCount:             counts[3],
+	}, nil
 }
 
 func (header Header) String() string {
-	return fmt.Sprintf("id: %d, qcount: %d acount: %d, nscount: %d, arcount: %d", header.ID, header.QuestionCount, header.AnswerCount, header.NSCount, header.ARCount)
+	return fmt.Sprintf("id: %d, opcode: %d, rcode: %s, flags: %s, qcount: %d, acount: %d, nscount: %d, arcount: %d", header.ID, header.OPCODE, header.ResponseCode, header.flagString(), header.QuestionCount, header.AnswerCount, header.NSCount, header.ARCount)
 }
 
-func (header Header) ToBinary() ([]byte, error) {
-	buf := new(bytes.Buffer)
+// flagString lists the set flags the way dig does.
+func (header Header) flagString() string {
+	flags := []string{}
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", !header.IsQuery},
+		{"aa", header.AuthoritativeAnswer},
+		{"tc", header.TrunCation},
+		{"rd", header.RecursionDesired},
+		{"ra", header.RecursionAvailable},
+		{"z", header.Zero},
+		{"ad", header.AuthenticData},
+		{"cd", header.CheckingDisabled},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return strings.Join(flags, " ")
+}
 
-	if err := binary.Write(buf, binary.BigEndian, header.ID); err != nil {
+func (header Header) ToBinary() ([]byte, error) {
+	e := &messageEncoder{}
+	if err := e.writeHeader(header); err != nil {
 		return nil, err
 	}
+	return e.buf, nil
+}
 
+// flags packs the second 16 bits of the header. The opcode has to fit in its
+// four bits, the upper bits of the response code belong to the OPT record.
+func (header Header) flags() (uint16, error) {
+	if header.OPCODE > 0b1111 {
+		return 0, fmt.Errorf("%w: %d", ErrBadOpcode, header.OPCODE)
+	}
 	var flags uint16
 	if !header.IsQuery {
 		flags |= uint16(1 << 15)
 	}
 	flags |= uint16(header.OPCODE) << 11
-	flags |= uint16(header.ResponseCode)
-
-	if err := binary.Write(buf, binary.BigEndian, flags); err != nil {
-		return nil, err
+	for bit, set := range [...]bool{
+		10: header.AuthoritativeAnswer,
+		9:  header.TrunCation,
+		8:  header.RecursionDesired,
+		7:  header.RecursionAvailable,
+		6:  header.Zero,
+		5:  header.AuthenticData,
+		4:  header.CheckingDisabled,
+	} {
+		if set {
+			flags |= uint16(1 << bit)
+		}
 	}
-	if err := binary.Write(buf, binary.BigEndian, header.QuestionCount); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.BigEndian, header.AnswerCount); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.BigEndian, header.NSCount); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.BigEndian, header.ARCount); err != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
+	return flags | uint16(header.ResponseCode&0b1111), nil
 }
@@ -1,6 +1,16 @@
 package parser
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	maxLabelLength = 63
+	maxNameLength  = 255
+	// a name of 255 octets can't contain more pointers than this
+	maxPointers = (maxNameLength+1)/2 - 2
+)
 
 type MessageBuffer struct {
 	buf []byte
@@ -13,61 +23,140 @@ func NewLookBackBuffer(b []byte) *MessageBuffer {
 	}
 }
 
+func (r *MessageBuffer) errorAt(offset int, err error) error {
+	return &ParseError{Offset: offset, Err: err}
+}
+
+// Read fills b completely or fails with ErrTruncatedMessage without
+// consuming anything.
 func (r *MessageBuffer) Read(b []byte) (n int, err error) {
+	if len(r.buf)-r.off < len(b) {
+		return 0, r.errorAt(r.off, ErrTruncatedMessage)
+	}
 	n = copy(b, r.buf[r.off:])
 	r.off += n
 	return n, nil
 }
 
-func (r *MessageBuffer) ReadUint16() (n uint16) {
-	count := make([]byte, 2)
-	r.Read(count)
-	return binary.BigEndian.Uint16(count)
+// rest copies what is left of the buffer, for RDATA that ends in a field
+// without length.
+func (r *MessageBuffer) rest() []byte {
+	b := make([]byte, len(r.buf)-r.off)
+	r.off += copy(b, r.buf[r.off:])
+	return b
 }
-func (r *MessageBuffer) ReadUint32() (n uint32) {
-	count := make([]byte, 4)
-	r.Read(count)
-	return binary.BigEndian.Uint32(count)
+
+func (r *MessageBuffer) ReadUint16() (n uint16, err error) {
+	if len(r.buf)-r.off < 2 {
+		return 0, r.errorAt(r.off, ErrTruncatedMessage)
+	}
+	n = binary.BigEndian.Uint16(r.buf[r.off:])
+	r.off += 2
+	return n, nil
+}
+
+func (r *MessageBuffer) ReadUint32() (n uint32, err error) {
+	if len(r.buf)-r.off < 4 {
+		return 0, r.errorAt(r.off, ErrTruncatedMessage)
+	}
+	n = binary.BigEndian.Uint32(r.buf[r.off:])
+	r.off += 4
+	return n, nil
 }
 
 func (r *MessageBuffer) ReadByte() (n byte, err error) {
+	if r.off >= len(r.buf) {
+		return 0, r.errorAt(r.off, ErrTruncatedMessage)
+	}
 	result := r.buf[r.off]
 	r.off += 1
 	return result, nil
 }
 
-func (r *MessageBuffer) ReadLabels() ([]string, error) {
-	labels := []string{}
+// ReadName reads a possibly compressed name. Every compression pointer has
+// to point before the part of the name it was found in, which rules out loops;
+// the number of jumps is capped on top of that. All labels share a single
+// string, so a name costs two allocations however many labels it has.
+func (r *MessageBuffer) ReadName() (Name, error) {
+	// where each label starts, a name of 255 octets has at most 127 labels
+	var labels [maxNameLength / 2]int
+	count := 0
+	off := r.off
+	segment := r.off
+	jumped := false
+	pointers := 0
+	nameLength := 1
 	for {
-		length, err := r.ReadByte()
+		if off >= len(r.buf) {
+			return Name{}, r.errorAt(off, ErrTruncatedMessage)
+		}
+		length := r.buf[off]
 
-		if (length & 0b11000000) == 0b11000000 {
-			octets, _ := r.ReadByte()
-			offset := binary.BigEndian.Uint16([]byte{
-				(length & 0b00111111),
-				octets,
-			})
-			current := r.off
-			r.off = int(offset)
-			pointerLabels, _ := r.ReadLabels()
-			r.off = current
+		switch length & 0b11000000 {
+		case 0b11000000:
+			if off+1 >= len(r.buf) {
+				return Name{}, r.errorAt(off, ErrTruncatedMessage)
+			}
+			offset := int(binary.BigEndian.Uint16(r.buf[off:]) & 0b00111111_11111111)
+			if offset >= len(r.buf) {
+				return Name{}, r.errorAt(off, ErrBadPointer)
+			}
+			if offset >= segment {
+				return Name{}, r.errorAt(off, ErrPointerLoop)
+			}
+			pointers++
+			if pointers > maxPointers {
+				return Name{}, r.errorAt(off, ErrTooManyPointers)
+			}
+			if !jumped {
+				r.off = off + 2
+				jumped = true
+			}
+			off = offset
+			segment = offset
 
-			labels = append(labels, pointerLabels...)
-			return labels, nil
-		}
+		case 0:
+			if length == 0 {
+				if !jumped {
+					r.off = off + 1
+				}
+				return r.name(labels[:count], nameLength), nil
+			}
+			nameLength += int(length) + 1
+			if nameLength > maxNameLength {
+				return Name{}, r.errorAt(off, ErrNameTooLong)
+			}
+			if off+1+int(length) > len(r.buf) {
+				return Name{}, r.errorAt(off, ErrTruncatedMessage)
+			}
+			labels[count] = off
+			count++
+			off += 1 + int(length)
 
-		if length == 0 {
-			break
-		}
-		if err != nil {
-			return labels, err
-		}
-		label := make([]byte, length)
-		_, err = r.Read(label)
-		if err != nil {
-			return labels, err
+		default:
+			// 0b01 and 0b10 prefixes are reserved
+			return Name{}, r.errorAt(off, ErrBadLabelLength)
 		}
-		labels = append(labels, string(label))
 	}
-	return labels, nil
+}
+
+// name copies the labels starting at offsets into one string.
+func (r *MessageBuffer) name(offsets []int, nameLength int) Name {
+	if len(offsets) == 0 {
+		return Name{}
+	}
+	s := strings.Builder{}
+	s.Grow(nameLength)
+	for _, off := range offsets {
+		s.Write(r.buf[off+1 : off+1+int(r.buf[off])])
+	}
+	all := s.String()
+	name := make(Name, len(offsets))
+	start := 0
+	for i, off := range offsets {
+		end := start + int(r.buf[off])
+		name[i] = all[start:end]
+		start = end
+	}
+	return name
 }
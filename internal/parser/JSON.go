@@ -0,0 +1,266 @@
+package parser
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// The JSON form of messages follows RFC 8427. Names are in presentation
+// format, RDATA is kept as hex so that every type survives the round trip,
+// with the presentation format next to it for known types.
+
+type headerJSON struct {
+	ID      uint16
+	QR      bool
+	Opcode  OPCODE
+	AA      bool
+	TC      bool
+	RD      bool
+	RA      bool
+	AD      bool
+	CD      bool
+	RCODE   RCODE
+	QDCOUNT uint16
+	ANCOUNT uint16
+	NSCOUNT uint16
+	ARCOUNT uint16
+}
+
+func (header Header) toJSON() headerJSON {
+	return headerJSON{
+		ID:      header.ID,
+		QR:      !header.IsQuery,
+		Opcode:  header.OPCODE,
+		AA:      header.AuthoritativeAnswer,
+		TC:      header.TrunCation,
+		RD:      header.RecursionDesired,
+		RA:      header.RecursionAvailable,
+		AD:      header.AuthenticData,
+		CD:      header.CheckingDisabled,
+		RCODE:   header.ResponseCode,
+		QDCOUNT: header.QuestionCount,
+		ANCOUNT: header.AnswerCount,
+		NSCOUNT: header.NSCount,
+		ARCOUNT: header.ARCount,
+	}
+}
+
+func (j headerJSON) header() Header {
+	return Header{
+		ID:                  j.ID,
+		IsQuery:             !j.QR,
+		OPCODE:              j.Opcode,
+		AuthoritativeAnswer: j.AA,
+		TrunCation:          j.TC,
+		RecursionDesired:    j.RD,
+		RecursionAvailable:  j.RA,
+		AuthenticData:       j.AD,
+		CheckingDisabled:    j.CD,
+		ResponseCode:        j.RCODE,
+		QuestionCount:       j.QDCOUNT,
+		AnswerCount:         j.ANCOUNT,
+		NSCount:             j.NSCOUNT,
+		ARCount:             j.ARCOUNT,
+	}
+}
+
+func (header Header) MarshalJSON() ([]byte, error) {
+	return json.Marshal(header.toJSON())
+}
+
+func (header *Header) UnmarshalJSON(data []byte) error {
+	j := headerJSON{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*header = j.header()
+	return nil
+}
+
+// questionJSON uses the member names of questions, RFC 8427 section 2.2
+type questionJSON struct {
+	QNAME      string
+	QTYPE      QType
+	QTYPEname  string `json:",omitempty"`
+	QCLASS     QClass
+	QCLASSname string `json:",omitempty"`
+}
+
+func (question Question) MarshalJSON() ([]byte, error) {
+	return json.Marshal(questionJSON{
+		QNAME:      question.Name.String(),
+		QTYPE:      question.Type,
+		QTYPEname:  question.Type.String(),
+		QCLASS:     question.Class,
+		QCLASSname: question.Class.String(),
+	})
+}
+
+func (question *Question) UnmarshalJSON(data []byte) error {
+	j := questionJSON{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	name, t, class, err := resolveJSON(j.QNAME, j.QTYPE, j.QTYPEname, j.QCLASS, j.QCLASSname)
+	if err != nil {
+		return err
+	}
+	*question = Question{Name: name, Type: t, Class: class}
+	return nil
+}
+
+// resolveJSON reads the fields that questions and records share. Type and
+// class may be given by number or by name.
+func resolveJSON(name string, t QType, typeName string, class QClass, className string) (Name, QType, QClass, error) {
+	parsed, err := ParseName(name)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if t == 0 && typeName != "" {
+		if t, err = ParseQType(typeName); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if class == 0 && className != "" {
+		if class, err = ParseQClass(className); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return parsed, t, class, nil
+}
+
+type answerJSON struct {
+	NAME      string
+	TYPE      QType
+	TYPEname  string `json:",omitempty"`
+	CLASS     QClass
+	CLASSname string `json:",omitempty"`
+	TTL       uint32
+	RDLENGTH  int
+	RDATAHEX  string
+}
+
+func (answer Answer) MarshalJSON() ([]byte, error) {
+	e := &messageEncoder{}
+	if answer.Data != nil {
+		if err := answer.Data.pack(e); err != nil {
+			return nil, err
+		}
+	}
+	fields := map[string]any{
+		"NAME":      answer.Name.String(),
+		"TYPE":      answer.Type,
+		"TYPEname":  answer.Type.String(),
+		"CLASS":     answer.Class,
+		"CLASSname": answer.Class.String(),
+		"TTL":       answer.TTL,
+		"RDLENGTH":  len(e.buf),
+		"RDATAHEX":  fmt.Sprintf("%X", e.buf),
+	}
+	// RFC 8427 section 2.3 names the presentation form after the type
+	if _, known := rdataTextParsers[answer.Type]; known && answer.Data != nil {
+		fields["rdata"+answer.Type.String()] = answer.Data.String()
+	}
+	return json.Marshal(fields)
+}
+
+func (answer *Answer) UnmarshalJSON(data []byte) error {
+	j := answerJSON{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	name, t, class, err := resolveJSON(j.NAME, j.TYPE, j.TYPEname, j.CLASS, j.CLASSname)
+	if err != nil {
+		return err
+	}
+	parsed := Answer{Name: name, Type: t, Class: class, TTL: j.TTL}
+
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	presentation, hasPresentation := members["rdata"+t.String()]
+	if j.RDATAHEX != "" || !hasPresentation {
+		rdata, err := hex.DecodeString(j.RDATAHEX)
+		if err != nil || len(rdata) != j.RDLENGTH && j.RDLENGTH != 0 {
+			return fmt.Errorf("%w: bad RDATAHEX", ErrSyntax)
+		}
+		if parsed.Data, err = parseRData(t, NewLookBackBuffer(rdata), len(rdata)); err != nil {
+			return err
+		}
+		*answer = parsed
+		return nil
+	}
+
+	text := ""
+	if err := json.Unmarshal(presentation, &text); err != nil {
+		return fmt.Errorf("%w: rdata%s is not a string", ErrSyntax, t)
+	}
+	entries, err := lex(text)
+	if err != nil || len(entries) != 1 {
+		return fmt.Errorf("%w: bad rdata%s", ErrSyntax, t)
+	}
+	if parsed.Data, err = ParseRData(t, entries[0].fields, nil); err != nil {
+		return err
+	}
+	*answer = parsed
+	return nil
+}
+
+type messageJSON struct {
+	headerJSON
+	QuestionRRs      []Question `json:"questionRRs,omitempty"`
+	AnswerRRs        []Answer   `json:"answerRRs,omitempty"`
+	AuthorityRRs     []Answer   `json:"authorityRRs,omitempty"`
+	AdditionalRRs    []Answer   `json:"additionalRRs,omitempty"`
+	MessageOctetsHEX string     `json:"messageOctetsHEX,omitempty"`
+}
+
+// MarshalJSON writes the message as RFC 8427 members together with its
+// wire format in messageOctetsHEX.
+func (message Message) MarshalJSON() ([]byte, error) {
+	buf, err := message.Pack()
+	if err != nil {
+		return nil, err
+	}
+	message.syncCounts()
+	return json.Marshal(messageJSON{
+		headerJSON:       message.Header.toJSON(),
+		QuestionRRs:      message.Questions,
+		AnswerRRs:        message.Answers,
+		AuthorityRRs:     message.Authority,
+		AdditionalRRs:    message.Additional,
+		MessageOctetsHEX: fmt.Sprintf("%X", buf),
+	})
+}
+
+// UnmarshalJSON prefers messageOctetsHEX when it is present, as it is exact.
+// Otherwise the message is put together from the other members.
+func (message *Message) UnmarshalJSON(data []byte) error {
+	j := messageJSON{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.MessageOctetsHEX != "" {
+		buf, err := hex.DecodeString(j.MessageOctetsHEX)
+		if err != nil {
+			return fmt.Errorf("%w: bad messageOctetsHEX", ErrSyntax)
+		}
+		parsed, err := Parse(buf)
+		if err != nil {
+			return err
+		}
+		*message = parsed
+		return nil
+	}
+	*message = Message{
+		Header:     j.headerJSON.header(),
+		Questions:  j.QuestionRRs,
+		Answers:    j.AnswerRRs,
+		Authority:  j.AuthorityRRs,
+		Additional: j.AdditionalRRs,
+	}
+	message.syncCounts()
+	return nil
+}
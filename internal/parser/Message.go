@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the shortest question and record possible, with the root as name
+const (
+	minQuestionLength = 5
+	minAnswerLength   = 11
+)
+
+type Message struct {
+	Header     Header
+	Questions  []Question
+	Answers    []Answer
+	Authority  []Answer
+	Additional []Answer
+}
+
+// Parse decodes a complete message. On error the returned message holds
+// everything that was decoded before the failure. If the message has an OPT
+// record, its extended response code is merged into Header.ResponseCode.
+func Parse(buf []byte) (Message, error) {
+	message, _, err := parse(buf)
+	return message, err
+}
+
+// parse is Parse that also returns how many octets the message took.
+func parse(buf []byte) (Message, int, error) {
+	buffer := NewLookBackBuffer(buf)
+
+	header, err := ParseHeader(buffer)
+	if err != nil {
+		return Message{}, buffer.off, err
+	}
+	message := Message{Header: header}
+	// size the sections from the counts, but no larger than the rest of the
+	// message could hold, so lying counts can't make us allocate
+	remaining := len(buf) - buffer.off
+	if header.QuestionCount > 0 {
+		message.Questions = make([]Question, 0, min(int(header.QuestionCount), remaining/minQuestionLength))
+	}
+
+	for i := 0; i < int(header.QuestionCount); i++ {
+		question, err := ParseQuestion(buffer)
+		if err != nil {
+			return message, buffer.off, err
+		}
+		message.Questions = append(message.Questions, question)
+	}
+	sections := []struct {
+		count   uint16
+		answers *[]Answer
+	}{
+		{header.AnswerCount, &message.Answers},
+		{header.NSCount, &message.Authority},
+		{header.ARCount, &message.Additional},
+	}
+	for _, section := range sections {
+		if section.count > 0 {
+			*section.answers = make([]Answer, 0, min(int(section.count), (len(buf)-buffer.off)/minAnswerLength))
+		}
+		for i := 0; i < int(section.count); i++ {
+			answer, err := ParseAnswer(buffer)
+			if err != nil {
+				return message, buffer.off, err
+			}
+			*section.answers = append(*section.answers, answer)
+		}
+	}
+
+	opts := 0
+	for _, answer := range message.Additional {
+		if answer.Type == OPT {
+			opts++
+			message.Header.ResponseCode |= RCODE(answer.TTL>>24) << 4
+		}
+	}
+	if opts > 1 {
+		return message, buffer.off, &ParseError{Offset: buffer.off, Err: ErrMultipleOPT}
+	}
+	return message, buffer.off, nil
+}
+
+// Pack encodes the message, compressing names wherever RFC 1035 allows it.
+// The section counts in the header are taken from the length of the
+// sections, whatever the header says. The upper bits of the response code
+// go into the OPT record.
+func (message Message) Pack() ([]byte, error) {
+	return message.AppendPack(nil)
+}
+
+// AppendPack is Pack appending to dst. If dst has room for the message,
+// nothing is allocated.
+func (message Message) AppendPack(dst []byte) ([]byte, error) {
+	// the counts are 16 bits, more entries can't be described by the header
+	for _, count := range []int{len(message.Questions), len(message.Answers), len(message.Authority), len(message.Additional)} {
+		if count > 0xffff {
+			return nil, fmt.Errorf("%w: %d", ErrTooManyRecords, count)
+		}
+	}
+	message.syncCounts()
+	header := message.Header
+
+	extendedRCODE := uint32(header.ResponseCode >> 4)
+	if _, ok := message.EDNS(); !ok && extendedRCODE != 0 {
+		return nil, ErrExtendedRCODE
+	}
+
+	encoder := newMessageEncoder(dst)
+	defer encoder.release()
+	if err := encoder.writeHeader(header); err != nil {
+		return nil, err
+	}
+	for _, question := range message.Questions {
+		if err := encoder.writeQuestion(question); err != nil {
+			return nil, err
+		}
+	}
+	for _, section := range [][]Answer{message.Answers, message.Authority} {
+		for _, answer := range section {
+			if err := encoder.writeAnswer(answer); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, answer := range message.Additional {
+		// only an OPT record in the additional section is EDNS
+		if answer.Type == OPT {
+			answer.TTL = answer.TTL&0x00ffffff | extendedRCODE<<24
+		}
+		if err := encoder.writeAnswer(answer); err != nil {
+			return nil, err
+		}
+	}
+	return encoder.buf, nil
+}
+
+// the section markers of dig, questions are commented out like it does
+const (
+	questionSection   = ";; QUESTION SECTION:"
+	answerSection     = ";; ANSWER SECTION:"
+	authoritySection  = ";; AUTHORITY SECTION:"
+	additionalSection = ";; ADDITIONAL SECTION:"
+)
+
+// String returns the header and the non-empty sections in presentation
+// format, one record per line.
+func (message Message) String() string {
+	s := strings.Builder{}
+	s.WriteString(message.Header.String())
+	if len(message.Questions) > 0 {
+		s.WriteString("\n" + questionSection)
+		for _, question := range message.Questions {
+			s.WriteString("\n;" + question.String())
+		}
+	}
+	for _, section := range []struct {
+		marker  string
+		answers []Answer
+	}{
+		{answerSection, message.Answers},
+		{authoritySection, message.Authority},
+		{additionalSection, message.Additional},
+	} {
+		if len(section.answers) == 0 {
+			continue
+		}
+		s.WriteString("\n" + section.marker)
+		for _, answer := range section.answers {
+			s.WriteString("\n" + answer.String())
+		}
+	}
+	return s.String()
+}
@@ -0,0 +1,189 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Name is a domain name as its labels, leftmost first. The root is the empty
+// name. Labels hold the raw octets, escapes only exist in presentation
+// format.
+type Name []string
+
+// NewName builds a name from labels and checks RFC 1035 length limits.
+func NewName(labels ...string) (Name, error) {
+	name := Name(append([]string{}, labels...))
+	if err := name.Validate(); err != nil {
+		return nil, err
+	}
+	return name, nil
+}
+
+// ParseName reads an absolute name in presentation format, the trailing dot
+// may be left out.
+func ParseName(s string) (Name, error) {
+	return parseName(s, nil)
+}
+
+// parseName reads a name in presentation format. Names without a trailing
+// dot are relative to origin, @ is the origin itself.
+func parseName(s string, origin Name) (Name, error) {
+	if s == "@" {
+		return append(Name{}, origin...), nil
+	}
+	if s == "." {
+		return Name{}, nil
+	}
+	name := Name{}
+	absolute := false
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && s[i] == '\\' {
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("%w: dangling escape in %q", ErrSyntax, s)
+			}
+			i++
+			continue
+		}
+		if i < len(s) && s[i] != '.' {
+			continue
+		}
+		if i == len(s) && start == len(s) {
+			absolute = true
+			break
+		}
+		if i == start {
+			return nil, fmt.Errorf("%w: empty label in %q", ErrSyntax, s)
+		}
+		label, err := unescape(s[start:i])
+		if err != nil {
+			return nil, err
+		}
+		name = append(name, string(label))
+		start = i + 1
+	}
+	if !absolute {
+		name = append(name, origin...)
+	}
+	if err := name.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %q", err, s)
+	}
+	return name, nil
+}
+
+// characters that would otherwise end or change the meaning of a name
+const nameSpecials = ". \"();@$"
+
+// String returns the absolute name in presentation format, escaping
+// everything that isn't printable or would end a label.
+func (name Name) String() string {
+	if len(name) == 0 {
+		return "."
+	}
+	s := strings.Builder{}
+	for _, label := range name {
+		s.WriteString(escape([]byte(label), nameSpecials))
+		s.WriteByte('.')
+	}
+	return s.String()
+}
+
+// Validate checks the limits of RFC 1035 section 2.3.4: labels of 1 to 63
+// octets and at most 255 octets in wire format.
+func (name Name) Validate() error {
+	if name.WireLength() > maxNameLength {
+		return ErrNameTooLong
+	}
+	for _, label := range name {
+		if len(label) == 0 {
+			return ErrEmptyLabel
+		}
+		if len(label) > maxLabelLength {
+			return ErrLabelTooLong
+		}
+	}
+	return nil
+}
+
+// WireLength is the length of the uncompressed name in wire format.
+func (name Name) WireLength() int {
+	length := 1
+	for _, label := range name {
+		length += len(label) + 1
+	}
+	return length
+}
+
+// Equal compares names ignoring ASCII case, RFC 4343.
+func (name Name) Equal(other Name) bool {
+	if len(name) != len(other) {
+		return false
+	}
+	for i := range name {
+		if compareLabels(name[i], other[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSubdomainOf tells whether name is parent or below it.
+func (name Name) IsSubdomainOf(parent Name) bool {
+	return len(name) >= len(parent) && name[len(name)-len(parent):].Equal(parent)
+}
+
+// Parent returns the name without its first label. The root is its own
+// parent.
+func (name Name) Parent() Name {
+	if len(name) == 0 {
+		return name
+	}
+	return name[1:]
+}
+
+// Child returns label prepended to name.
+func (name Name) Child(label string) (Name, error) {
+	return NewName(append([]string{label}, name...)...)
+}
+
+// Canonical returns the name in lower case, RFC 4034 section 6.2.
+func (name Name) Canonical() Name {
+	canonical := make(Name, len(name))
+	for i, label := range name {
+		canonical[i] = lower(label)
+	}
+	return canonical
+}
+
+// Compare orders names canonically, RFC 4034 section 6.1: label by label
+// from the right, each compared as lower case octets, where a missing label
+// sorts first. It returns -1, 0 or +1.
+func (name Name) Compare(other Name) int {
+	for i := 1; i <= min(len(name), len(other)); i++ {
+		if c := compareLabels(name[len(name)-i], other[len(other)-i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(name) < len(other):
+		return -1
+	case len(name) > len(other):
+		return 1
+	}
+	return 0
+}
+
+func compareLabels(a string, b string) int {
+	return strings.Compare(lower(a), lower(b))
+}
+
+// lower folds only ASCII letters, DNS names are not Unicode aware.
+func lower(label string) string {
+	b := []byte(label)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+	return string(b)
+}
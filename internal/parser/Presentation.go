@@ -0,0 +1,283 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// entry is one record worth of fields in presentation format, as produced by
+// the lexer. Quoted strings keep their quotes and escapes are not resolved,
+// so every field can still be interpreted on its own.
+type entry struct {
+	fields []string
+	line   int
+	// the entry started with white space, so it has no owner name
+	indented bool
+}
+
+// lex splits text into entries following RFC 1035 section 5.1: fields are
+// separated by white space, ; starts a comment, parentheses continue an entry
+// over several lines and quotes allow white space inside a field.
+func lex(text string) ([]entry, error) {
+	entries := []entry{}
+	current := entry{line: 1}
+	line := 1
+	parens := 0
+	startOfLine := true
+
+	flush := func() {
+		if len(current.fields) > 0 {
+			entries = append(entries, current)
+		}
+		current = entry{line: line}
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+			if parens == 0 {
+				flush()
+				startOfLine = true
+			}
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			if startOfLine && len(current.fields) == 0 {
+				current.indented = true
+			}
+			i++
+		case c == ';':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == '(':
+			parens++
+			i++
+		case c == ')':
+			if parens == 0 {
+				return nil, &ZoneError{Line: line, Err: fmt.Errorf("%w: unbalanced )", ErrSyntax)}
+			}
+			parens--
+			i++
+		case c == '"':
+			start := line
+			end := i + 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				if end < len(text) && text[end] == '\n' {
+					line++
+				}
+				end++
+			}
+			if end >= len(text) {
+				return nil, &ZoneError{Line: start, Err: fmt.Errorf("%w: unterminated string", ErrSyntax)}
+			}
+			current.fields = append(current.fields, text[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(text) && !strings.ContainsRune(" \t\r\n;()\"", rune(text[end])) {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end, len(text))
+			current.fields = append(current.fields, text[i:end])
+			i = end
+		}
+		startOfLine = false
+	}
+	if parens != 0 {
+		return nil, &ZoneError{Line: line, Err: fmt.Errorf("%w: unbalanced (", ErrSyntax)}
+	}
+	flush()
+	return entries, nil
+}
+
+// unescape resolves \X and \DDD escapes, RFC 1035 section 5.1.
+func unescape(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return nil, fmt.Errorf("%w: trailing backslash in %q", ErrSyntax, s)
+		}
+		if s[i] < '0' || s[i] > '9' {
+			out = append(out, s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return nil, fmt.Errorf("%w: short \\DDD escape in %q", ErrSyntax, s)
+		}
+		n, err := strconv.ParseUint(s[i:i+3], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad \\DDD escape in %q", ErrSyntax, s)
+		}
+		out = append(out, byte(n))
+		i += 2
+	}
+	return out, nil
+}
+
+// escape writes b so that unescape gives it back. Characters in special are
+// escaped with a backslash, everything unprintable as \DDD.
+func escape(b []byte, special string) string {
+	s := strings.Builder{}
+	for _, c := range b {
+		switch {
+		case c == ' ' && !strings.Contains(special, " "):
+			s.WriteByte(c)
+		case c < 0x21 || c > 0x7e:
+			fmt.Fprintf(&s, "\\%03d", c)
+		case c == '\\' || strings.IndexByte(special, c) >= 0:
+			s.WriteByte('\\')
+			s.WriteByte(c)
+		default:
+			s.WriteByte(c)
+		}
+	}
+	return s.String()
+}
+
+// parseTTL reads a TTL in seconds, also accepting the unit suffixes of BIND
+// like 1h30m.
+func parseTTL(s string) (uint32, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	units := map[byte]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+	total := uint64(0)
+	number := ""
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' {
+			number += string(c)
+			continue
+		}
+		unit, ok := units[c|0x20]
+		if !ok || number == "" {
+			return 0, fmt.Errorf("%w: bad ttl %q", ErrSyntax, s)
+		}
+		n, err := strconv.ParseUint(number, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: bad ttl %q", ErrSyntax, s)
+		}
+		total += n * unit
+		if total > 0xffffffff {
+			return 0, fmt.Errorf("%w: bad ttl %q", ErrSyntax, s)
+		}
+		number = ""
+	}
+	if number != "" {
+		return 0, fmt.Errorf("%w: bad ttl %q", ErrSyntax, s)
+	}
+	return uint32(total), nil
+}
+
+type rdataTextParser func(fields []string, origin Name) (RData, error)
+
+// ParseRData reads RDATA of type t from its presentation fields. Relative
+// names are completed with origin. Every type also accepts the generic form
+// of RFC 3597.
+func ParseRData(t QType, fields []string, origin Name) (RData, error) {
+	if len(fields) > 0 && fields[0] == `\#` {
+		return ParseGenericRData(t, fields)
+	}
+	parse, ok := rdataTextParsers[t]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s rdata has to use the \\# form", ErrSyntax, t)
+	}
+	return parse(fields, origin)
+}
+
+// recordDefaults are the values used for fields an entry leaves out.
+type recordDefaults struct {
+	origin Name
+	owner  Name
+	ttl    uint32
+	hasTTL bool
+	class  QClass
+}
+
+// parseEntry reads owner, TTL, class, type and RDATA of one entry. TTL and
+// class are optional and may come in either order, RFC 1035 section 5.1.
+func parseEntry(e entry, defaults recordDefaults) (Answer, error) {
+	fields := e.fields
+	answer := Answer{Name: defaults.owner, Class: defaults.class, TTL: defaults.ttl}
+	if !e.indented {
+		owner, err := parseName(fields[0], defaults.origin)
+		if err != nil {
+			return Answer{}, err
+		}
+		answer.Name = owner
+		fields = fields[1:]
+	} else if defaults.owner == nil {
+		return Answer{}, fmt.Errorf("%w: no owner name", ErrSyntax)
+	}
+
+	hasTTL, hasClass := false, false
+	for len(fields) > 0 {
+		if !hasTTL && fields[0][0] >= '0' && fields[0][0] <= '9' {
+			ttl, err := parseTTL(fields[0])
+			if err != nil {
+				return Answer{}, err
+			}
+			answer.TTL = ttl
+			hasTTL = true
+			fields = fields[1:]
+			continue
+		}
+		if !hasClass {
+			if class, err := ParseQClass(fields[0]); err == nil {
+				answer.Class = class
+				hasClass = true
+				fields = fields[1:]
+				continue
+			}
+		}
+		break
+	}
+	if !hasTTL && !defaults.hasTTL {
+		return Answer{}, fmt.Errorf("%w: no ttl", ErrSyntax)
+	}
+	if len(fields) == 0 {
+		return Answer{}, fmt.Errorf("%w: no type", ErrSyntax)
+	}
+	t, err := ParseQType(fields[0])
+	if err != nil {
+		return Answer{}, err
+	}
+	answer.Type = t
+	answer.Data, err = ParseRData(t, fields[1:], defaults.origin)
+	if err != nil {
+		return Answer{}, err
+	}
+	return answer, nil
+}
+
+// ParseRecord reads a single record in presentation format, like
+// "www.example.com. 3600 IN A 192.0.2.1". Names are relative to the root,
+// so the trailing dot may be left out. The class defaults to IN.
+func ParseRecord(text string) (Answer, error) {
+	entries, err := lex(text)
+	if err != nil {
+		return Answer{}, err
+	}
+	if len(entries) != 1 {
+		return Answer{}, fmt.Errorf("%w: expected one record, got %d", ErrSyntax, len(entries))
+	}
+	if entries[0].indented {
+		return Answer{}, fmt.Errorf("%w: no owner name", ErrSyntax)
+	}
+	return parseEntry(entries[0], recordDefaults{class: IN})
+}
@@ -1,11 +1,6 @@
 package parser
 
-import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	"strings"
-)
+import "fmt"
 
 type QClass uint16
 
@@ -20,54 +15,84 @@ const (
 type QType uint16
 
 const (
-	A  QType = 1
-	NS QType = 2
-	MD QType = 3
-	MF QType = 4
+	A          QType = 1
+	NS         QType = 2
+	MD         QType = 3
+	MF         QType = 4
+	CNAME      QType = 5
+	SOA        QType = 6
+	MB         QType = 7
+	MG         QType = 8
+	MR         QType = 9
+	NULL       QType = 10
+	WKS        QType = 11
+	PTR        QType = 12
+	HINFO      QType = 13
+	MINFO      QType = 14
+	MX         QType = 15
+	TXT        QType = 16
+	RP         QType = 17
+	AFSDB      QType = 18
+	AAAA       QType = 28
+	LOC        QType = 29
+	SRV        QType = 33
+	NAPTR      QType = 35
+	DNAME      QType = 39
+	OPT        QType = 41
+	DS         QType = 43
+	SSHFP      QType = 44
+	RRSIG      QType = 46
+	NSEC       QType = 47
+	DNSKEY     QType = 48
+	NSEC3      QType = 50
+	NSEC3PARAM QType = 51
+	TLSA       QType = 52
+	SVCB       QType = 64
+	HTTPS      QType = 65
+	IXFR       QType = 251
+	AXFR       QType = 252
+	MAILB      QType = 253
+	MAILA      QType = 254
+	ALL        QType = 255 // ANY in presentation format
+	URI        QType = 256
+	CAA        QType = 257
 )
 
 type Question struct {
-	Labels []string
-	Type   QType
-	Class  QClass
+	Name  Name
+	Type  QType
+	Class QClass
 }
 
-func ParseQuestion(buffer *MessageBuffer) Question {
-	labels, _ := buffer.ReadLabels()
-	buf := make([]byte, 2)
-	buffer.Read(buf)
-	qtype := binary.BigEndian.Uint16(buf)
-
-	buf = make([]byte, 2)
-	buffer.Read(buf)
-	qclass := binary.BigEndian.Uint16(buf)
+func ParseQuestion(buffer *MessageBuffer) (Question, error) {
+	name, err := buffer.ReadName()
+	if err != nil {
+		return Question{}, err
+	}
+	qtype, err := buffer.ReadUint16()
+	if err != nil {
+		return Question{}, err
+	}
+	qclass, err := buffer.ReadUint16()
+	if err != nil {
+		return Question{}, err
+	}
 
 	return Question{
-		Labels: labels,
-		Type:   QType(qtype),
-		Class:  QClass(qclass),
-	}
+		Name:  name,
+		Type:  QType(qtype),
+		Class: QClass(qclass),
+	}, nil
 }
 
 func (question Question) String() string {
-	return fmt.Sprintf("question: %s type: %d class: %d", strings.Join(question.Labels, "."), question.Type, question.Class)
+	return fmt.Sprintf("%s %s %s", question.Name, question.Class, question.Type)
 }
 
 func (question Question) ToBinary() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	for i := 0; i < len(question.Labels); i++ {
-		size := byte(len(question.Labels[i]))
-		if err := binary.Write(buf, binary.BigEndian, size); err != nil {
-			return nil, err
-		}
-		buf.Write([]byte(question.Labels[i]))
-	}
-	buf.WriteByte(0)
-	if err := binary.Write(buf, binary.BigEndian, question.Type); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.BigEndian, question.Class); err != nil {
+	e := &messageEncoder{}
+	if err := e.writeQuestion(question); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return e.buf, nil
 }
@@ -0,0 +1,591 @@
+package parser
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// RData is the typed content of a resource record. Names inside RDATA are
+// kept uncompressed; compression is decided by the encoder.
+type RData interface {
+	Type() QType
+	// String returns the RDATA in presentation format
+	String() string
+	pack(e *messageEncoder) error
+}
+
+type rdataParser func(buffer *MessageBuffer, length int) (RData, error)
+
+var rdataTextParsers = map[QType]rdataTextParser{
+	A:          parseARecordText,
+	NS:         parseNSRecordText,
+	CNAME:      parseCNAMERecordText,
+	SOA:        parseSOARecordText,
+	PTR:        parsePTRRecordText,
+	MX:         parseMXRecordText,
+	TXT:        parseTXTRecordText,
+	AAAA:       parseAAAARecordText,
+	SRV:        parseSRVRecordText,
+	SVCB:       parseSVCBRecordText,
+	HTTPS:      parseHTTPSRecordText,
+	DS:         parseDSRecordText,
+	RRSIG:      parseRRSIGRecordText,
+	NSEC:       parseNSECRecordText,
+	DNSKEY:     parseDNSKEYRecordText,
+	NSEC3:      parseNSEC3RecordText,
+	NSEC3PARAM: parseNSEC3PARAMRecordText,
+	OPT:        parseOPTRecordText,
+}
+
+var rdataParsers = map[QType]rdataParser{
+	A:          parseARecord,
+	NS:         parseNSRecord,
+	CNAME:      parseCNAMERecord,
+	SOA:        parseSOARecord,
+	PTR:        parsePTRRecord,
+	MX:         parseMXRecord,
+	TXT:        parseTXTRecord,
+	AAAA:       parseAAAARecord,
+	SRV:        parseSRVRecord,
+	SVCB:       parseSVCBRecord,
+	HTTPS:      parseHTTPSRecord,
+	DS:         parseDSRecord,
+	RRSIG:      parseRRSIGRecord,
+	NSEC:       parseNSECRecord,
+	DNSKEY:     parseDNSKEYRecord,
+	NSEC3:      parseNSEC3Record,
+	NSEC3PARAM: parseNSEC3PARAMRecord,
+	OPT:        parseOPTRecord,
+}
+
+// parseRData reads exactly length octets of RDATA for the given type.
+func parseRData(t QType, buffer *MessageBuffer, length int) (RData, error) {
+	start := buffer.off
+	if len(buffer.buf)-start < length {
+		return nil, buffer.errorAt(start, ErrTruncatedMessage)
+	}
+	// the RDATA must not borrow octets from the next record
+	full := buffer.buf
+	buffer.buf = full[:start+length]
+	var data RData
+	var err error
+	if parse, ok := rdataParsers[t]; ok {
+		data, err = parse(buffer, length)
+	} else {
+		data, err = parseUnknownRecord(t, buffer, length)
+	}
+	buffer.buf = full
+	if errors.Is(err, ErrTruncatedMessage) {
+		return nil, buffer.errorAt(start, ErrBadRDataLength)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if buffer.off != start+length {
+		return nil, buffer.errorAt(start, ErrBadRDataLength)
+	}
+	return data, nil
+}
+
+type ARecord struct {
+	Addr netip.Addr
+}
+
+func parseARecord(buffer *MessageBuffer, length int) (RData, error) {
+	if length != 4 {
+		return nil, buffer.errorAt(buffer.off, ErrBadRDataLength)
+	}
+	var ip [4]byte
+	if _, err := buffer.Read(ip[:]); err != nil {
+		return nil, err
+	}
+	return ARecord{Addr: netip.AddrFrom4(ip)}, nil
+}
+
+func (record ARecord) Type() QType { return A }
+
+func (record ARecord) pack(e *messageEncoder) error {
+	if !record.Addr.Is4() {
+		return ErrBadRDataLength
+	}
+	ip := record.Addr.As4()
+	e.buf = append(e.buf, ip[:]...)
+	return nil
+}
+
+func (record ARecord) String() string {
+	return record.Addr.String()
+}
+
+func parseARecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(A, fields, 1); err != nil {
+		return nil, err
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil || !addr.Is4() {
+		return nil, fmt.Errorf("%w: bad IPv4 address %q", ErrSyntax, fields[0])
+	}
+	return ARecord{Addr: addr}, nil
+}
+
+type AAAARecord struct {
+	Addr netip.Addr
+}
+
+func parseAAAARecord(buffer *MessageBuffer, length int) (RData, error) {
+	if length != 16 {
+		return nil, buffer.errorAt(buffer.off, ErrBadRDataLength)
+	}
+	var ip [16]byte
+	if _, err := buffer.Read(ip[:]); err != nil {
+		return nil, err
+	}
+	return AAAARecord{Addr: netip.AddrFrom16(ip)}, nil
+}
+
+func (record AAAARecord) Type() QType { return AAAA }
+
+func (record AAAARecord) pack(e *messageEncoder) error {
+	if !record.Addr.Is6() {
+		return ErrBadRDataLength
+	}
+	ip := record.Addr.As16()
+	e.buf = append(e.buf, ip[:]...)
+	return nil
+}
+
+func (record AAAARecord) String() string {
+	return record.Addr.String()
+}
+
+func parseAAAARecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(AAAA, fields, 1); err != nil {
+		return nil, err
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil || !addr.Is6() || addr.Zone() != "" {
+		return nil, fmt.Errorf("%w: bad IPv6 address %q", ErrSyntax, fields[0])
+	}
+	return AAAARecord{Addr: addr}, nil
+}
+
+type NSRecord struct {
+	Host Name
+}
+
+func parseNSRecord(buffer *MessageBuffer, length int) (RData, error) {
+	name, err := buffer.ReadName()
+	return NSRecord{Host: name}, err
+}
+
+func (record NSRecord) Type() QType { return NS }
+
+func (record NSRecord) pack(e *messageEncoder) error {
+	return e.writeName(record.Host)
+}
+
+func (record NSRecord) String() string {
+	return record.Host.String()
+}
+
+func parseNSRecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(NS, fields, 1); err != nil {
+		return nil, err
+	}
+	name, err := parseName(fields[0], origin)
+	return NSRecord{Host: name}, err
+}
+
+type CNAMERecord struct {
+	Target Name
+}
+
+func parseCNAMERecord(buffer *MessageBuffer, length int) (RData, error) {
+	name, err := buffer.ReadName()
+	return CNAMERecord{Target: name}, err
+}
+
+func (record CNAMERecord) Type() QType { return CNAME }
+
+func (record CNAMERecord) pack(e *messageEncoder) error {
+	return e.writeName(record.Target)
+}
+
+func (record CNAMERecord) String() string {
+	return record.Target.String()
+}
+
+func parseCNAMERecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(CNAME, fields, 1); err != nil {
+		return nil, err
+	}
+	name, err := parseName(fields[0], origin)
+	return CNAMERecord{Target: name}, err
+}
+
+type PTRRecord struct {
+	Target Name
+}
+
+func parsePTRRecord(buffer *MessageBuffer, length int) (RData, error) {
+	name, err := buffer.ReadName()
+	return PTRRecord{Target: name}, err
+}
+
+func (record PTRRecord) Type() QType { return PTR }
+
+func (record PTRRecord) pack(e *messageEncoder) error {
+	return e.writeName(record.Target)
+}
+
+func (record PTRRecord) String() string {
+	return record.Target.String()
+}
+
+func parsePTRRecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(PTR, fields, 1); err != nil {
+		return nil, err
+	}
+	name, err := parseName(fields[0], origin)
+	return PTRRecord{Target: name}, err
+}
+
+type MXRecord struct {
+	Preference uint16
+	Exchange   Name
+}
+
+func parseMXRecord(buffer *MessageBuffer, length int) (RData, error) {
+	preference, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	name, err := buffer.ReadName()
+	return MXRecord{Preference: preference, Exchange: name}, err
+}
+
+func (record MXRecord) Type() QType { return MX }
+
+func (record MXRecord) pack(e *messageEncoder) error {
+	e.writeUint16(record.Preference)
+	return e.writeName(record.Exchange)
+}
+
+func (record MXRecord) String() string {
+	return fmt.Sprintf("%d %s", record.Preference, record.Exchange.String())
+}
+
+func parseMXRecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(MX, fields, 2); err != nil {
+		return nil, err
+	}
+	preference, err := parseUint16(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	name, err := parseName(fields[1], origin)
+	return MXRecord{Preference: preference, Exchange: name}, err
+}
+
+type TXTRecord struct {
+	Texts []string
+}
+
+func parseTXTRecord(buffer *MessageBuffer, length int) (RData, error) {
+	// RFC 1035 requires at least one string
+	if length == 0 {
+		return nil, buffer.errorAt(buffer.off, ErrBadRDataLength)
+	}
+	record := TXTRecord{}
+	for buffer.off < len(buffer.buf) {
+		size, err := buffer.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if len(buffer.buf)-buffer.off < int(size) {
+			return nil, buffer.errorAt(buffer.off, ErrTruncatedMessage)
+		}
+		record.Texts = append(record.Texts, string(buffer.buf[buffer.off:buffer.off+int(size)]))
+		buffer.off += int(size)
+	}
+	return record, nil
+}
+
+func (record TXTRecord) Type() QType { return TXT }
+
+func (record TXTRecord) pack(e *messageEncoder) error {
+	// RFC 1035 requires at least one string
+	if len(record.Texts) == 0 {
+		e.buf = append(e.buf, 0)
+	}
+	for _, text := range record.Texts {
+		if len(text) > 255 {
+			return ErrBadRDataLength
+		}
+		e.buf = append(e.buf, byte(len(text)))
+		e.buf = append(e.buf, text...)
+	}
+	return nil
+}
+
+func (record TXTRecord) String() string {
+	texts := []string{}
+	for _, text := range record.Texts {
+		texts = append(texts, `"`+escape([]byte(text), `"`)+`"`)
+	}
+	return strings.Join(texts, " ")
+}
+
+func parseTXTRecordText(fields []string, origin Name) (RData, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: TXT needs at least one string", ErrSyntax)
+	}
+	record := TXTRecord{}
+	for _, field := range fields {
+		text, err := parseCharacterString(field)
+		if err != nil {
+			return nil, err
+		}
+		record.Texts = append(record.Texts, text)
+	}
+	return record, nil
+}
+
+type SOARecord struct {
+	MName   Name
+	RName   Name
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+func parseSOARecord(buffer *MessageBuffer, length int) (RData, error) {
+	mname, err := buffer.ReadName()
+	if err != nil {
+		return nil, err
+	}
+	rname, err := buffer.ReadName()
+	if err != nil {
+		return nil, err
+	}
+	var numbers [5]uint32
+	for i := range numbers {
+		if numbers[i], err = buffer.ReadUint32(); err != nil {
+			return nil, err
+		}
+	}
+	return SOARecord{
+		MName:   mname,
+		RName:   rname,
+		Serial:  numbers[0],
+		Refresh: numbers[1],
+		Retry:   numbers[2],
+		Expire:  numbers[3],
+		Minimum: numbers[4],
+	}, nil
+}
+
+func (record SOARecord) Type() QType { return SOA }
+
+func (record SOARecord) pack(e *messageEncoder) error {
+	if err := e.writeName(record.MName); err != nil {
+		return err
+	}
+	if err := e.writeName(record.RName); err != nil {
+		return err
+	}
+	e.writeUint32(record.Serial)
+	e.writeUint32(record.Refresh)
+	e.writeUint32(record.Retry)
+	e.writeUint32(record.Expire)
+	e.writeUint32(record.Minimum)
+	return nil
+}
+
+func (record SOARecord) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", record.MName.String(), record.RName.String(), record.Serial, record.Refresh, record.Retry, record.Expire, record.Minimum)
+}
+
+func parseSOARecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(SOA, fields, 7); err != nil {
+		return nil, err
+	}
+	mname, err := parseName(fields[0], origin)
+	if err != nil {
+		return nil, err
+	}
+	rname, err := parseName(fields[1], origin)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad serial %q", ErrSyntax, fields[2])
+	}
+	var timers [4]uint32
+	for i := range timers {
+		if timers[i], err = parseTTL(fields[3+i]); err != nil {
+			return nil, err
+		}
+	}
+	return SOARecord{
+		MName:   mname,
+		RName:   rname,
+		Serial:  uint32(serial),
+		Refresh: timers[0],
+		Retry:   timers[1],
+		Expire:  timers[2],
+		Minimum: timers[3],
+	}, nil
+}
+
+type SRVRecord struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   Name
+}
+
+func parseSRVRecord(buffer *MessageBuffer, length int) (RData, error) {
+	var numbers [3]uint16
+	var err error
+	for i := range numbers {
+		if numbers[i], err = buffer.ReadUint16(); err != nil {
+			return nil, err
+		}
+	}
+	name, err := buffer.ReadName()
+	if err != nil {
+		return nil, err
+	}
+	return SRVRecord{
+		Priority: numbers[0],
+		Weight:   numbers[1],
+		Port:     numbers[2],
+		Target:   name,
+	}, nil
+}
+
+func (record SRVRecord) Type() QType { return SRV }
+
+// RFC 2782 forbids compressing the target
+func (record SRVRecord) pack(e *messageEncoder) error {
+	e.writeUint16(record.Priority)
+	e.writeUint16(record.Weight)
+	e.writeUint16(record.Port)
+	return e.writeUncompressedName(record.Target)
+}
+
+func (record SRVRecord) String() string {
+	return fmt.Sprintf("%d %d %d %s", record.Priority, record.Weight, record.Port, record.Target.String())
+}
+
+func parseSRVRecordText(fields []string, origin Name) (RData, error) {
+	if err := expectFields(SRV, fields, 4); err != nil {
+		return nil, err
+	}
+	var numbers [3]uint16
+	var err error
+	for i := range numbers {
+		if numbers[i], err = parseUint16(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	name, err := parseName(fields[3], origin)
+	if err != nil {
+		return nil, err
+	}
+	return SRVRecord{
+		Priority: numbers[0],
+		Weight:   numbers[1],
+		Port:     numbers[2],
+		Target:   name,
+	}, nil
+}
+
+// UnknownRecord holds the RDATA of every type without a dedicated type.
+type UnknownRecord struct {
+	RRType QType
+	Data   []byte
+}
+
+func parseUnknownRecord(t QType, buffer *MessageBuffer, length int) (RData, error) {
+	data := make([]byte, length)
+	if _, err := buffer.Read(data); err != nil {
+		return nil, err
+	}
+	return UnknownRecord{RRType: t, Data: data}, nil
+}
+
+func (record UnknownRecord) Type() QType { return record.RRType }
+
+func (record UnknownRecord) pack(e *messageEncoder) error {
+	e.buf = append(e.buf, record.Data...)
+	return nil
+}
+
+// String renders the RDATA in the generic form of RFC 3597 section 5.
+func (record UnknownRecord) String() string {
+	if len(record.Data) == 0 {
+		return `\# 0`
+	}
+	return fmt.Sprintf(`\# %d %x`, len(record.Data), record.Data)
+}
+
+// ParseGenericRData reads RDATA in the generic form of RFC 3597 section 5,
+// for example `\# 4 0a000001` split into fields. The hex data may be split
+// into several fields. Types this package knows are decoded into their own
+// RData type.
+func ParseGenericRData(t QType, fields []string) (RData, error) {
+	if len(fields) < 2 || fields[0] != `\#` {
+		return nil, fmt.Errorf("%w: generic rdata has to start with \\#", ErrSyntax)
+	}
+	length, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad rdata length %q", ErrSyntax, fields[1])
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad hex rdata: %s", ErrSyntax, err)
+	}
+	if len(data) != int(length) {
+		return nil, fmt.Errorf("%w: rdata has %d octets instead of %d", ErrSyntax, len(data), length)
+	}
+	return parseRData(t, NewLookBackBuffer(data), len(data))
+}
+
+func expectFields(t QType, fields []string, n int) error {
+	if len(fields) != n {
+		return fmt.Errorf("%w: %s needs %d fields, got %d", ErrSyntax, t, n, len(fields))
+	}
+	return nil
+}
+
+func parseUint16(s string) (uint16, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad number %q", ErrSyntax, s)
+	}
+	return uint16(n), nil
+}
+
+// parseCharacterString reads a <character-string>, quoted or not.
+func parseCharacterString(field string) (string, error) {
+	if len(field) >= 2 && field[0] == '"' && field[len(field)-1] == '"' {
+		field = field[1 : len(field)-1]
+	}
+	text, err := unescape(field)
+	if err != nil {
+		return "", err
+	}
+	if len(text) > 255 {
+		return "", fmt.Errorf("%w: string longer than 255 octets", ErrSyntax)
+	}
+	return string(text), nil
+}
@@ -0,0 +1,439 @@
+package parser
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type SvcParamKey uint16
+
+// SvcParamKeys of RFC 9460 section 14.3.2
+const (
+	SVCB_MANDATORY       SvcParamKey = 0
+	SVCB_ALPN            SvcParamKey = 1
+	SVCB_NO_DEFAULT_ALPN SvcParamKey = 2
+	SVCB_PORT            SvcParamKey = 3
+	SVCB_IPV4HINT        SvcParamKey = 4
+	SVCB_ECH             SvcParamKey = 5
+	SVCB_IPV6HINT        SvcParamKey = 6
+)
+
+var svcParamKeyNames = map[SvcParamKey]string{
+	SVCB_MANDATORY:       "mandatory",
+	SVCB_ALPN:            "alpn",
+	SVCB_NO_DEFAULT_ALPN: "no-default-alpn",
+	SVCB_PORT:            "port",
+	SVCB_IPV4HINT:        "ipv4hint",
+	SVCB_ECH:             "ech",
+	SVCB_IPV6HINT:        "ipv6hint",
+}
+
+// String returns the name of the key, or keyNNNNN for keys without one.
+func (key SvcParamKey) String() string {
+	if name, ok := svcParamKeyNames[key]; ok {
+		return name
+	}
+	return fmt.Sprintf("key%d", uint16(key))
+}
+
+func ParseSvcParamKey(s string) (SvcParamKey, error) {
+	for key, name := range svcParamKeyNames {
+		if s == name {
+			return key, nil
+		}
+	}
+	// keyNNNNN has no leading zeros, RFC 9460 section 2.1
+	number, ok := strings.CutPrefix(s, "key")
+	if !ok || number == "" || number[0] == '0' && number != "0" {
+		return 0, fmt.Errorf("%w: unknown SvcParamKey %q", ErrSyntax, s)
+	}
+	n, err := strconv.ParseUint(number, 10, 16)
+	if err != nil || n == 65535 {
+		return 0, fmt.Errorf("%w: unknown SvcParamKey %q", ErrSyntax, s)
+	}
+	return SvcParamKey(n), nil
+}
+
+// SvcParam is a key with its value in wire format.
+type SvcParam struct {
+	Key   SvcParamKey
+	Value []byte
+}
+
+// SVCBRecord is the RDATA of the SVCB record, RFC 9460 section 2.2. A
+// priority of 0 is AliasMode, everything else ServiceMode. Params are in
+// increasing order of their keys.
+type SVCBRecord struct {
+	Priority uint16
+	Target   Name
+	Params   []SvcParam
+}
+
+// HTTPSRecord has the same RDATA as SVCB, RFC 9460 section 9.
+type HTTPSRecord struct {
+	SVCBRecord
+}
+
+// Param returns the parameter with the given key.
+func (record SVCBRecord) Param(key SvcParamKey) (SvcParam, bool) {
+	for _, param := range record.Params {
+		if param.Key == key {
+			return param, true
+		}
+	}
+	return SvcParam{}, false
+}
+
+func parseSVCBRecord(buffer *MessageBuffer, length int) (RData, error) {
+	record, err := parseSVCB(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func parseHTTPSRecord(buffer *MessageBuffer, length int) (RData, error) {
+	record, err := parseSVCB(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return HTTPSRecord{record}, nil
+}
+
+func parseSVCB(buffer *MessageBuffer) (SVCBRecord, error) {
+	start := buffer.off
+	priority, err := buffer.ReadUint16()
+	if err != nil {
+		return SVCBRecord{}, err
+	}
+	target, err := buffer.ReadName()
+	if err != nil {
+		return SVCBRecord{}, err
+	}
+	record := SVCBRecord{Priority: priority, Target: target}
+	for buffer.off < len(buffer.buf) {
+		key, err := buffer.ReadUint16()
+		if err != nil {
+			return SVCBRecord{}, err
+		}
+		size, err := buffer.ReadUint16()
+		if err != nil {
+			return SVCBRecord{}, err
+		}
+		value := make([]byte, size)
+		if _, err := buffer.Read(value); err != nil {
+			return SVCBRecord{}, err
+		}
+		record.Params = append(record.Params, SvcParam{Key: SvcParamKey(key), Value: value})
+	}
+	if err := record.validate(); err != nil {
+		return SVCBRecord{}, buffer.errorAt(start, err)
+	}
+	return record, nil
+}
+
+func (record SVCBRecord) Type() QType  { return SVCB }
+func (record HTTPSRecord) Type() QType { return HTTPS }
+
+// RFC 9460 section 2.2 forbids compressing the target
+func (record SVCBRecord) pack(e *messageEncoder) error {
+	if err := record.validate(); err != nil {
+		return err
+	}
+	e.writeUint16(record.Priority)
+	if err := e.writeUncompressedName(record.Target); err != nil {
+		return err
+	}
+	for _, param := range record.Params {
+		e.writeUint16(uint16(param.Key))
+		e.writeUint16(uint16(len(param.Value)))
+		e.buf = append(e.buf, param.Value...)
+	}
+	return nil
+}
+
+// validate checks the wire format rules of RFC 9460 section 2.2: keys in
+// increasing order and values in the format of their key.
+func (record SVCBRecord) validate() error {
+	for i, param := range record.Params {
+		if i > 0 && param.Key <= record.Params[i-1].Key {
+			return fmt.Errorf("%w: %s is out of order or repeated", ErrBadSvcParams, param.Key)
+		}
+		if len(param.Value) > 0xffff {
+			return fmt.Errorf("%w: %s value too long", ErrBadSvcParams, param.Key)
+		}
+		if err := validateSvcParam(param); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the wire format and that the record is self-consistent,
+// RFC 9460 sections 2.4.3, 7.1 and 8: mandatory only lists keys present in
+// the record, but not itself, and no-default-alpn comes with alpn. Parsing
+// presentation format enforces this, parsing wire format and packing only
+// check the format, so records from elsewhere can be passed on unchanged.
+func (record SVCBRecord) Validate() error {
+	if err := record.validate(); err != nil {
+		return err
+	}
+	if param, ok := record.Param(SVCB_MANDATORY); ok {
+		for i := 0; i < len(param.Value); i += 2 {
+			key := SvcParamKey(binary.BigEndian.Uint16(param.Value[i:]))
+			if key == SVCB_MANDATORY {
+				return fmt.Errorf("%w: mandatory lists itself", ErrBadSvcParams)
+			}
+			if _, ok := record.Param(key); !ok {
+				return fmt.Errorf("%w: mandatory %s is missing", ErrBadSvcParams, key)
+			}
+		}
+	}
+	if _, ok := record.Param(SVCB_NO_DEFAULT_ALPN); ok {
+		if _, ok := record.Param(SVCB_ALPN); !ok {
+			return fmt.Errorf("%w: no-default-alpn without alpn", ErrBadSvcParams)
+		}
+	}
+	return nil
+}
+
+// validateSvcParam checks the wire format of the value of a known key.
+func validateSvcParam(param SvcParam) error {
+	value := param.Value
+	ok := true
+	switch param.Key {
+	case SVCB_MANDATORY:
+		// keys in strictly increasing order
+		ok = len(value) > 0 && len(value)%2 == 0
+		for i := 2; ok && i < len(value); i += 2 {
+			ok = binary.BigEndian.Uint16(value[i:]) > binary.BigEndian.Uint16(value[i-2:])
+		}
+	case SVCB_ALPN:
+		ok = len(value) > 0
+		for i := 0; ok && i < len(value); i += 1 + int(value[i]) {
+			ok = value[i] > 0 && i+1+int(value[i]) <= len(value)
+		}
+	case SVCB_NO_DEFAULT_ALPN:
+		ok = len(value) == 0
+	case SVCB_PORT:
+		ok = len(value) == 2
+	case SVCB_IPV4HINT:
+		ok = len(value) > 0 && len(value)%4 == 0
+	case SVCB_IPV6HINT:
+		ok = len(value) > 0 && len(value)%16 == 0
+	}
+	if !ok {
+		return fmt.Errorf("%w: bad %s value", ErrBadSvcParams, param.Key)
+	}
+	return nil
+}
+
+func (record SVCBRecord) String() string {
+	s := strings.Builder{}
+	fmt.Fprintf(&s, "%d %s", record.Priority, record.Target.String())
+	for _, param := range record.Params {
+		s.WriteString(" " + param.String())
+	}
+	return s.String()
+}
+
+// String returns the parameter in presentation format, RFC 9460 section
+// 2.1. A value that doesn't match the format of its key is written as
+// escaped octets, which can't be read back.
+func (param SvcParam) String() string {
+	value := param.Value
+	if validateSvcParam(param) != nil {
+		return param.Key.String() + `="` + escape(value, `"`) + `"`
+	}
+	switch param.Key {
+	case SVCB_MANDATORY:
+		keys := []string{}
+		for i := 0; i < len(value); i += 2 {
+			keys = append(keys, SvcParamKey(binary.BigEndian.Uint16(value[i:])).String())
+		}
+		return "mandatory=" + strings.Join(keys, ",")
+	case SVCB_ALPN:
+		// commas and backslashes inside an id are escaped before the
+		// value is escaped as a whole, RFC 9460 appendix A.1
+		ids := []string{}
+		for i := 0; i < len(value); i += 1 + int(value[i]) {
+			id := string(value[i+1 : i+1+int(value[i])])
+			id = strings.NewReplacer(`\`, `\\`, `,`, `\,`).Replace(id)
+			ids = append(ids, id)
+		}
+		return `alpn="` + escape([]byte(strings.Join(ids, ",")), `"`) + `"`
+	case SVCB_NO_DEFAULT_ALPN:
+		return "no-default-alpn"
+	case SVCB_PORT:
+		return fmt.Sprintf("port=%d", binary.BigEndian.Uint16(value))
+	case SVCB_IPV4HINT, SVCB_IPV6HINT:
+		size := 4
+		if param.Key == SVCB_IPV6HINT {
+			size = 16
+		}
+		addrs := []string{}
+		for i := 0; i < len(value); i += size {
+			addr, _ := netip.AddrFromSlice(value[i : i+size])
+			addrs = append(addrs, addr.String())
+		}
+		return param.Key.String() + "=" + strings.Join(addrs, ",")
+	case SVCB_ECH:
+		return "ech=" + base64.StdEncoding.EncodeToString(value)
+	}
+	if len(value) == 0 {
+		return param.Key.String()
+	}
+	return param.Key.String() + `="` + escape(value, `"`) + `"`
+}
+
+func parseSVCBRecordText(fields []string, origin Name) (RData, error) {
+	record, err := parseSVCBText(SVCB, fields, origin)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func parseHTTPSRecordText(fields []string, origin Name) (RData, error) {
+	record, err := parseSVCBText(HTTPS, fields, origin)
+	if err != nil {
+		return nil, err
+	}
+	return HTTPSRecord{record}, nil
+}
+
+func parseSVCBText(t QType, fields []string, origin Name) (SVCBRecord, error) {
+	if len(fields) < 2 {
+		return SVCBRecord{}, fmt.Errorf("%w: %s needs a priority and a target", ErrSyntax, t)
+	}
+	priority, err := parseUint16(fields[0])
+	if err != nil {
+		return SVCBRecord{}, err
+	}
+	target, err := parseName(fields[1], origin)
+	if err != nil {
+		return SVCBRecord{}, err
+	}
+	record := SVCBRecord{Priority: priority, Target: target}
+	for rest := fields[2:]; len(rest) > 0; rest = rest[1:] {
+		field := rest[0]
+		// the lexer ends a field at a quote, so key="value" comes in two
+		if strings.HasSuffix(field, "=") && len(rest) > 1 && strings.HasPrefix(rest[1], `"`) {
+			field += rest[1]
+			rest = rest[1:]
+		}
+		param, err := parseSvcParam(field)
+		if err != nil {
+			return SVCBRecord{}, err
+		}
+		record.Params = append(record.Params, param)
+	}
+	// keys may come in any order, but only once
+	slices.SortStableFunc(record.Params, func(a, b SvcParam) int { return int(a.Key) - int(b.Key) })
+	for i := 1; i < len(record.Params); i++ {
+		if record.Params[i].Key == record.Params[i-1].Key {
+			return SVCBRecord{}, fmt.Errorf("%w: %s given twice", ErrSyntax, record.Params[i].Key)
+		}
+	}
+	if err := record.Validate(); err != nil {
+		return SVCBRecord{}, fmt.Errorf("%w: %w", ErrSyntax, err)
+	}
+	return record, nil
+}
+
+// parseSvcParam reads key=value or a key without value.
+func parseSvcParam(field string) (SvcParam, error) {
+	name, text, hasValue := strings.Cut(field, "=")
+	key, err := ParseSvcParamKey(name)
+	if err != nil {
+		return SvcParam{}, err
+	}
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		text = text[1 : len(text)-1]
+	}
+	raw, err := unescape(text)
+	if err != nil {
+		return SvcParam{}, err
+	}
+	param := SvcParam{Key: key}
+	if key != SVCB_NO_DEFAULT_ALPN && svcParamKeyNames[key] != "" && (!hasValue || len(raw) == 0) {
+		return SvcParam{}, fmt.Errorf("%w: %s needs a value", ErrSyntax, key)
+	}
+	switch key {
+	case SVCB_MANDATORY:
+		// the keys may be listed in any order, the wire format sorts them
+		keys := []SvcParamKey{}
+		for _, item := range strings.Split(string(raw), ",") {
+			listed, err := ParseSvcParamKey(item)
+			if err != nil {
+				return SvcParam{}, err
+			}
+			keys = append(keys, listed)
+		}
+		slices.Sort(keys)
+		for i, listed := range keys {
+			if i > 0 && listed == keys[i-1] {
+				return SvcParam{}, fmt.Errorf("%w: mandatory lists %s twice", ErrSyntax, listed)
+			}
+			param.Value = binary.BigEndian.AppendUint16(param.Value, uint16(listed))
+		}
+	case SVCB_ALPN:
+		for _, id := range splitValueList(raw) {
+			if len(id) == 0 || len(id) > 255 {
+				return SvcParam{}, fmt.Errorf("%w: bad alpn id %q", ErrSyntax, id)
+			}
+			param.Value = append(param.Value, byte(len(id)))
+			param.Value = append(param.Value, id...)
+		}
+	case SVCB_NO_DEFAULT_ALPN:
+		if hasValue {
+			return SvcParam{}, fmt.Errorf("%w: no-default-alpn has no value", ErrSyntax)
+		}
+	case SVCB_PORT:
+		port, err := parseUint16(string(raw))
+		if err != nil {
+			return SvcParam{}, err
+		}
+		param.Value = binary.BigEndian.AppendUint16(nil, port)
+	case SVCB_IPV4HINT, SVCB_IPV6HINT:
+		for _, item := range strings.Split(string(raw), ",") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil || addr.Is4() != (key == SVCB_IPV4HINT) || addr.Zone() != "" {
+				return SvcParam{}, fmt.Errorf("%w: bad %s address %q", ErrSyntax, key, item)
+			}
+			param.Value = append(param.Value, addr.AsSlice()...)
+		}
+	case SVCB_ECH:
+		if param.Value, err = base64.StdEncoding.DecodeString(string(raw)); err != nil {
+			return SvcParam{}, fmt.Errorf("%w: bad ech %q", ErrSyntax, raw)
+		}
+	default:
+		param.Value = raw
+	}
+	return param, nil
+}
+
+// splitValueList splits at commas that are not escaped by a backslash,
+// RFC 9460 appendix A.1.
+func splitValueList(value []byte) [][]byte {
+	items := [][]byte{}
+	item := []byte{}
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			i++
+			item = append(item, value[i])
+		case value[i] == ',':
+			items = append(items, item)
+			item = []byte{}
+		default:
+			item = append(item, value[i])
+		}
+	}
+	return append(items, item)
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var typeNames = map[QType]string{
+	A:          "A",
+	NS:         "NS",
+	MD:         "MD",
+	MF:         "MF",
+	CNAME:      "CNAME",
+	SOA:        "SOA",
+	MB:         "MB",
+	MG:         "MG",
+	MR:         "MR",
+	NULL:       "NULL",
+	WKS:        "WKS",
+	PTR:        "PTR",
+	HINFO:      "HINFO",
+	MINFO:      "MINFO",
+	MX:         "MX",
+	TXT:        "TXT",
+	RP:         "RP",
+	AFSDB:      "AFSDB",
+	AAAA:       "AAAA",
+	LOC:        "LOC",
+	SRV:        "SRV",
+	NAPTR:      "NAPTR",
+	DNAME:      "DNAME",
+	OPT:        "OPT",
+	DS:         "DS",
+	SSHFP:      "SSHFP",
+	RRSIG:      "RRSIG",
+	NSEC:       "NSEC",
+	DNSKEY:     "DNSKEY",
+	NSEC3:      "NSEC3",
+	NSEC3PARAM: "NSEC3PARAM",
+	TLSA:       "TLSA",
+	SVCB:       "SVCB",
+	HTTPS:      "HTTPS",
+	URI:        "URI",
+	CAA:        "CAA",
+	IXFR:       "IXFR",
+	AXFR:       "AXFR",
+	MAILB:      "MAILB",
+	MAILA:      "MAILA",
+	ALL:        "ANY",
+}
+
+var classNames = map[QClass]string{
+	IN:  "IN",
+	CS:  "CS",
+	CH:  "CH",
+	HS:  "HS",
+	ANY: "ANY",
+}
+
+// String returns the mnemonic of the type, or the TYPEnnn form of RFC 3597
+// section 5 for types without one.
+func (t QType) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+func ParseQType(s string) (QType, error) {
+	for t, name := range typeNames {
+		if strings.EqualFold(s, name) {
+			return t, nil
+		}
+	}
+	n, err := parseGenericNumber(s, "TYPE")
+	if err != nil {
+		return 0, fmt.Errorf("%w: unknown type %q", ErrSyntax, s)
+	}
+	return QType(n), nil
+}
+
+// String returns the mnemonic of the class, or CLASSnnn for classes without
+// one.
+func (class QClass) String() string {
+	if name, ok := classNames[class]; ok {
+		return name
+	}
+	return fmt.Sprintf("CLASS%d", uint16(class))
+}
+
+func ParseQClass(s string) (QClass, error) {
+	for class, name := range classNames {
+		if strings.EqualFold(s, name) {
+			return class, nil
+		}
+	}
+	n, err := parseGenericNumber(s, "CLASS")
+	if err != nil {
+		return 0, fmt.Errorf("%w: unknown class %q", ErrSyntax, s)
+	}
+	return QClass(n), nil
+}
+
+func parseGenericNumber(s string, prefix string) (uint16, error) {
+	if len(s) <= len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return 0, ErrSyntax
+	}
+	n, err := strconv.ParseUint(s[len(prefix):], 10, 16)
+	return uint16(n), err
+}
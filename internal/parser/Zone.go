@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// how deep $INCLUDE may nest, which also stops files including themselves
+const maxIncludeDepth = 8
+
+type zoneParser struct {
+	records []Answer
+	// set once $TTL was seen, afterwards the TTL of a record no longer
+	// becomes the default for the next one, RFC 2308 section 4
+	dollarTTL bool
+}
+
+// ParseZone reads a master file, RFC 1035 section 5. Relative names are
+// completed with origin until $ORIGIN changes it. The class defaults to IN.
+// name is used in errors and $INCLUDE paths are relative to its directory.
+func ParseZone(text string, name string, origin Name) ([]Answer, error) {
+	p := zoneParser{}
+	defaults := recordDefaults{origin: origin, class: IN}
+	if err := p.parse(text, name, &defaults, 0); err != nil {
+		return nil, err
+	}
+	return p.records, nil
+}
+
+// ParseZoneFile reads the master file at path, see ParseZone.
+func ParseZoneFile(path string, origin Name) ([]Answer, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseZone(string(text), path, origin)
+}
+
+func (p *zoneParser) parse(text string, name string, defaults *recordDefaults, depth int) error {
+	entries, err := lex(text)
+	if err != nil {
+		var zoneError *ZoneError
+		if errors.As(err, &zoneError) {
+			zoneError.File = name
+		}
+		return err
+	}
+	for _, e := range entries {
+		if err := p.parseEntry(e, name, defaults, depth); err != nil {
+			var zoneError *ZoneError
+			if errors.As(err, &zoneError) {
+				return err
+			}
+			return &ZoneError{File: name, Line: e.line, Err: err}
+		}
+	}
+	return nil
+}
+
+func (p *zoneParser) parseEntry(e entry, name string, defaults *recordDefaults, depth int) error {
+	if e.indented || !strings.HasPrefix(e.fields[0], "$") {
+		answer, err := parseEntry(e, *defaults)
+		if err != nil {
+			return err
+		}
+		p.records = append(p.records, answer)
+		defaults.owner = answer.Name
+		defaults.class = answer.Class
+		if !p.dollarTTL {
+			defaults.ttl = answer.TTL
+			defaults.hasTTL = true
+		}
+		return nil
+	}
+
+	args := e.fields[1:]
+	switch strings.ToUpper(e.fields[0]) {
+	case "$ORIGIN":
+		if len(args) != 1 {
+			return fmt.Errorf("%w: $ORIGIN needs one name", ErrSyntax)
+		}
+		origin, err := parseName(args[0], defaults.origin)
+		if err != nil {
+			return err
+		}
+		defaults.origin = origin
+	case "$TTL":
+		if len(args) != 1 {
+			return fmt.Errorf("%w: $TTL needs one ttl", ErrSyntax)
+		}
+		ttl, err := parseTTL(args[0])
+		if err != nil {
+			return err
+		}
+		defaults.ttl = ttl
+		defaults.hasTTL = true
+		p.dollarTTL = true
+	case "$INCLUDE":
+		if len(args) != 1 && len(args) != 2 {
+			return fmt.Errorf("%w: $INCLUDE needs a file and an optional origin", ErrSyntax)
+		}
+		if depth >= maxIncludeDepth {
+			return ErrIncludeDepth
+		}
+		file, err := unescape(strings.Trim(args[0], `"`))
+		if err != nil {
+			return err
+		}
+		path := string(file)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(name), path)
+		}
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// the included file gets a copy of the defaults, so its origin and
+		// last owner don't change this file, RFC 1035 section 5.1. Its class
+		// and TTL, including a $TTL, don't come back either: a file reads
+		// the same wherever it is included.
+		included := *defaults
+		if len(args) == 2 {
+			if included.origin, err = parseName(args[1], defaults.origin); err != nil {
+				return err
+			}
+		}
+		dollarTTL := p.dollarTTL
+		if err := p.parse(string(text), path, &included, depth+1); err != nil {
+			return err
+		}
+		p.dollarTTL = dollarTTL
+	default:
+		return fmt.Errorf("%w: unknown directive %s", ErrSyntax, e.fields[0])
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+// link types of http://www.tcpdump.org/linktypes.html
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLinuxSLL = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkLoop     = 108
+	linkSLL2     = 276
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+
+	protocolTCP = 6
+	protocolUDP = 17
+
+	dnsPort = 53
+)
+
+// decode finds the DNS messages in p and queues them.
+func (reader *Reader) decode(p packet) {
+	ip, ok := linkPayload(p.linkType, p.data)
+	if !ok {
+		return
+	}
+	protocol, src, dst, payload, ok := ipPayload(ip)
+	if !ok {
+		return
+	}
+	switch protocol {
+	case protocolUDP:
+		if len(payload) < 8 {
+			return
+		}
+		srcPort := binary.BigEndian.Uint16(payload[0:])
+		dstPort := binary.BigEndian.Uint16(payload[2:])
+		length := int(binary.BigEndian.Uint16(payload[4:]))
+		if srcPort != dnsPort && dstPort != dnsPort || length < 8 || length > len(payload) {
+			return
+		}
+		reader.queue(p, "udp", netip.AddrPortFrom(src, srcPort), netip.AddrPortFrom(dst, dstPort), payload[8:length])
+	case protocolTCP:
+		reader.decodeTCP(p, src, dst, payload)
+	}
+}
+
+func (reader *Reader) queue(p packet, transport string, src netip.AddrPort, dst netip.AddrPort, raw []byte) {
+	message, err := parser.Parse(raw)
+	reader.ready = append(reader.ready, Message{
+		Time:      p.time,
+		Transport: transport,
+		Src:       src,
+		Dst:       dst,
+		Raw:       append([]byte{}, raw...),
+		Message:   message,
+		Err:       err,
+	})
+}
+
+// linkPayload strips the link layer header, leaving the IP packet.
+func linkPayload(linkType uint32, data []byte) ([]byte, bool) {
+	switch linkType {
+	case linkNull, linkLoop:
+		// the address family, in the byte order of the capturing host
+		if len(data) < 4 {
+			return nil, false
+		}
+		return data[4:], true
+	case linkEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+			if len(data) < 4 {
+				return nil, false
+			}
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+		return data, etherType == etherTypeIPv4 || etherType == etherTypeIPv6
+	case linkRaw, linkIPv4, linkIPv6:
+		return data, true
+	case linkLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		return data[16:], true
+	case linkSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		return data[20:], true
+	}
+	return nil, false
+}
+
+// ipPayload returns the transport protocol, addresses and payload of an
+// IPv4 or IPv6 packet. Fragments are not reassembled and skipped.
+func ipPayload(data []byte) (uint8, netip.Addr, netip.Addr, []byte, bool) {
+	if len(data) < 1 {
+		return 0, netip.Addr{}, netip.Addr{}, nil, false
+	}
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			break
+		}
+		headerLength := int(data[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(data[2:]))
+		fragment := binary.BigEndian.Uint16(data[6:])
+		// more fragments or a fragment offset
+		if fragment&0x3fff != 0 || headerLength < 20 || totalLength < headerLength || totalLength > len(data) {
+			break
+		}
+		src := netip.AddrFrom4([4]byte(data[12:16]))
+		dst := netip.AddrFrom4([4]byte(data[16:20]))
+		return data[9], src, dst, data[headerLength:totalLength], true
+	case 6:
+		if len(data) < 40 {
+			break
+		}
+		payloadLength := int(binary.BigEndian.Uint16(data[4:]))
+		if 40+payloadLength > len(data) {
+			break
+		}
+		next := data[6]
+		src := netip.AddrFrom16([16]byte(data[8:24]))
+		dst := netip.AddrFrom16([16]byte(data[24:40]))
+		payload := data[40 : 40+payloadLength]
+		for {
+			switch next {
+			case 0, 43, 60:
+				// hop-by-hop, routing and destination options
+				if len(payload) < 2 || len(payload) < (int(payload[1])+1)*8 {
+					return 0, netip.Addr{}, netip.Addr{}, nil, false
+				}
+				next, payload = payload[0], payload[(int(payload[1])+1)*8:]
+				continue
+			case 44:
+				// fragment
+				return 0, netip.Addr{}, netip.Addr{}, nil, false
+			}
+			return next, src, dst, payload, true
+		}
+	}
+	return 0, netip.Addr{}, netip.Addr{}, nil, false
+}
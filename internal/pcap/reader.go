@@ -0,0 +1,336 @@
+// Package pcap reads DNS messages from packet captures in the pcap and
+// pcapng formats. UDP datagrams and TCP streams from or to port 53 are
+// decoded, TCP streams are reassembled first.
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"time"
+
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+var (
+	ErrUnknownFormat = errors.New("not a pcap or pcapng file")
+	ErrBadCapture    = errors.New("malformed capture file")
+)
+
+const (
+	pcapMagic      = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+	pcapngSection  = 0x0a0d0d0a
+	pcapngByteMark = 0x1a2b3c4d
+
+	pcapngInterface      = 1
+	pcapngPacket         = 2
+	pcapngSimplePacket   = 3
+	pcapngEnhancedPacket = 6
+
+	// larger blocks are taken as a sign of a broken file
+	maxBlockLength = 16 << 20
+)
+
+// Message is a DNS message found in a capture.
+type Message struct {
+	Time time.Time
+	// "udp" or "tcp"
+	Transport string
+	Src       netip.AddrPort
+	Dst       netip.AddrPort
+	Raw       []byte
+	Message   parser.Message
+	// Err is set when Raw is not a valid message, Message then holds what
+	// could be decoded
+	Err error
+}
+
+// packet is a captured frame with the link type of its interface.
+type packet struct {
+	time     time.Time
+	linkType uint32
+	data     []byte
+}
+
+// Reader returns the DNS messages of a capture in the order their last
+// packet was captured.
+type Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	// reads the next packet of the detected format
+	next    func() (packet, error)
+	streams map[flow]*stream
+	ready   []Message
+
+	// pcap
+	linkType   uint32
+	resolution uint64
+
+	// pcapng, per interface of the current section
+	interfaces []pcapngInterfaceInfo
+}
+
+type pcapngInterfaceInfo struct {
+	linkType uint32
+	// timestamp units per second
+	resolution uint64
+}
+
+// NewReader detects the format of r and reads its file header.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: r, streams: map[flow]*stream{}}
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, ErrUnknownFormat
+	}
+	if binary.BigEndian.Uint32(magic[:]) == pcapngSection {
+		if err := reader.readSection(); err != nil {
+			return nil, err
+		}
+		reader.next = reader.nextPcapng
+		return reader, nil
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(magic[:]) {
+		case pcapMagic:
+			reader.resolution = 1e6
+		case pcapMagicNano:
+			reader.resolution = 1e9
+		default:
+			continue
+		}
+		reader.order = order
+		var header [20]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("%w: short file header", ErrBadCapture)
+		}
+		reader.linkType = order.Uint32(header[16:])
+		reader.next = reader.nextPcap
+		return reader, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// ReadAll returns every message of the capture in r.
+func ReadAll(r io.Reader) ([]Message, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	messages := []Message{}
+	for {
+		message, err := reader.Next()
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, message)
+	}
+}
+
+// Next returns the next message, or io.EOF at the end of the capture.
+// Packets that are not DNS or can't be decoded are skipped.
+func (reader *Reader) Next() (Message, error) {
+	for len(reader.ready) == 0 {
+		p, err := reader.next()
+		if err != nil {
+			return Message{}, err
+		}
+		reader.decode(p)
+	}
+	message := reader.ready[0]
+	reader.ready = reader.ready[1:]
+	return message, nil
+}
+
+func (reader *Reader) nextPcap() (packet, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(reader.r, header[:]); err == io.EOF {
+		return packet{}, io.EOF
+	} else if err != nil {
+		return packet{}, fmt.Errorf("%w: short record header", ErrBadCapture)
+	}
+	seconds := reader.order.Uint32(header[0:])
+	fraction := reader.order.Uint32(header[4:])
+	length := reader.order.Uint32(header[8:])
+	if length > maxBlockLength {
+		return packet{}, fmt.Errorf("%w: record of %d octets", ErrBadCapture, length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader.r, data); err != nil {
+		return packet{}, fmt.Errorf("%w: short record", ErrBadCapture)
+	}
+	return packet{
+		time:     timestamp(uint64(seconds)*reader.resolution+uint64(fraction), reader.resolution),
+		linkType: reader.linkType,
+		data:     data,
+	}, nil
+}
+
+// readSection reads a section header block whose type was already read.
+func (reader *Reader) readSection() error {
+	var header [8]byte
+	if _, err := io.ReadFull(reader.r, header[:]); err != nil {
+		return fmt.Errorf("%w: short section header", ErrBadCapture)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(header[4:]) == pcapngByteMark:
+		reader.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header[4:]) == pcapngByteMark:
+		reader.order = binary.BigEndian
+	default:
+		return fmt.Errorf("%w: bad byte order magic", ErrBadCapture)
+	}
+	length := reader.order.Uint32(header[:])
+	if length < 28 || length%4 != 0 || length > maxBlockLength {
+		return fmt.Errorf("%w: section header of %d octets", ErrBadCapture, length)
+	}
+	// version, section length and options are of no interest
+	if _, err := io.CopyN(io.Discard, reader.r, int64(length)-12); err != nil {
+		return fmt.Errorf("%w: short section header", ErrBadCapture)
+	}
+	reader.interfaces = nil
+	return nil
+}
+
+func (reader *Reader) nextPcapng() (packet, error) {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(reader.r, header[:]); err == io.EOF {
+			return packet{}, io.EOF
+		} else if err != nil {
+			return packet{}, fmt.Errorf("%w: short block header", ErrBadCapture)
+		}
+		blockType := reader.order.Uint32(header[:])
+		if blockType == pcapngSection {
+			// a new section may switch the byte order, so its length
+			// can only be decoded after the byte order magic
+			if err := reader.readSectionAfterType(header[4:]); err != nil {
+				return packet{}, err
+			}
+			continue
+		}
+		length := reader.order.Uint32(header[4:])
+		if length < 12 || length%4 != 0 || length > maxBlockLength {
+			return packet{}, fmt.Errorf("%w: block of %d octets", ErrBadCapture, length)
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(reader.r, body); err != nil {
+			return packet{}, fmt.Errorf("%w: short block", ErrBadCapture)
+		}
+		body = body[:len(body)-4]
+
+		switch blockType {
+		case pcapngInterface:
+			if err := reader.addInterface(body); err != nil {
+				return packet{}, err
+			}
+		case pcapngEnhancedPacket, pcapngPacket:
+			p, ok := reader.enhancedPacket(blockType, body)
+			if ok {
+				return p, nil
+			}
+		case pcapngSimplePacket:
+			if len(body) < 4 || len(reader.interfaces) == 0 {
+				continue
+			}
+			data := body[4:]
+			if length := reader.order.Uint32(body); int(length) < len(data) {
+				data = data[:length]
+			}
+			return packet{linkType: reader.interfaces[0].linkType, data: data}, nil
+		}
+	}
+}
+
+// readSectionAfterType handles a section header in the middle of a file.
+// Its byte order may differ from the one before, so the first four octets
+// after the type are passed in undecoded.
+func (reader *Reader) readSectionAfterType(length []byte) error {
+	reader.r = io.MultiReader(bytes.NewReader(length), reader.r)
+	return reader.readSection()
+}
+
+func (reader *Reader) addInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("%w: short interface description", ErrBadCapture)
+	}
+	info := pcapngInterfaceInfo{
+		linkType:   uint32(reader.order.Uint16(body)),
+		resolution: 1e6,
+	}
+	options := body[8:]
+	for len(options) >= 4 {
+		code := reader.order.Uint16(options)
+		length := int(reader.order.Uint16(options[2:]))
+		if 4+length > len(options) {
+			break
+		}
+		value := options[4 : 4+length]
+		// if_tsresol, draft-ietf-opsawg-pcapng section 4.2
+		if code == 9 && length == 1 {
+			exponent := uint64(value[0] & 0x7f)
+			if value[0]&0x80 != 0 {
+				if exponent > 63 {
+					return fmt.Errorf("%w: timestamp resolution 2^-%d", ErrBadCapture, exponent)
+				}
+				info.resolution = 1 << exponent
+			} else {
+				if exponent > 19 {
+					return fmt.Errorf("%w: timestamp resolution 10^-%d", ErrBadCapture, exponent)
+				}
+				info.resolution = 1
+				for range exponent {
+					info.resolution *= 10
+				}
+			}
+		}
+		if code == 0 {
+			break
+		}
+		options = options[4+(length+3)&^3:]
+	}
+	reader.interfaces = append(reader.interfaces, info)
+	return nil
+}
+
+// enhancedPacket decodes an enhanced packet block or the obsolete packet
+// block, which only differs in the size of the interface ID.
+func (reader *Reader) enhancedPacket(blockType uint32, body []byte) (packet, bool) {
+	if len(body) < 20 {
+		return packet{}, false
+	}
+	id := int(reader.order.Uint32(body))
+	if blockType == pcapngPacket {
+		id = int(reader.order.Uint16(body))
+	}
+	if id >= len(reader.interfaces) {
+		return packet{}, false
+	}
+	info := reader.interfaces[id]
+	ts := uint64(reader.order.Uint32(body[4:]))<<32 | uint64(reader.order.Uint32(body[8:]))
+	length := int(reader.order.Uint32(body[12:]))
+	if 20+length > len(body) {
+		return packet{}, false
+	}
+	return packet{
+		time:     timestamp(ts, info.resolution),
+		linkType: info.linkType,
+		data:     body[20 : 20+length],
+	}, true
+}
+
+// timestamp converts ts in units of 1/resolution seconds since the epoch.
+func timestamp(ts uint64, resolution uint64) time.Time {
+	seconds := ts / resolution
+	hi, lo := bits.Mul64(ts%resolution, 1e9)
+	nanoseconds, _ := bits.Div64(hi, lo, resolution)
+	return time.Unix(int64(seconds), int64(nanoseconds)).UTC()
+}
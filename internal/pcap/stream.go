@@ -0,0 +1,128 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+
+	// how much out of order data a stream may hold before it is dropped
+	maxPending = 1 << 20
+)
+
+// flow is one direction of a TCP connection.
+type flow struct {
+	src netip.AddrPort
+	dst netip.AddrPort
+}
+
+// stream puts the segments of a flow back in order. DNS messages on TCP
+// are prefixed by their length, RFC 1035 section 4.2.2.
+type stream struct {
+	// the sequence number of the next octet expected
+	next uint32
+	buf  []byte
+	// segments that came before the ones preceding them, by sequence number
+	pending     map[uint32][]byte
+	pendingSize int
+}
+
+func (reader *Reader) decodeTCP(p packet, src netip.Addr, dst netip.Addr, segment []byte) {
+	if len(segment) < 20 {
+		return
+	}
+	srcPort := binary.BigEndian.Uint16(segment[0:])
+	dstPort := binary.BigEndian.Uint16(segment[2:])
+	if srcPort != dnsPort && dstPort != dnsPort {
+		return
+	}
+	seq := binary.BigEndian.Uint32(segment[4:])
+	dataOffset := int(segment[12]>>4) * 4
+	flags := segment[13]
+	if dataOffset < 20 || dataOffset > len(segment) {
+		return
+	}
+	data := segment[dataOffset:]
+	key := flow{netip.AddrPortFrom(src, srcPort), netip.AddrPortFrom(dst, dstPort)}
+
+	s, ok := reader.streams[key]
+	if !ok || flags&tcpSYN != 0 {
+		// without the SYN the capture started in the middle of the
+		// connection, hope it is at the start of a message
+		s = &stream{next: seq, pending: map[uint32][]byte{}}
+		if flags&tcpSYN != 0 {
+			s.next = seq + 1
+		}
+		reader.streams[key] = s
+	}
+	if flags&tcpSYN == 0 {
+		s.add(seq, data)
+	}
+	for {
+		raw, ok := s.message()
+		if !ok {
+			break
+		}
+		reader.queue(p, "tcp", key.src, key.dst, raw)
+	}
+	if flags&(tcpFIN|tcpRST) != 0 || s.pendingSize > maxPending {
+		delete(reader.streams, key)
+	}
+}
+
+// add places the segment starting at seq, dropping what was seen before.
+func (s *stream) add(seq uint32, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if ahead := int32(seq - s.next); ahead > 0 {
+		if _, ok := s.pending[seq]; !ok {
+			s.pending[seq] = append([]byte{}, data...)
+			s.pendingSize += len(data)
+		}
+		return
+	}
+	s.append(seq, data)
+	for len(s.pending) > 0 {
+		found := false
+		for seq, data := range s.pending {
+			if int32(seq-s.next) <= 0 {
+				delete(s.pending, seq)
+				s.pendingSize -= len(data)
+				s.append(seq, data)
+				found = true
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// append adds the part of data starting at seq that is new.
+func (s *stream) append(seq uint32, data []byte) {
+	seen := int(s.next - seq)
+	if seen >= len(data) {
+		return
+	}
+	s.buf = append(s.buf, data[seen:]...)
+	s.next += uint32(len(data) - seen)
+}
+
+// message takes the next complete message off the stream.
+func (s *stream) message() ([]byte, bool) {
+	if len(s.buf) < 2 {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(s.buf))
+	if len(s.buf) < 2+length {
+		return nil, false
+	}
+	raw := s.buf[2 : 2+length]
+	s.buf = s.buf[2+length:]
+	return raw, true
+}
@@ -1,61 +1,352 @@
 package main
 
 import (
-	"bytes"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/pascal-sochacki/dns/internal/cookie"
+	"github.com/pascal-sochacki/dns/internal/dnssec"
 	"github.com/pascal-sochacki/dns/internal/parser"
 )
 
+const (
+	headerLength = 12
+	// the RFC 1035 limit for clients without EDNS
+	minUDPSize = 512
+	// what we advertise, as recommended by DNS flag day 2020
+	maxUDPSize = 1232
+	// large enough for any request we are willing to read
+	readBufferSize = 4096
+)
+
 func main() {
-	conn, err := net.ListenPacket("udp", ":53")
+	zoneFile := flag.String("zone", "", "master file to serve, without one every A question is answered with 1.1.1.1")
+	origin := flag.String("origin", ".", "origin for relative names in the master file")
+	keyFiles := flag.String("keys", "", "comma separated key files to sign the zone with, like Kexample.+013+12345 for the .key and .private files")
+	nsec3 := flag.Bool("nsec3", false, "deny with NSEC3 instead of NSEC when signing, without salt and extra iterations")
+	anchorFile := flag.String("anchor", "", "DS or DNSKEY records to validate the zone with, answers that validate get the AD bit")
+	flag.Parse()
+
+	answer := fixedAnswer
+	if *zoneFile != "" {
+		originName, err := parser.ParseName(*origin)
+		if err != nil {
+			slog.Error("bad origin", "err", err)
+			os.Exit(1)
+		}
+		records, err := parser.ParseZoneFile(*zoneFile, originName)
+		if err != nil {
+			slog.Error("loading zone", "err", err)
+			os.Exit(1)
+		}
+		slog.Info("loaded zone", "file", *zoneFile, "records", len(records))
+		z := newZone(records)
+		if *keyFiles != "" {
+			signer, err := loadSigner(strings.Split(*keyFiles, ","), records, *nsec3)
+			if err != nil {
+				slog.Error("loading keys", "err", err)
+				os.Exit(1)
+			}
+			z.sign(signer)
+		}
+		if *anchorFile != "" {
+			anchors, err := loadAnchors(*anchorFile)
+			if err != nil {
+				slog.Error("loading trust anchors", "err", err)
+				os.Exit(1)
+			}
+			z.validator = dnssec.NewValidator(z, anchors)
+		}
+		answer = z.answer
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 53})
 	if err != nil {
 		os.Exit(1)
 	}
 	defer conn.Close()
+	server := newServer(answer)
+	// handle is done with the request before the next one is read
+	request := make([]byte, readBufferSize)
 	for {
-		request := make([]byte, 1024)
-		_, addr, _ := conn.ReadFrom(request)
-		requestheader, resquestquestion, _ := ParseMessage(request)
-
-		response := new(bytes.Buffer)
-		responseHeader := parser.Header{
-			ID:            requestheader.ID,
-			QuestionCount: 1,
-			AnswerCount:   1,
+		n, addr, err := conn.ReadFromUDPAddrPort(request)
+		if err != nil {
+			continue
 		}
-		request, _ = responseHeader.ToBinary()
-		response.Write(request)
+		response := server.handle(request[:n], addr.Addr())
+		if response == nil {
+			slog.Info("dropping request", "addr", addr)
+			continue
+		}
+		conn.WriteToUDPAddrPort(response, addr)
+	}
 
-		question, _ := resquestquestion.ToBinary()
-		response.Write(question)
+}
 
-		answer := parser.Answer{
-			Labels: resquestquestion.Labels,
-			Type:   resquestquestion.Type,
-			Class:  resquestquestion.Class,
-			TTL:    3600,
-			Data:   []byte{1, 1, 1, 1},
+// loadAnchors reads the DS and DNSKEY records of a master file, names are
+// relative to the root.
+func loadAnchors(file string) ([]parser.Answer, error) {
+	records, err := parser.ParseZoneFile(file, parser.Name{})
+	if err != nil {
+		return nil, err
+	}
+	anchors := []parser.Answer{}
+	for _, record := range records {
+		if record.Type == parser.DS || record.Type == parser.DNSKEY {
+			anchors = append(anchors, record)
 		}
-		answerBuf, _ := answer.ToBinary()
-		response.Write(answerBuf)
-		conn.WriteTo(response.Bytes(), addr)
 	}
+	if len(anchors) == 0 {
+		return nil, fmt.Errorf("no DS or DNSKEY records in %s", file)
+	}
+	return anchors, nil
+}
 
+// loadSigner reads the keys, all of them have to be for the same zone.
+func loadSigner(prefixes []string, records []parser.Answer, nsec3 bool) (*dnssec.Signer, error) {
+	keys := []dnssec.Key{}
+	for _, prefix := range prefixes {
+		key, err := dnssec.LoadKey(prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	var params *parser.NSEC3PARAMRecord
+	if nsec3 {
+		// RFC 9276 section 3.1
+		params = &parser.NSEC3PARAMRecord{HashAlgorithm: parser.NSEC3_SHA1}
+	}
+	return dnssec.NewSigner(keys[0].Owner, keys, records, params)
 }
 
-func ParseMessage(buf []byte) (parser.Header, parser.Question, []parser.Answer) {
-	buffer := parser.NewLookBackBuffer(buf)
+// how often the secret for server cookies is replaced
+const cookieRotation = 24 * time.Hour
 
-	header := parser.ParseHeader(buffer)
-	question := parser.ParseQuestion(buffer)
+type server struct {
+	answer  Answerer
+	cookies *cookie.Server
+	now     func() time.Time
+}
 
-	ns := []parser.Answer{}
-	for i := 0; i < int(header.NSCount); i++ {
-		ns = append(ns, parser.ParseAnswer(buffer))
+func newServer(answer Answerer) *server {
+	return &server{
+		answer:  answer,
+		cookies: cookie.NewServer(cookieRotation),
+		now:     time.Now,
+	}
+}
+
+// handle builds the response for a single request from addr. It returns nil
+// when the request is too broken to answer at all, or isn't a query.
+func (s *server) handle(request []byte, addr netip.Addr) []byte {
+	message, err := parser.Parse(request)
+	if err != nil {
+		slog.Warn("malformed request", "err", err)
+		// answering a response could start a loop between two servers
+		if len(request) < headerLength || request[2]&0x80 != 0 {
+			return nil
+		}
+		return formatError(message, nil)
+	}
+	if !message.Header.IsQuery {
+		slog.Warn("dropped response", "addr", addr)
+		return nil
 	}
+
+	response := message.Reply()
+	// RA stays clear, the server only answers for its own zone
+	response.Header.AuthoritativeAnswer = true
+
+	limit := minUDPSize
+	edns, hasEDNS := message.EDNS()
+	var responseEDNS *parser.EDNS
+	var subnet *parser.ClientSubnet
+	hasCookie := false
+	if hasEDNS {
+		if edns.Version != 0 {
+			response.SetRcode(parser.BADVERS).SetEDNS(parser.EDNS{UDPSize: maxUDPSize})
+			return pack(*response, minUDPSize)
+		}
+		limit = min(max(int(edns.UDPSize), minUDPSize), maxUDPSize)
+		responseEDNS = &parser.EDNS{UDPSize: maxUDPSize, DNSSECOK: edns.DNSSECOK}
+
+		if option, ok := edns.Option(parser.COOKIE); ok {
+			hasCookie = true
+			requestCookie, err := parser.ParseCookie(option)
+			if err != nil {
+				slog.Warn("malformed cookie", "err", err)
+				return formatError(message, responseEDNS)
+			}
+			responseCookie, valid := s.serverCookie(requestCookie, addr)
+			responseEDNS.Options = append(responseEDNS.Options, responseCookie)
+			// RFC 7873 section 5.2.3, a server cookie we can't verify
+			// is either forged or too old
+			if !valid {
+				response.SetRcode(parser.BADCOOKIE).SetEDNS(*responseEDNS)
+				return pack(*response, limit)
+			}
+		}
+
+		if option, ok := edns.Option(parser.CLIENT_SUBNET); ok {
+			clientSubnet, err := parser.ParseClientSubnet(option)
+			if err != nil {
+				slog.Warn("malformed client subnet", "err", err)
+				return formatError(message, responseEDNS)
+			}
+			subnet = &clientSubnet
+		}
+	}
+
+	if message.Header.OPCODE != parser.QUERY {
+		response.SetRcode(parser.NOT_IMPLEMENTED)
+		if responseEDNS != nil {
+			ede := parser.ExtendedError{InfoCode: parser.EDE_NOT_SUPPORTED, ExtraText: "only QUERY is supported"}
+			responseEDNS.Options = append(responseEDNS.Options, ede.Option())
+			response.SetEDNS(*responseEDNS)
+		}
+		return pack(*response, limit)
+	}
+
+	// RFC 9619: a query has exactly one question. The only exception is a
+	// query for a fresh server cookie, RFC 7873 section 5.4, which has none.
+	if len(message.Questions) == 0 && hasCookie {
+		response.SetEDNS(*responseEDNS)
+		return pack(*response, limit)
+	}
+	if len(message.Questions) != 1 {
+		slog.Warn("refusing question count", "count", len(message.Questions))
+		return formatError(message, responseEDNS)
+	}
+
+	question := message.Questions[0]
 	slog.Info("question", "type", question.Type)
-	return header, question, ns
+	result := s.answer(Query{Question: question, ClientSubnet: subnet, DNSSECOK: hasEDNS && edns.DNSSECOK})
+	response.AddAnswer(result.Answers...)
+	response.AddAuthority(result.Authority...)
+	response.AddAdditional(result.Additional...)
+	response.SetRcode(result.ResponseCode)
+	// a CNAME before the referral is still our own data, RFC 1034 section
+	// 4.3.2
+	if result.Referral && len(result.Answers) == 0 {
+		response.Header.AuthoritativeAnswer = false
+	}
+	// only clients that show they understand the AD bit get it, RFC 6840
+	// section 5.7
+	if result.Authentic && (message.Header.AuthenticData || hasEDNS && edns.DNSSECOK) {
+		response.Header.AuthenticData = true
+	}
+
+	if responseEDNS != nil {
+		if result.Error != nil {
+			responseEDNS.Options = append(responseEDNS.Options, result.Error.Option())
+		}
+		if subnet != nil {
+			responseEDNS.Options = append(responseEDNS.Options, echoClientSubnet(*subnet, result.Scope))
+		}
+		response.SetEDNS(*responseEDNS)
+	}
+	return pack(*response, limit)
+}
+
+// serverCookie returns the cookie option of the response. It is false if the
+// request carried a server cookie that isn't valid.
+func (s *server) serverCookie(request parser.Cookie, addr netip.Addr) (parser.EDNSOption, bool) {
+	now := s.now()
+	valid, refresh := s.cookies.Verify(request, addr, now)
+	response := parser.Cookie{Client: request.Client, Server: request.Server}
+	if !valid || refresh {
+		response.Server = s.cookies.Generate(request.Client, addr, now)
+	}
+	option, err := response.Option()
+	if err != nil {
+		slog.Error("encoding cookie", "err", err)
+	}
+	return option, valid || len(request.Server) == 0
+}
+
+// echoClientSubnet returns the client subnet option of a response, RFC 7871
+// section 7.2.1: the request's family, source prefix and address with the
+// scope of the answer.
+func echoClientSubnet(subnet parser.ClientSubnet, scope uint8) parser.EDNSOption {
+	if subnet.Prefix.Bits() == 0 {
+		scope = 0
+	}
+	subnet.Scope = scope
+	option, err := subnet.Option()
+	if err != nil {
+		// the subnet came from a valid option, so this can't happen
+		slog.Error("echoing client subnet", "err", err)
+	}
+	return option
+}
+
+func formatError(request parser.Message, edns *parser.EDNS) []byte {
+	response := parser.Message{
+		Header: parser.Header{
+			ID:           request.Header.ID,
+			OPCODE:       request.Header.OPCODE,
+			ResponseCode: parser.FORMAT_ERROR,
+		},
+	}
+	if edns != nil {
+		response.SetEDNS(*edns)
+	}
+	return pack(response, minUDPSize)
+}
+
+// pack encodes the response. If it is larger than limit, all records but the
+// OPT record are dropped and the TC bit tells the client to retry over TCP.
+func pack(response parser.Message, limit int) []byte {
+	buf, err := response.Pack()
+	if err != nil {
+		slog.Error("packing response", "err", err)
+		return serverFailure(response, parser.ExtendedError{
+			InfoCode:  parser.EDE_OTHER,
+			ExtraText: "response could not be encoded",
+		})
+	}
+	if len(buf) <= limit {
+		return buf
+	}
+
+	edns, hasEDNS := response.EDNS()
+	response.Header.TrunCation = true
+	response.Answers = nil
+	response.Authority = nil
+	response.Additional = nil
+	if hasEDNS {
+		response.SetEDNS(edns)
+	}
+	buf, err = response.Pack()
+	if err != nil {
+		slog.Error("packing truncated response", "err", err)
+		return nil
+	}
+	return buf
+}
+
+// serverFailure replaces a response that can't be sent by a SERVFAIL that
+// explains why, if the client supports EDNS.
+func serverFailure(response parser.Message, ede parser.ExtendedError) []byte {
+	failure := response.Reply().SetRcode(parser.SERVER_FAILURE)
+	if edns, ok := response.EDNS(); ok {
+		options := []parser.EDNSOption{ede.Option()}
+		if option, ok := edns.Option(parser.COOKIE); ok {
+			options = append(options, option)
+		}
+		failure.SetEDNS(parser.EDNS{UDPSize: edns.UDPSize, DNSSECOK: edns.DNSSECOK, Options: options})
+	}
+	buf, err := failure.Pack()
+	if err != nil {
+		slog.Error("packing server failure", "err", err)
+		return nil
+	}
+	return buf
 }
@@ -1,11 +1,130 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/pascal-sochacki/dns/internal/cookie"
+	"github.com/pascal-sochacki/dns/internal/dnssec"
 	"github.com/pascal-sochacki/dns/internal/parser"
+	"github.com/pascal-sochacki/dns/internal/pcap"
 )
 
+var clientAddr = netip.MustParseAddr("198.51.100.100")
+
+// a referral for eu. from a root server
+var euReferral = []byte{
+	0b00000000, 0b00001100,
+	0b10000000, 0b00000000,
+	0b00000000, 0b00000001,
+	0b00000000, 0b00000000,
+	0b00000000, 0b00000101,
+	0b00000000, 0b00001001,
+
+	0b00000010, 0b01100101, 0b01110101,
+	0b00000000,
+	0b00000000, 0b00000001,
+	0b00000000, 0b00000001,
+
+	// compression with offset
+	0b11000000, 0b00001100,
+
+	0b00000000, 0b00000010, 0b00000000, 0b00000001,
+	0b00000000, 0b00000010, 0b10100011, 0b00000000,
+	0b00000000, 0b00001000, 0b00000001, 0b01110111,
+	0b00000011, 0b01100100, 0b01101110, 0b01110011,
+	0b11000000, 0b00001100, 0b11000000, 0b00001100,
+	0b00000000, 0b00000010, 0b00000000, 0b00000001,
+	0b00000000, 0b00000010, 0b10100011, 0b00000000,
+	0b00000000, 0b00000100, 0b00000001, 0b01111000,
+	0b11000000, 0b00100010, 0b11000000, 0b00001100,
+	0b00000000, 0b00000010, 0b00000000, 0b00000001,
+	0b00000000, 0b00000010, 0b10100011, 0b00000000,
+	0b00000000, 0b00000100, 0b00000001, 0b01111001,
+	0b11000000, 0b00100010, 0b11000000, 0b00001100,
+	0b00000000, 0b00000010, 0b00000000, 0b00000001,
+	0b00000000, 0b00000010, 0b10100011, 0b00000000,
+	0b00000000, 0b00000101, 0b00000010, 0b01100010,
+	0b01100101, 0b11000000, 0b00100010, 0b11000000,
+	0b00001100, 0b00000000, 0b00000010, 0b00000000,
+	0b00000001, 0b00000000, 0b00000010, 0b10100011,
+	0b00000000, 0b00000000, 0b00000101, 0b00000010,
+	0b01110011, 0b01101001, 0b11000000, 0b00100010,
+	0b11000000, 0b00100000, 0b00000000, 0b00000001,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00000100,
+	0b11000010, 0b00000000, 0b00011001, 0b00011100,
+	0b11000000, 0b00110100, 0b00000000, 0b00000001,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00000100,
+	0b10111001, 0b10010111, 0b10001101, 0b00000001,
+	0b11000000, 0b01000100, 0b00000000, 0b00000001,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00000100,
+	0b11000010, 0b10010010, 0b01101010, 0b01011010,
+	0b11000000, 0b01010100, 0b00000000, 0b00000001,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00000100,
+	0b10010101, 0b00100110, 0b00000001, 0b00011010,
+	0b11000000, 0b01100101, 0b00000000, 0b00000001,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00000100,
+	0b11000001, 0b00000010, 0b11011101, 0b00111110,
+	0b11000000, 0b00100000, 0b00000000, 0b00011100,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00010000,
+	0b00100000, 0b00000001, 0b00000110, 0b01111000,
+	0b00000000, 0b00100000, 0b00000000, 0b00000000,
+	0b00000000, 0b00000000, 0b00000000, 0b00000000,
+	0b00000000, 0b00000000, 0b00000000, 0b00101000,
+	0b11000000, 0b00110100, 0b00000000, 0b00011100,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00010000,
+	0b00101010, 0b00000010, 0b00000101, 0b01101000,
+	0b11111110, 0b00000000, 0b00000000, 0b00000000,
+	0b00000000, 0b00000000, 0b00000000, 0b00000000,
+	0b00000000, 0b00000000, 0b01100101, 0b01110101,
+	0b11000000, 0b01000100, 0b00000000, 0b00011100,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00010000,
+	0b00100000, 0b00000001, 0b00000110, 0b01111100,
+	0b00010000, 0b00010000, 0b00000000, 0b00100011,
+	0b00000000, 0b00000000, 0b00000000, 0b00000000,
+	0b00000000, 0b00000000, 0b00000000, 0b01010011,
+	0b11000000, 0b01100101, 0b00000000, 0b00011100,
+	0b00000000, 0b00000001, 0b00000000, 0b00000010,
+	0b10100011, 0b00000000, 0b00000000, 0b00010000,
+	0b00100000, 0b00000001, 0b00010100, 0b01110000,
+	0b10000000, 0b00000000, 0b00000001, 0b00000000,
+	0b00000000, 0b00000000, 0b00000000, 0b00000000,
+	0b00000000, 0b00000000, 0b00000000, 0b01100010,
+	0b00000000, 0b00000000, 0b00000000, 0b00000000,
+	0b00000000, 0b00000000, 0b00000000, 0b00000000,
+	0b00000000,
+}
+
 func TestParseMessage(t *testing.T) {
 	tests := []struct {
 		input              []byte
@@ -46,7 +165,7 @@ func TestParseMessage(t *testing.T) {
 			question: parser.Question{
 				Class: parser.IN,
 				Type:  parser.A,
-				Labels: []string{
+				Name: parser.Name{
 					"blog",
 					"example",
 					"com",
@@ -54,95 +173,7 @@ func TestParseMessage(t *testing.T) {
 			},
 		},
 		{
-			input: []byte{
-				0b00000000, 0b00001100,
-				0b10000000, 0b00000000,
-				0b00000000, 0b00000001,
-				0b00000000, 0b00000000,
-				0b00000000, 0b00000101,
-				0b00000000, 0b00001001,
-
-				0b00000010, 0b01100101, 0b01110101,
-				0b00000000,
-				0b00000000, 0b00000001,
-				0b00000000, 0b00000001,
-
-				// compression with offset
-				0b11000000, 0b00001100,
-
-				0b00000000, 0b00000010, 0b00000000, 0b00000001,
-				0b00000000, 0b00000010, 0b10100011, 0b00000000,
-				0b00000000, 0b00001000, 0b00000001, 0b01110111,
-				0b00000011, 0b01100100, 0b01101110, 0b01110011,
-				0b11000000, 0b00001100, 0b11000000, 0b00001100,
-				0b00000000, 0b00000010, 0b00000000, 0b00000001,
-				0b00000000, 0b00000010, 0b10100011, 0b00000000,
-				0b00000000, 0b00000100, 0b00000001, 0b01111000,
-				0b11000000, 0b00100010, 0b11000000, 0b00001100,
-				0b00000000, 0b00000010, 0b00000000, 0b00000001,
-				0b00000000, 0b00000010, 0b10100011, 0b00000000,
-				0b00000000, 0b00000100, 0b00000001, 0b01111001,
-				0b11000000, 0b00100010, 0b11000000, 0b00001100,
-				0b00000000, 0b00000010, 0b00000000, 0b00000001,
-				0b00000000, 0b00000010, 0b10100011, 0b00000000,
-				0b00000000, 0b00000101, 0b00000010, 0b01100010,
-				0b01100101, 0b11000000, 0b00100010, 0b11000000,
-				0b00001100, 0b00000000, 0b00000010, 0b00000000,
-				0b00000001, 0b00000000, 0b00000010, 0b10100011,
-				0b00000000, 0b00000000, 0b00000101, 0b00000010,
-				0b01110011, 0b01101001, 0b11000000, 0b00100010,
-				0b11000000, 0b00100000, 0b00000000, 0b00000001,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00000100,
-				0b11000010, 0b00000000, 0b00011001, 0b00011100,
-				0b11000000, 0b00110100, 0b00000000, 0b00000001,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00000100,
-				0b10111001, 0b10010111, 0b10001101, 0b00000001,
-				0b11000000, 0b01000100, 0b00000000, 0b00000001,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00000100,
-				0b11000010, 0b10010010, 0b01101010, 0b01011010,
-				0b11000000, 0b01010100, 0b00000000, 0b00000001,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00000100,
-				0b10010101, 0b00100110, 0b00000001, 0b00011010,
-				0b11000000, 0b01100101, 0b00000000, 0b00000001,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00000100,
-				0b11000001, 0b00000010, 0b11011101, 0b00111110,
-				0b11000000, 0b00100000, 0b00000000, 0b00011100,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00010000,
-				0b00100000, 0b00000001, 0b00000110, 0b01111000,
-				0b00000000, 0b00100000, 0b00000000, 0b00000000,
-				0b00000000, 0b00000000, 0b00000000, 0b00000000,
-				0b00000000, 0b00000000, 0b00000000, 0b00101000,
-				0b11000000, 0b00110100, 0b00000000, 0b00011100,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00010000,
-				0b00101010, 0b00000010, 0b00000101, 0b01101000,
-				0b11111110, 0b00000000, 0b00000000, 0b00000000,
-				0b00000000, 0b00000000, 0b00000000, 0b00000000,
-				0b00000000, 0b00000000, 0b01100101, 0b01110101,
-				0b11000000, 0b01000100, 0b00000000, 0b00011100,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00010000,
-				0b00100000, 0b00000001, 0b00000110, 0b01111100,
-				0b00010000, 0b00010000, 0b00000000, 0b00100011,
-				0b00000000, 0b00000000, 0b00000000, 0b00000000,
-				0b00000000, 0b00000000, 0b00000000, 0b01010011,
-				0b11000000, 0b01100101, 0b00000000, 0b00011100,
-				0b00000000, 0b00000001, 0b00000000, 0b00000010,
-				0b10100011, 0b00000000, 0b00000000, 0b00010000,
-				0b00100000, 0b00000001, 0b00010100, 0b01110000,
-				0b10000000, 0b00000000, 0b00000001, 0b00000000,
-				0b00000000, 0b00000000, 0b00000000, 0b00000000,
-				0b00000000, 0b00000000, 0b00000000, 0b01100010,
-				0b00000000, 0b00000000, 0b00000000, 0b00000000,
-				0b00000000, 0b00000000, 0b00000000, 0b00000000,
-				0b00000000,
-			},
+			input: euReferral,
 			header: parser.Header{
 				ID:            12,
 				QuestionCount: 1,
@@ -150,58 +181,116 @@ func TestParseMessage(t *testing.T) {
 				ARCount:       9,
 			},
 			question: parser.Question{
-				Class:  parser.IN,
-				Type:   parser.A,
-				Labels: []string{"eu"},
+				Class: parser.IN,
+				Type:  parser.A,
+				Name:  parser.Name{"eu"},
 			},
 			nameserverResouece: []parser.Answer{
 				{
-					Labels: []string{"eu"},
-					Type:   parser.NS,
-					Class:  parser.IN,
-					TTL:    172800,
-					Data:   []byte{1, 'w', 3, 'd', 'n', 's', 2, 'e', 'u', 0},
+					Name:  parser.Name{"eu"},
+					Type:  parser.NS,
+					Class: parser.IN,
+					TTL:   172800,
+					Data:  parser.NSRecord{Host: parser.Name{"w", "dns", "eu"}},
 				},
 				{
-					Labels: []string{"eu"},
-					Type:   parser.NS,
-					Class:  parser.IN,
-					TTL:    172800,
-					Data:   []byte{1, 'x', 3, 'd', 'n', 's', 2, 'e', 'u', 0},
+					Name:  parser.Name{"eu"},
+					Type:  parser.NS,
+					Class: parser.IN,
+					TTL:   172800,
+					Data:  parser.NSRecord{Host: parser.Name{"x", "dns", "eu"}},
 				},
 				{
-					Labels: []string{"eu"},
-					Type:   parser.NS,
-					Class:  parser.IN,
-					TTL:    172800,
-					Data:   []byte{1, 'y', 3, 'd', 'n', 's', 2, 'e', 'u', 0},
+					Name:  parser.Name{"eu"},
+					Type:  parser.NS,
+					Class: parser.IN,
+					TTL:   172800,
+					Data:  parser.NSRecord{Host: parser.Name{"y", "dns", "eu"}},
 				},
 				{
-					Labels: []string{"eu"},
-					Type:   parser.NS,
-					Class:  parser.IN,
-					TTL:    172800,
-					Data:   []byte{2, 'b', 'e', 3, 'd', 'n', 's', 2, 'e', 'u', 0},
+					Name:  parser.Name{"eu"},
+					Type:  parser.NS,
+					Class: parser.IN,
+					TTL:   172800,
+					Data:  parser.NSRecord{Host: parser.Name{"be", "dns", "eu"}},
 				},
 				{
-					Labels: []string{"eu"},
-					Type:   parser.NS,
-					Class:  parser.IN,
-					TTL:    172800,
-					Data:   []byte{2, 's', 'i', 3, 'd', 'n', 's', 2, 'e', 'u', 0},
+					Name:  parser.Name{"eu"},
+					Type:  parser.NS,
+					Class: parser.IN,
+					TTL:   172800,
+					Data:  parser.NSRecord{Host: parser.Name{"si", "dns", "eu"}},
 				},
 			},
 		},
 	}
 	for _, test := range tests {
-		header, question, ns := ParseMessage(test.input)
-		compareHeaders(t, header, test.header)
-		compareQuestion(t, question, test.question)
+		message, err := parser.Parse(test.input)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		compareHeaders(t, message.Header, test.header)
+		compareQuestion(t, message.Questions[0], test.question)
 
-		for i := 0; i < int(header.NSCount); i++ {
-			compareAnswer(t, ns[i], test.nameserverResouece[i])
+		if len(message.Authority) != int(message.Header.NSCount) {
+			t.Fatalf("authority length dont match is %d wanted %d", len(message.Authority), message.Header.NSCount)
+		}
+		for i := 0; i < int(message.Header.NSCount); i++ {
+			compareAnswer(t, message.Authority[i], test.nameserverResouece[i])
 		}
+		if len(message.Additional) != int(message.Header.ARCount) {
+			t.Fatalf("additional length dont match is %d wanted %d", len(message.Additional), message.Header.ARCount)
+		}
+	}
+}
+
+func TestMessagePack(t *testing.T) {
+	message := parser.Message{
+		Header: parser.Header{
+			ID: 7,
+			// counts are derived from the sections
+			QuestionCount: 5,
+		},
+		Questions: []parser.Question{
+			{Name: parser.Name{"example", "com"}, Type: parser.A, Class: parser.IN},
+		},
+		Answers: []parser.Answer{
+			{Name: parser.Name{"example", "com"}, Type: parser.A, Class: parser.IN, TTL: 60, Data: parser.ARecord{Addr: netip.MustParseAddr("1.1.1.1")}},
+			{Name: parser.Name{"example", "com"}, Type: parser.A, Class: parser.IN, TTL: 60, Data: parser.ARecord{Addr: netip.MustParseAddr("1.0.0.1")}},
+		},
+		Authority: []parser.Answer{
+			{Name: parser.Name{"com"}, Type: parser.NS, Class: parser.IN, TTL: 120, Data: parser.NSRecord{Host: parser.Name{"a", "com"}}},
+		},
+		Additional: []parser.Answer{
+			{Name: parser.Name{"a", "com"}, Type: parser.A, Class: parser.IN, TTL: 120, Data: parser.ARecord{Addr: netip.MustParseAddr("2.2.2.2")}},
+		},
+	}
+	buf, err := message.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	is, err := parser.Parse(buf)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareHeaders(t, is.Header, parser.Header{
+		ID:            7,
+		QuestionCount: 1,
+		AnswerCount:   2,
+		NSCount:       1,
+		ARCount:       1,
+	})
+	compareQuestion(t, is.Questions[0], message.Questions[0])
+	for i := range message.Answers {
+		compareAnswer(t, is.Answers[i], message.Answers[i])
+	}
+	compareAnswer(t, is.Authority[0], message.Authority[0])
+	compareAnswer(t, is.Additional[0], message.Additional[0])
 
+	// a count that doesn't fit the header is an error, not a wrapped number
+	message.Answers = make([]parser.Answer, 0x10000)
+	if _, err := message.Pack(); !errors.Is(err, parser.ErrTooManyRecords) {
+		t.Fatalf("error dont match is %v want %v", err, parser.ErrTooManyRecords)
 	}
 }
 
@@ -231,6 +320,108 @@ func compareHeaders(t *testing.T, is parser.Header, expect parser.Header) {
 	if is.ResponseCode != expect.ResponseCode {
 		t.Fatalf("response code dont match is %d wanted %d", is.ResponseCode, expect.ResponseCode)
 	}
+	if is.AuthoritativeAnswer != expect.AuthoritativeAnswer {
+		t.Fatalf("aa dont match is %t wanted %t", is.AuthoritativeAnswer, expect.AuthoritativeAnswer)
+	}
+	if is.TrunCation != expect.TrunCation {
+		t.Fatalf("tc dont match is %t wanted %t", is.TrunCation, expect.TrunCation)
+	}
+	if is.RecursionDesired != expect.RecursionDesired {
+		t.Fatalf("rd dont match is %t wanted %t", is.RecursionDesired, expect.RecursionDesired)
+	}
+	if is.RecursionAvailable != expect.RecursionAvailable {
+		t.Fatalf("ra dont match is %t wanted %t", is.RecursionAvailable, expect.RecursionAvailable)
+	}
+	if is.Zero != expect.Zero {
+		t.Fatalf("z dont match is %t wanted %t", is.Zero, expect.Zero)
+	}
+	if is.AuthenticData != expect.AuthenticData {
+		t.Fatalf("ad dont match is %t wanted %t", is.AuthenticData, expect.AuthenticData)
+	}
+	if is.CheckingDisabled != expect.CheckingDisabled {
+		t.Fatalf("cd dont match is %t wanted %t", is.CheckingDisabled, expect.CheckingDisabled)
+	}
+}
+
+func TestHeaderFlags(t *testing.T) {
+	tests := []struct {
+		flags  []byte
+		header parser.Header
+	}{
+		{
+			flags:  []byte{0b00000100, 0b00000000},
+			header: parser.Header{IsQuery: true, AuthoritativeAnswer: true},
+		},
+		{
+			flags:  []byte{0b00000010, 0b00000000},
+			header: parser.Header{IsQuery: true, TrunCation: true},
+		},
+		{
+			flags:  []byte{0b00000001, 0b00000000},
+			header: parser.Header{IsQuery: true, RecursionDesired: true},
+		},
+		{
+			flags:  []byte{0b00000000, 0b10000000},
+			header: parser.Header{IsQuery: true, RecursionAvailable: true},
+		},
+		{
+			flags:  []byte{0b00000000, 0b01000000},
+			header: parser.Header{IsQuery: true, Zero: true},
+		},
+		{
+			flags:  []byte{0b00000000, 0b00100000},
+			header: parser.Header{IsQuery: true, AuthenticData: true},
+		},
+		{
+			flags:  []byte{0b00000000, 0b00010000},
+			header: parser.Header{IsQuery: true, CheckingDisabled: true},
+		},
+		{
+			flags:  []byte{0b00100000, 0b00000000},
+			header: parser.Header{IsQuery: true, OPCODE: parser.NOTIFY},
+		},
+		{
+			flags:  []byte{0b10011000, 0b00000000},
+			header: parser.Header{OPCODE: 3},
+		},
+		{
+			flags: []byte{0b10101111, 0b10110101},
+			header: parser.Header{
+				OPCODE:              parser.UPDATE,
+				AuthoritativeAnswer: true,
+				TrunCation:          true,
+				RecursionDesired:    true,
+				RecursionAvailable:  true,
+				AuthenticData:       true,
+				CheckingDisabled:    true,
+				ResponseCode:        parser.REFUSED,
+			},
+		},
+	}
+	for _, test := range tests {
+		input := append([]byte{0, 0}, test.flags...)
+		input = append(input, 0, 0, 0, 0, 0, 0, 0, 0)
+		is, err := parser.ParseHeader(parser.NewLookBackBuffer(input))
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		compareHeaders(t, is, test.header)
+
+		buf, err := test.header.ToBinary()
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		CompareBytes(t, buf, input)
+	}
+
+	// the opcode has four bits, larger ones aren't cut off
+	header := parser.Header{IsQuery: true, OPCODE: 16}
+	if _, err := header.ToBinary(); !errors.Is(err, parser.ErrBadOpcode) {
+		t.Fatalf("error dont match is %v want %v", err, parser.ErrBadOpcode)
+	}
+	if _, err := (parser.Message{Header: header}).Pack(); !errors.Is(err, parser.ErrBadOpcode) {
+		t.Fatalf("error dont match is %v want %v", err, parser.ErrBadOpcode)
+	}
 }
 
 func TestHeaderToBinary(t *testing.T) {
@@ -391,12 +582,12 @@ func compareQuestion(t *testing.T, is parser.Question, expect parser.Question) {
 	if is.Type != expect.Type {
 		t.Fatalf("type dont match")
 	}
-	if len(is.Labels) != len(expect.Labels) {
+	if len(is.Name) != len(expect.Name) {
 		t.Fatalf("label length dont match")
 	}
-	for i := 0; i < len(is.Labels); i++ {
-		if is.Labels[i] != expect.Labels[i] {
-			t.Fatalf("label not match is: %s should: %s", is.Labels[i], expect.Labels[i])
+	for i := 0; i < len(is.Name); i++ {
+		if is.Name[i] != expect.Name[i] {
+			t.Fatalf("label not match is: %s should: %s", is.Name[i], expect.Name[i])
 		}
 	}
 }
@@ -408,7 +599,7 @@ func TestQuestionToBinary(t *testing.T) {
 	}{
 		{
 			input: parser.Question{
-				Labels: []string{
+				Name: parser.Name{
 					"blog",
 					"example",
 					"com",
@@ -440,12 +631,12 @@ func TestQuestionToBinary(t *testing.T) {
 
 func compareAnswer(t *testing.T, is parser.Answer, expect parser.Answer) {
 	t.Helper()
-	if len(is.Labels) != len(expect.Labels) {
+	if len(is.Name) != len(expect.Name) {
 		t.Fatalf("labels length dont match up")
 	}
-	for i := 0; i < len(is.Labels); i++ {
-		if is.Labels[i] != expect.Labels[i] {
-			t.Fatalf("label dont match up is: %s want: %s", is.Labels[i], expect.Labels[i])
+	for i := 0; i < len(is.Name); i++ {
+		if is.Name[i] != expect.Name[i] {
+			t.Fatalf("label dont match up is: %s want: %s", is.Name[i], expect.Name[i])
 		}
 
 	}
@@ -459,15 +650,8 @@ func compareAnswer(t *testing.T, is parser.Answer, expect parser.Answer) {
 		t.Fatalf("ttl dont match up is: %d want: %d", is.TTL, expect.TTL)
 	}
 
-	if len(is.Data) != len(expect.Data) {
-		t.Fatalf("data length dont match up is: %d want: %d", len(is.Data), len(expect.Data))
-	}
-	for i := 0; i < len(is.Data); i++ {
-		if is.Data[i] != expect.Data[i] {
-			t.Fatalf("data dont match is: %d want: %d i: %d", is.Data[i], expect.Data[i], i)
-
-		}
-
+	if !reflect.DeepEqual(is.Data, expect.Data) {
+		t.Fatalf("data dont match up is: %v want: %v", is.Data, expect.Data)
 	}
 }
 
@@ -478,14 +662,14 @@ func TestAnswerToBinary(t *testing.T) {
 	}{
 		{
 			input: parser.Answer{
-				Labels: []string{
+				Name: parser.Name{
 					"example",
 					"com",
 				},
 				Type:  parser.A,
 				TTL:   1024,
 				Class: parser.IN,
-				Data:  []byte{1, 1, 1, 1},
+				Data:  parser.ARecord{Addr: netip.MustParseAddr("1.1.1.1")},
 			},
 			expect: []byte{
 				7, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
@@ -511,3 +695,2610 @@ func TestAnswerToBinary(t *testing.T) {
 	}
 
 }
+
+func TestParseErrors(t *testing.T) {
+	header := []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	answerHeader := []byte{0, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	tests := []struct {
+		name   string
+		input  []byte
+		expect error
+	}{
+		{
+			name:   "empty",
+			input:  []byte{},
+			expect: parser.ErrTruncatedMessage,
+		},
+		{
+			name:   "short header",
+			input:  []byte{0, 1, 0, 0, 0},
+			expect: parser.ErrTruncatedMessage,
+		},
+		{
+			name:   "missing question",
+			input:  header,
+			expect: parser.ErrTruncatedMessage,
+		},
+		{
+			name:   "label longer than message",
+			input:  append(header, 7, 'e', 'x'),
+			expect: parser.ErrTruncatedMessage,
+		},
+		{
+			name:   "question without type",
+			input:  append(header, 2, 'e', 'u', 0, 0),
+			expect: parser.ErrTruncatedMessage,
+		},
+		{
+			name:   "reserved label type",
+			input:  append(header, 0b01000000, 0, 1, 0, 1),
+			expect: parser.ErrBadLabelLength,
+		},
+		{
+			name:   "pointer outside message",
+			input:  append(header, 0b11000000, 0xff, 0, 1, 0, 1),
+			expect: parser.ErrBadPointer,
+		},
+		{
+			name:   "pointer cut off",
+			input:  append(header, 0b11000000),
+			expect: parser.ErrTruncatedMessage,
+		},
+		{
+			name: "rdata longer than message",
+			input: append(answerHeader,
+				0, 0, 1, 0, 1,
+				0, 0, 0, 60,
+				0, 4, 1, 1,
+			),
+			expect: parser.ErrTruncatedMessage,
+		},
+		{
+			name: "ns name longer than rdata",
+			input: append(answerHeader,
+				0, 0, 2, 0, 1,
+				0, 0, 0, 60,
+				0, 1, 1, 'a', 0,
+			),
+			expect: parser.ErrBadRDataLength,
+		},
+	}
+	for _, test := range tests {
+		_, err := parser.Parse(test.input)
+		if !errors.Is(err, test.expect) {
+			t.Fatalf("%s: error dont match is %v wanted %v", test.name, err, test.expect)
+		}
+		var parseError *parser.ParseError
+		if !errors.As(err, &parseError) {
+			t.Fatalf("%s: error is not a parse error: %v", test.name, err)
+		}
+	}
+}
+
+func TestHandleMalformed(t *testing.T) {
+	if response := newServer(fixedAnswer).handle([]byte{0, 1, 0}, clientAddr); response != nil {
+		t.Fatalf("response for garbage should be dropped")
+	}
+	response := newServer(fixedAnswer).handle([]byte{0, 9, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 5, 'a'}, clientAddr)
+	message, err := parser.Parse(response)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareHeaders(t, message.Header, parser.Header{
+		ID:           9,
+		ResponseCode: parser.FORMAT_ERROR,
+	})
+
+	// responses are never answered, not even with FORMERR
+	if response := newServer(fixedAnswer).handle([]byte{0, 9, 0x80, 0, 0, 1, 0, 0, 0, 0, 0, 0, 5, 'a'}, clientAddr); response != nil {
+		t.Fatalf("malformed response should be dropped")
+	}
+	reply := parser.Message{
+		Header: parser.Header{ID: 10},
+		Questions: []parser.Question{
+			{Name: parser.Name{"example", "com"}, Type: parser.A, Class: parser.IN},
+		},
+	}
+	buf, err := reply.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if response := newServer(fixedAnswer).handle(buf, clientAddr); response != nil {
+		t.Fatalf("response should be dropped")
+	}
+}
+
+// regression inputs found while fuzzing ReadName
+func TestReadNameLimits(t *testing.T) {
+	header := []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	longName := []byte{}
+	for i := 0; i < 5; i++ {
+		longName = append(longName, 63)
+		for j := 0; j < 63; j++ {
+			longName = append(longName, 'a')
+		}
+	}
+	longName = append(longName, 0, 0, 1, 0, 1)
+
+	// every link is a label followed by a pointer to the previous link
+	labelChain := []byte{1, 'a', 0}
+	previous := 0
+	for i := 0; i < 130; i++ {
+		next := len(labelChain)
+		labelChain = append(labelChain, 2, 'a', 'b', 0b11000000|byte(previous>>8), byte(previous))
+		previous = next
+	}
+	labelChain = append(labelChain, 0b11000000|byte(previous>>8), byte(previous))
+
+	// every link is only a pointer to the previous one
+	pointerChain := []byte{0}
+	for i := 0; i < 130; i++ {
+		previous := len(pointerChain) - 2
+		if i == 0 {
+			previous = 0
+		}
+		pointerChain = append(pointerChain, 0b11000000|byte(previous>>8), byte(previous))
+	}
+	previous = len(pointerChain) - 2
+	pointerChain = append(pointerChain, 0b11000000|byte(previous>>8), byte(previous))
+
+	for _, test := range []struct {
+		name   string
+		input  []byte
+		expect error
+	}{
+		{
+			name:   "pointer chain with labels",
+			input:  labelChain,
+			expect: parser.ErrNameTooLong,
+		},
+		{
+			name:   "pointer chain",
+			input:  pointerChain,
+			expect: parser.ErrTooManyPointers,
+		},
+	} {
+		buffer := parser.NewLookBackBuffer(test.input)
+		buffer.Read(make([]byte, len(test.input)-2))
+		_, err := buffer.ReadName()
+		if !errors.Is(err, test.expect) {
+			t.Fatalf("%s: error dont match is %v wanted %v", test.name, err, test.expect)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		input  []byte
+		expect error
+	}{
+		{
+			name:   "pointer to itself",
+			input:  append(header, 0b11000000, 12, 0, 1, 0, 1),
+			expect: parser.ErrPointerLoop,
+		},
+		{
+			name:   "forward pointer",
+			input:  append(header, 0b11000000, 14, 1, 'a', 0, 0, 1, 0, 1),
+			expect: parser.ErrPointerLoop,
+		},
+		{
+			name:   "pointer back into the same name",
+			input:  append(header, 1, 'a', 0b11000000, 12, 0, 1, 0, 1),
+			expect: parser.ErrPointerLoop,
+		},
+		{
+			name:   "name longer than 255 octets",
+			input:  append(header, longName...),
+			expect: parser.ErrNameTooLong,
+		},
+	}
+	for _, test := range tests {
+		_, err := parser.Parse(test.input)
+		if !errors.Is(err, test.expect) {
+			t.Fatalf("%s: error dont match is %v wanted %v", test.name, err, test.expect)
+		}
+	}
+}
+
+func TestToBinaryLimits(t *testing.T) {
+	long := ""
+	for i := 0; i < 64; i++ {
+		long += "a"
+	}
+	_, err := parser.Question{Name: parser.Name{long}}.ToBinary()
+	if !errors.Is(err, parser.ErrLabelTooLong) {
+		t.Fatalf("error dont match is %v wanted %v", err, parser.ErrLabelTooLong)
+	}
+	labels := []string{}
+	for i := 0; i < 128; i++ {
+		labels = append(labels, "a")
+	}
+	_, err = parser.Answer{Name: labels}.ToBinary()
+	if !errors.Is(err, parser.ErrNameTooLong) {
+		t.Fatalf("error dont match is %v wanted %v", err, parser.ErrNameTooLong)
+	}
+}
+
+func TestPackCompression(t *testing.T) {
+	message, err := parser.Parse(euReferral)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	is, err := message.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	// the referral was compressed the same way, followed by padding
+	CompareBytes(t, is, euReferral[:len(is)])
+	for _, b := range euReferral[len(is):] {
+		if b != 0 {
+			t.Fatalf("packed message is shorter than the original")
+		}
+	}
+}
+
+func TestPackCompressionRData(t *testing.T) {
+	message := parser.Message{
+		Header: parser.Header{ID: 1},
+		Questions: []parser.Question{
+			{Name: parser.Name{"www", "example", "com"}, Type: parser.CNAME, Class: parser.IN},
+		},
+		Answers: []parser.Answer{
+			{
+				Name:  parser.Name{"www", "example", "com"},
+				Type:  parser.CNAME,
+				Class: parser.IN,
+				TTL:   60,
+				Data:  parser.CNAMERecord{Target: parser.Name{"web", "example", "com"}},
+			},
+			{
+				Name:  parser.Name{"example", "com"},
+				Type:  parser.MX,
+				Class: parser.IN,
+				TTL:   60,
+				Data:  parser.MXRecord{Preference: 10, Exchange: parser.Name{"mail", "example", "com"}},
+			},
+		},
+	}
+	is, err := message.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	CompareBytes(t, is, []byte{
+		0, 1, 0b10000000, 0, 0, 1, 0, 2, 0, 0, 0, 0,
+
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0, 5, 0, 1,
+
+		0b11000000, 12,
+		0, 5, 0, 1, 0, 0, 0, 60, 0, 6,
+		3, 'w', 'e', 'b', 0b11000000, 16,
+
+		0b11000000, 16,
+		0, 15, 0, 1, 0, 0, 0, 60, 0, 9,
+		0, 10, 4, 'm', 'a', 'i', 'l', 0b11000000, 16,
+	})
+
+	parsed, err := parser.Parse(is)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	for i := range message.Answers {
+		compareAnswer(t, parsed.Answers[i], message.Answers[i])
+	}
+}
+
+func TestRDataRoundTrip(t *testing.T) {
+	owner := []string{"example", "com"}
+	tests := []parser.RData{
+		parser.ARecord{Addr: netip.MustParseAddr("192.0.2.1")},
+		parser.AAAARecord{Addr: netip.MustParseAddr("2001:db8::1")},
+		parser.NSRecord{Host: parser.Name{"ns1", "example", "com"}},
+		parser.CNAMERecord{Target: parser.Name{"www", "example", "com"}},
+		parser.PTRRecord{Target: parser.Name{"host", "example", "com"}},
+		parser.MXRecord{Preference: 10, Exchange: parser.Name{"mail", "example", "com"}},
+		parser.TXTRecord{Texts: []string{"v=spf1 -all", "second string"}},
+		parser.SOARecord{
+			MName:   parser.Name{"ns1", "example", "com"},
+			RName:   parser.Name{"hostmaster", "example", "com"},
+			Serial:  2024010101,
+			Refresh: 7200,
+			Retry:   3600,
+			Expire:  1209600,
+			Minimum: 300,
+		},
+		parser.SRVRecord{Priority: 1, Weight: 5, Port: 5060, Target: parser.Name{"sip", "example", "com"}},
+		parser.UnknownRecord{RRType: 99, Data: []byte{1, 2, 3}},
+	}
+	for _, data := range tests {
+		message := parser.Message{
+			Answers: []parser.Answer{
+				{Name: owner, Type: data.Type(), Class: parser.IN, TTL: 60, Data: data},
+			},
+		}
+		buf, err := message.Pack()
+		if err != nil {
+			t.Fatalf("%T: should not error: %s", data, err)
+		}
+		parsed, err := parser.Parse(buf)
+		if err != nil {
+			t.Fatalf("%T: should not error: %s", data, err)
+		}
+		compareAnswer(t, parsed.Answers[0], message.Answers[0])
+	}
+}
+
+func TestSRVTargetUncompressed(t *testing.T) {
+	answer := parser.Answer{
+		Name:  parser.Name{"_sip", "_udp", "example", "com"},
+		Type:  parser.SRV,
+		Class: parser.IN,
+		Data:  parser.SRVRecord{Port: 5060, Target: parser.Name{"example", "com"}},
+	}
+	buf, err := parser.Message{Answers: []parser.Answer{answer}}.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	CompareBytes(t, buf[len(buf)-13:], []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0})
+}
+
+func TestUnknownTypes(t *testing.T) {
+	if is := parser.QType(12345).String(); is != "TYPE12345" {
+		t.Fatalf("type dont match is %s want TYPE12345", is)
+	}
+	if is := parser.MX.String(); is != "MX" {
+		t.Fatalf("type dont match is %s want MX", is)
+	}
+	if is := parser.QClass(300).String(); is != "CLASS300" {
+		t.Fatalf("class dont match is %s want CLASS300", is)
+	}
+	for text, expect := range map[string]parser.QType{"TYPE12345": 12345, "type1": parser.A, "aaaa": parser.AAAA} {
+		is, err := parser.ParseQType(text)
+		if err != nil || is != expect {
+			t.Fatalf("%s: type dont match is %d want %d (%v)", text, is, expect, err)
+		}
+	}
+	if is, err := parser.ParseQClass("CLASS300"); err != nil || is != 300 {
+		t.Fatalf("class dont match is %d want 300 (%v)", is, err)
+	}
+	for _, text := range []string{"TYPE", "TYPE65536", "TYPEx", "BOGUS"} {
+		if _, err := parser.ParseQType(text); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("%s: should be a syntax error, is %v", text, err)
+		}
+	}
+
+	record := parser.UnknownRecord{RRType: 12345, Data: []byte{10, 0, 0, 1}}
+	if is := record.String(); is != `\# 4 0a000001` {
+		t.Fatalf("rdata dont match is %s", is)
+	}
+	is, err := parser.ParseGenericRData(12345, []string{`\#`, "4", "0a00", "0001"})
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if !reflect.DeepEqual(is, record) {
+		t.Fatalf("rdata dont match is %v want %v", is, record)
+	}
+	// known types in generic form are decoded into their own type
+	is, err = parser.ParseGenericRData(parser.A, []string{`\#`, "4", "0a000001"})
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if !reflect.DeepEqual(is, parser.ARecord{Addr: netip.MustParseAddr("10.0.0.1")}) {
+		t.Fatalf("rdata dont match is %v", is)
+	}
+	for _, fields := range [][]string{{`\#`, "3", "0a000001"}, {"4", "0a000001"}, {`\#`, "4", "0a00001"}} {
+		if _, err := parser.ParseGenericRData(12345, fields); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("%v: should be a syntax error, is %v", fields, err)
+		}
+	}
+
+	answer := parser.Answer{
+		Name:  parser.Name{"example", "com"},
+		Type:  12345,
+		Class: 300,
+		TTL:   60,
+		Data:  record,
+	}
+	buf, err := answer.ToBinary()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	parsed, err := parser.ParseAnswer(parser.NewLookBackBuffer(buf))
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareAnswer(t, parsed, answer)
+}
+
+func TestHandleFlags(t *testing.T) {
+	request, err := parser.Message{
+		Header: parser.Header{ID: 3, IsQuery: true, RecursionDesired: true},
+		Questions: []parser.Question{
+			{Name: parser.Name{"example", "com"}, Type: parser.A, Class: parser.IN},
+		},
+	}.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	response, err := parser.Parse(newServer(fixedAnswer).handle(request, clientAddr))
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareHeaders(t, response.Header, parser.Header{
+		ID:                  3,
+		AuthoritativeAnswer: true,
+		RecursionDesired:    true,
+		QuestionCount:       1,
+		AnswerCount:         1,
+	})
+}
+
+func TestEDNS(t *testing.T) {
+	message := parser.Message{
+		Header: parser.Header{ID: 1, ResponseCode: parser.BADVERS},
+	}
+	if _, err := message.Pack(); !errors.Is(err, parser.ErrExtendedRCODE) {
+		t.Fatalf("error dont match is %v wanted %v", err, parser.ErrExtendedRCODE)
+	}
+	edns := parser.EDNS{
+		UDPSize:  1232,
+		DNSSECOK: true,
+		Options: []parser.EDNSOption{
+			{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+	}
+	message.SetEDNS(edns)
+	buf, err := message.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	CompareBytes(t, buf, []byte{
+		0, 1, 0b10000000, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0,
+		0, 41,
+		0b00000100, 0b11010000,
+		1, 0, 0b10000000, 0,
+		0, 12,
+		0, 10, 0, 8, 1, 2, 3, 4, 5, 6, 7, 8,
+	})
+
+	parsed, err := parser.Parse(buf)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if parsed.Header.ResponseCode != parser.BADVERS {
+		t.Fatalf("response code dont match is %d wanted %d", parsed.Header.ResponseCode, parser.BADVERS)
+	}
+	is, ok := parsed.EDNS()
+	if !ok {
+		t.Fatalf("edns is missing")
+	}
+	if !reflect.DeepEqual(is, edns) {
+		t.Fatalf("edns dont match is %+v wanted %+v", is, edns)
+	}
+	if _, ok := is.Option(10); !ok {
+		t.Fatalf("option is missing")
+	}
+
+	message.Additional = append(message.Additional, message.Additional[0])
+	buf, _ = message.Pack()
+	if _, err := parser.Parse(buf); !errors.Is(err, parser.ErrMultipleOPT) {
+		t.Fatalf("error dont match is %v wanted %v", err, parser.ErrMultipleOPT)
+	}
+}
+
+func query(t *testing.T, edns *parser.EDNS) parser.Message {
+	t.Helper()
+	return queryServer(t, newServer(fixedAnswer), edns)
+}
+
+func queryServer(t *testing.T, server *server, edns *parser.EDNS) parser.Message {
+	t.Helper()
+	request := parser.Message{
+		Header: parser.Header{ID: 3, IsQuery: true, RecursionDesired: true},
+		Questions: []parser.Question{
+			{Name: parser.Name{"example", "com"}, Type: parser.A, Class: parser.IN},
+		},
+	}
+	if edns != nil {
+		request.SetEDNS(*edns)
+	}
+	buf, err := request.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	response, err := parser.Parse(server.handle(buf, clientAddr))
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	return response
+}
+
+func TestHandleEDNS(t *testing.T) {
+	response := query(t, nil)
+	if _, ok := response.EDNS(); ok {
+		t.Fatalf("response should not have edns without request edns")
+	}
+
+	response = query(t, &parser.EDNS{UDPSize: 4096, DNSSECOK: true})
+	edns, ok := response.EDNS()
+	if !ok {
+		t.Fatalf("response should have edns")
+	}
+	if edns.UDPSize != maxUDPSize || !edns.DNSSECOK || edns.Version != 0 {
+		t.Fatalf("edns dont match is %+v", edns)
+	}
+	if len(response.Answers) != 1 {
+		t.Fatalf("answer count dont match is %d", len(response.Answers))
+	}
+
+	response = query(t, &parser.EDNS{UDPSize: 4096, Version: 1})
+	if response.Header.ResponseCode != parser.BADVERS {
+		t.Fatalf("response code dont match is %d wanted %d", response.Header.ResponseCode, parser.BADVERS)
+	}
+	if len(response.Answers) != 0 {
+		t.Fatalf("answer count dont match is %d", len(response.Answers))
+	}
+	if edns, _ := response.EDNS(); edns.Version != 0 {
+		t.Fatalf("version dont match is %d", edns.Version)
+	}
+}
+
+func TestPackTruncates(t *testing.T) {
+	response := parser.Message{
+		Header: parser.Header{ID: 1},
+		Questions: []parser.Question{
+			{Name: parser.Name{"example", "com"}, Type: parser.TXT, Class: parser.IN},
+		},
+	}
+	text := string(make([]byte, 255))
+	for i := 0; i < 3; i++ {
+		response.Answers = append(response.Answers, parser.Answer{
+			Name:  parser.Name{"example", "com"},
+			Type:  parser.TXT,
+			Class: parser.IN,
+			Data:  parser.TXTRecord{Texts: []string{text}},
+		})
+	}
+	response.SetEDNS(parser.EDNS{UDPSize: maxUDPSize})
+
+	message, err := parser.Parse(pack(response, minUDPSize))
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if !message.Header.TrunCation || len(message.Answers) != 0 || len(message.Questions) != 1 {
+		t.Fatalf("response should be truncated: %s", message)
+	}
+	if _, ok := message.EDNS(); !ok {
+		t.Fatalf("truncated response should keep edns")
+	}
+
+	message, err = parser.Parse(pack(response, maxUDPSize))
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if message.Header.TrunCation || len(message.Answers) != 3 {
+		t.Fatalf("response should not be truncated: %s", message)
+	}
+}
+
+func TestClientSubnet(t *testing.T) {
+	tests := []struct {
+		data   []byte
+		subnet parser.ClientSubnet
+	}{
+		{
+			data:   []byte{0, 1, 24, 0, 192, 0, 2},
+			subnet: parser.ClientSubnet{Prefix: netip.MustParsePrefix("192.0.2.0/24")},
+		},
+		{
+			data:   []byte{0, 1, 20, 16, 198, 51, 96},
+			subnet: parser.ClientSubnet{Prefix: netip.MustParsePrefix("198.51.96.0/20"), Scope: 16},
+		},
+		{
+			data:   []byte{0, 2, 56, 0, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0x12},
+			subnet: parser.ClientSubnet{Prefix: netip.MustParsePrefix("2001:db8:0:1200::/56")},
+		},
+		{
+			data:   []byte{0, 1, 0, 0},
+			subnet: parser.ClientSubnet{Prefix: netip.MustParsePrefix("0.0.0.0/0")},
+		},
+	}
+	for _, test := range tests {
+		option := parser.EDNSOption{Code: parser.CLIENT_SUBNET, Data: test.data}
+		is, err := parser.ParseClientSubnet(option)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		if is != test.subnet {
+			t.Fatalf("subnet dont match is %+v want %+v", is, test.subnet)
+		}
+		encoded, err := test.subnet.Option()
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		CompareBytes(t, encoded.Data, test.data)
+	}
+
+	for _, data := range [][]byte{
+		{0, 1, 24},
+		{0, 3, 0, 0},
+		// address longer than the prefix
+		{0, 1, 16, 0, 192, 0, 2},
+		// bits set beyond the prefix
+		{0, 1, 23, 0, 192, 0, 3},
+		{0, 1, 33, 0, 192, 0, 2, 1, 1},
+	} {
+		_, err := parser.ParseClientSubnet(parser.EDNSOption{Code: parser.CLIENT_SUBNET, Data: data})
+		if !errors.Is(err, parser.ErrBadClientSubnet) {
+			t.Fatalf("%v: error dont match is %v", data, err)
+		}
+	}
+}
+
+func TestHandleClientSubnet(t *testing.T) {
+	var seen *parser.ClientSubnet
+	server := newServer(func(query Query) Result {
+		seen = query.ClientSubnet
+		result := fixedAnswer(query)
+		result.Scope = 16
+		return result
+	})
+	option, _ := parser.ClientSubnet{Prefix: netip.MustParsePrefix("192.0.2.0/24")}.Option()
+	response := queryServer(t, server, &parser.EDNS{UDPSize: 1232, Options: []parser.EDNSOption{option}})
+	if seen == nil || seen.Prefix != netip.MustParsePrefix("192.0.2.0/24") {
+		t.Fatalf("answerer should see the client subnet, is %v", seen)
+	}
+	edns, _ := response.EDNS()
+	echo, ok := edns.Option(parser.CLIENT_SUBNET)
+	if !ok {
+		t.Fatalf("client subnet should be echoed")
+	}
+	CompareBytes(t, echo.Data, []byte{0, 1, 24, 16, 192, 0, 2})
+
+	// a source prefix of 0 always gets scope 0
+	option, _ = parser.ClientSubnet{Prefix: netip.MustParsePrefix("0.0.0.0/0")}.Option()
+	response = queryServer(t, server, &parser.EDNS{UDPSize: 1232, Options: []parser.EDNSOption{option}})
+	edns, _ = response.EDNS()
+	echo, _ = edns.Option(parser.CLIENT_SUBNET)
+	CompareBytes(t, echo.Data, []byte{0, 1, 0, 0})
+
+	response = queryServer(t, server, &parser.EDNS{UDPSize: 1232})
+	if seen != nil {
+		t.Fatalf("answerer should not see a client subnet, is %v", seen)
+	}
+	edns, _ = response.EDNS()
+	if _, ok := edns.Option(parser.CLIENT_SUBNET); ok {
+		t.Fatalf("client subnet should only be echoed when sent")
+	}
+
+	bad := parser.EDNSOption{Code: parser.CLIENT_SUBNET, Data: []byte{0, 1, 23, 0, 192, 0, 3}}
+	response = queryServer(t, server, &parser.EDNS{UDPSize: 1232, Options: []parser.EDNSOption{bad}})
+	if response.Header.ResponseCode != parser.FORMAT_ERROR {
+		t.Fatalf("response code dont match is %d wanted %d", response.Header.ResponseCode, parser.FORMAT_ERROR)
+	}
+}
+
+func TestCookieOption(t *testing.T) {
+	client := [8]byte{0x24, 0x64, 0xc4, 0xab, 0xcf, 0x10, 0xc9, 0x57}
+	for _, cookie := range []parser.Cookie{
+		{Client: client},
+		{Client: client, Server: []byte{1, 0, 0, 0, 0x5c, 0xf7, 0x9f, 0x11, 0x1f, 0x81, 0x30, 0xc3, 0xee, 0xe2, 0x94, 0x80}},
+	} {
+		option, err := cookie.Option()
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		is, err := parser.ParseCookie(option)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		if !reflect.DeepEqual(is, cookie) {
+			t.Fatalf("cookie dont match is %+v want %+v", is, cookie)
+		}
+	}
+	for _, data := range [][]byte{{1, 2, 3}, make([]byte, 12), make([]byte, 41)} {
+		_, err := parser.ParseCookie(parser.EDNSOption{Code: parser.COOKIE, Data: data})
+		if !errors.Is(err, parser.ErrBadCookie) {
+			t.Fatalf("%d octets: error dont match is %v", len(data), err)
+		}
+	}
+}
+
+// the test vectors of RFC 9018 appendix A
+func TestServerCookie(t *testing.T) {
+	secret := [16]byte{0xe5, 0xe9, 0x73, 0xe5, 0xa6, 0xb2, 0xa4, 0x3f, 0x48, 0xe7, 0xdc, 0x84, 0x9e, 0x37, 0xbf, 0xcf}
+	client := [8]byte{0x24, 0x64, 0xc4, 0xab, 0xcf, 0x10, 0xc9, 0x57}
+	issued := time.Unix(1559731985, 0)
+
+	server := cookie.NewServer(0)
+	server.SetSecret(secret, issued)
+	is := server.Generate(client, clientAddr, issued)
+	CompareBytes(t, is, []byte{1, 0, 0, 0, 0x5c, 0xf7, 0x9f, 0x11, 0x1f, 0x81, 0x30, 0xc3, 0xee, 0xe2, 0x94, 0x80})
+
+	request := parser.Cookie{Client: client, Server: is}
+	tests := []struct {
+		name    string
+		addr    netip.Addr
+		now     time.Time
+		valid   bool
+		refresh bool
+	}{
+		{"fresh", clientAddr, issued.Add(time.Minute), true, false},
+		{"old", clientAddr, issued.Add(40 * time.Minute), true, true},
+		{"expired", clientAddr, issued.Add(2 * time.Hour), false, false},
+		{"from the future", clientAddr, issued.Add(-10 * time.Minute), false, false},
+		{"other client", netip.MustParseAddr("198.51.100.101"), issued, false, false},
+	}
+	for _, test := range tests {
+		valid, refresh := server.Verify(request, test.addr, test.now)
+		if valid != test.valid || refresh != test.refresh {
+			t.Fatalf("%s: verify dont match is %t %t want %t %t", test.name, valid, refresh, test.valid, test.refresh)
+		}
+	}
+
+	// cookies of the previous secret stay valid, but get replaced
+	server.Rotate(issued)
+	if valid, refresh := server.Verify(request, clientAddr, issued); !valid || !refresh {
+		t.Fatalf("cookie of the previous secret should be valid and refreshed")
+	}
+	server.Rotate(issued)
+	if valid, _ := server.Verify(request, clientAddr, issued); valid {
+		t.Fatalf("cookie of an older secret should not be valid")
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	jar := cookie.NewJar()
+	first := jar.Cookie("192.0.2.53:53")
+	if len(first.Server) != 0 {
+		t.Fatalf("server cookie should be empty before the first response")
+	}
+	if other := jar.Cookie("192.0.2.54:53"); other.Client == first.Client {
+		t.Fatalf("client cookies should differ per upstream")
+	}
+
+	respond := func(c parser.Cookie) parser.Message {
+		option, _ := c.Option()
+		response := parser.Message{}
+		response.SetEDNS(parser.EDNS{UDPSize: 1232, Options: []parser.EDNSOption{option}})
+		return response
+	}
+	serverCookie := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	spoofed := respond(parser.Cookie{Client: [8]byte{1}, Server: serverCookie})
+	if err := jar.Update("192.0.2.53:53", spoofed); !errors.Is(err, cookie.ErrCookieMismatch) {
+		t.Fatalf("error dont match is %v", err)
+	}
+	if err := jar.Update("192.0.2.53:53", respond(parser.Cookie{Client: first.Client, Server: serverCookie})); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	CompareBytes(t, jar.Cookie("192.0.2.53:53").Server, serverCookie)
+	if err := jar.Update("192.0.2.53:53", parser.Message{}); err != nil {
+		t.Fatalf("response without cookie should be accepted: %s", err)
+	}
+}
+
+func TestHandleCookies(t *testing.T) {
+	server := newServer(fixedAnswer)
+	jar := cookie.NewJar()
+	send := func() parser.Message {
+		option, _ := jar.Cookie("server").Option()
+		response := queryServer(t, server, &parser.EDNS{UDPSize: 1232, Options: []parser.EDNSOption{option}})
+		if err := jar.Update("server", response); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		return response
+	}
+
+	// only a client cookie, answered normally with a new server cookie
+	response := send()
+	if response.Header.ResponseCode != parser.NO_ERROR || len(response.Answers) != 1 {
+		t.Fatalf("response should be answered: %s", response)
+	}
+	learned := jar.Cookie("server").Server
+	if len(learned) != 16 {
+		t.Fatalf("server cookie should be learned, is %x", learned)
+	}
+
+	response = send()
+	if response.Header.ResponseCode != parser.NO_ERROR || len(response.Answers) != 1 {
+		t.Fatalf("response should be answered: %s", response)
+	}
+
+	// a server cookie of another server secret
+	server.cookies.Rotate(time.Now())
+	server.cookies.Rotate(time.Now())
+	response = send()
+	if response.Header.ResponseCode != parser.BADCOOKIE || len(response.Answers) != 0 {
+		t.Fatalf("response should be BADCOOKIE: %s", response)
+	}
+	if string(jar.Cookie("server").Server) == string(learned) {
+		t.Fatalf("BADCOOKIE should carry a new server cookie")
+	}
+	response = send()
+	if response.Header.ResponseCode != parser.NO_ERROR {
+		t.Fatalf("retry with the new cookie should be answered: %s", response)
+	}
+
+	bad := parser.EDNSOption{Code: parser.COOKIE, Data: []byte{1, 2, 3}}
+	response = queryServer(t, server, &parser.EDNS{UDPSize: 1232, Options: []parser.EDNSOption{bad}})
+	if response.Header.ResponseCode != parser.FORMAT_ERROR {
+		t.Fatalf("response code dont match is %d wanted %d", response.Header.ResponseCode, parser.FORMAT_ERROR)
+	}
+}
+
+func TestExtendedError(t *testing.T) {
+	ede := parser.ExtendedError{InfoCode: parser.EDE_BLOCKED, ExtraText: "blocked by policy"}
+	option := ede.Option()
+	CompareBytes(t, option.Data, append([]byte{0, 15}, "blocked by policy"...))
+	is, err := parser.ParseExtendedError(option)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if is != ede {
+		t.Fatalf("extended error dont match is %+v want %+v", is, ede)
+	}
+	if is.String() != "15 (Blocked): blocked by policy" {
+		t.Fatalf("string dont match is %s", is)
+	}
+	if s := parser.ExtendedErrorCode(4000).String(); s != "Info Code 4000" {
+		t.Fatalf("string dont match is %s", s)
+	}
+	for _, data := range [][]byte{{0}, {0, 6, 0xff, 0xfe}} {
+		_, err := parser.ParseExtendedError(parser.EDNSOption{Code: parser.EXTENDED_ERROR, Data: data})
+		if !errors.Is(err, parser.ErrBadExtendedError) {
+			t.Fatalf("%v: error dont match is %v", data, err)
+		}
+	}
+}
+
+func queryMessage(t *testing.T, server *server, request parser.Message) parser.Message {
+	t.Helper()
+	buf, err := request.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	response, err := parser.Parse(server.handle(buf, clientAddr))
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	return response
+}
+
+func TestHandleExtendedErrors(t *testing.T) {
+	request := parser.Message{
+		Header: parser.Header{ID: 3, IsQuery: true},
+		Questions: []parser.Question{
+			{Name: parser.Name{"example", "com"}, Type: parser.A, Class: parser.CH},
+		},
+	}
+	request.SetEDNS(parser.EDNS{UDPSize: 1232})
+
+	response := queryMessage(t, newServer(fixedAnswer), request)
+	if response.Header.ResponseCode != parser.REFUSED {
+		t.Fatalf("response code dont match is %s wanted %s", response.Header.ResponseCode, parser.REFUSED)
+	}
+	errs := response.ExtendedErrors()
+	if len(errs) != 1 || errs[0].InfoCode != parser.EDE_NOT_SUPPORTED {
+		t.Fatalf("extended errors dont match is %v", errs)
+	}
+
+	broken := newServer(func(query Query) Result {
+		return Result{Answers: []parser.Answer{{Name: query.Question.Name, Type: parser.A, Data: parser.ARecord{}}}}
+	})
+	request.Questions[0].Class = parser.IN
+	response = queryMessage(t, broken, request)
+	if response.Header.ResponseCode != parser.SERVER_FAILURE || len(response.Answers) != 0 {
+		t.Fatalf("response should be a server failure: %s", response)
+	}
+	if errs := response.ExtendedErrors(); len(errs) != 1 || errs[0].InfoCode != parser.EDE_OTHER {
+		t.Fatalf("extended errors dont match is %v", errs)
+	}
+
+	request.Header.OPCODE = parser.UPDATE
+	response = queryMessage(t, newServer(fixedAnswer), request)
+	if response.Header.ResponseCode != parser.NOT_IMPLEMENTED {
+		t.Fatalf("response code dont match is %s wanted %s", response.Header.ResponseCode, parser.NOT_IMPLEMENTED)
+	}
+	if errs := response.ExtendedErrors(); len(errs) != 1 || errs[0].InfoCode != parser.EDE_NOT_SUPPORTED {
+		t.Fatalf("extended errors dont match is %v", errs)
+	}
+}
+
+func TestPresentation(t *testing.T) {
+	tests := []struct {
+		text   string
+		answer parser.Answer
+	}{
+		{
+			text:   "www.example.com. 3600 IN A 192.0.2.1",
+			answer: parser.Answer{Name: parser.Name{"www", "example", "com"}, Type: parser.A, Class: parser.IN, TTL: 3600, Data: parser.ARecord{Addr: netip.MustParseAddr("192.0.2.1")}},
+		},
+		{
+			text:   "example.com. 60 IN AAAA 2001:db8::1",
+			answer: parser.Answer{Name: parser.Name{"example", "com"}, Type: parser.AAAA, Class: parser.IN, TTL: 60, Data: parser.AAAARecord{Addr: netip.MustParseAddr("2001:db8::1")}},
+		},
+		{
+			text:   "example.com. 60 IN MX 10 mail.example.com.",
+			answer: parser.Answer{Name: parser.Name{"example", "com"}, Type: parser.MX, Class: parser.IN, TTL: 60, Data: parser.MXRecord{Preference: 10, Exchange: parser.Name{"mail", "example", "com"}}},
+		},
+		{
+			text:   `example.com. 60 IN TXT "v=spf1 -all" "say \"hi\"\\" "\009"`,
+			answer: parser.Answer{Name: parser.Name{"example", "com"}, Type: parser.TXT, Class: parser.IN, TTL: 60, Data: parser.TXTRecord{Texts: []string{"v=spf1 -all", `say "hi"\`, "\t"}}},
+		},
+		{
+			text:   "example.com. 60 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+			answer: parser.Answer{Name: parser.Name{"example", "com"}, Type: parser.SOA, Class: parser.IN, TTL: 60, Data: parser.SOARecord{MName: parser.Name{"ns", "example", "com"}, RName: parser.Name{"hostmaster", "example", "com"}, Serial: 1, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 300}},
+		},
+		{
+			text:   "_sip._udp.example.com. 60 IN SRV 10 20 5060 sip.example.com.",
+			answer: parser.Answer{Name: parser.Name{"_sip", "_udp", "example", "com"}, Type: parser.SRV, Class: parser.IN, TTL: 60, Data: parser.SRVRecord{Priority: 10, Weight: 20, Port: 5060, Target: parser.Name{"sip", "example", "com"}}},
+		},
+		{
+			text:   `a\.b\032c.example. 0 CH CNAME .`,
+			answer: parser.Answer{Name: parser.Name{"a.b c", "example"}, Type: parser.CNAME, Class: parser.CH, TTL: 0, Data: parser.CNAMERecord{Target: parser.Name{}}},
+		},
+		{
+			text:   `example. 0 CLASS300 TYPE12345 \# 4 0a000001`,
+			answer: parser.Answer{Name: parser.Name{"example"}, Type: 12345, Class: 300, Data: parser.UnknownRecord{RRType: 12345, Data: []byte{10, 0, 0, 1}}},
+		},
+	}
+	for _, test := range tests {
+		if is := test.answer.String(); is != test.text {
+			t.Fatalf("presentation dont match is %s want %s", is, test.text)
+		}
+		is, err := parser.ParseRecord(test.text)
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", test.text, err)
+		}
+		compareAnswer(t, is, test.answer)
+	}
+
+	// class and ttl may be left out or swapped, entries may span lines
+	is, err := parser.ParseRecord("example. IN 1h ( SOA ns.example. ; primary\n host.example. 1 2 3 4 5 )")
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if is.String() != "example. 3600 IN SOA ns.example. host.example. 1 2 3 4 5" {
+		t.Fatalf("presentation dont match is %s", is)
+	}
+
+	// names without the trailing dot are relative to the root
+	is, err = parser.ParseRecord("www.example 60 CNAME target.example")
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if is.String() != "www.example. 60 IN CNAME target.example." {
+		t.Fatalf("presentation dont match is %s", is)
+	}
+
+	question := parser.Question{Name: parser.Name{"www", "example", "com"}, Type: parser.ALL, Class: parser.IN}
+	if is := question.String(); is != "www.example.com. IN ANY" {
+		t.Fatalf("presentation dont match is %s", is)
+	}
+
+	for _, text := range []string{
+		"example. IN A 192.0.2.1",
+		"example. 60 IN A 2001:db8::1",
+		"example. 60 IN MX mail.example.",
+		"example. 60 IN A",
+		"example. 60 IN",
+		"example. 60 IN NULL 00",
+		"example..com. 60 IN A 192.0.2.1",
+		"example. 60 IN TXT \"open",
+		"example. 60 IN A 192.0.2.1 )",
+		"example. 60 IN A 192.0.2.1\nexample. 60 IN A 192.0.2.2",
+		"example. 99999999999s IN A 192.0.2.1",
+		"example. 4294967296 IN A 192.0.2.1",
+		"example. 7102w IN A 192.0.2.1",
+	} {
+		if _, err := parser.ParseRecord(text); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("%q: should be a syntax error, is %v", text, err)
+		}
+	}
+}
+
+const exampleZone = `$ORIGIN example.com.
+$TTL 1h
+@	IN	SOA	ns hostmaster (
+		2024010101 ; serial
+		2h 1h 2w 5m )
+	IN	NS	ns
+	IN	MX	10 mail
+ns		A	192.0.2.53
+www	60	A	192.0.2.1
+		AAAA	2001:db8::1
+alias		CNAME	www
+dot\.ted	TXT	"a \"quoted\" text" more
+$INCLUDE sub.zone sub
+		TXT	"after include"
+mail		A	192.0.2.25
+`
+
+const subZone = `; relative to sub.example.com.
+$TTL 5m
+@	A	192.0.2.100
+deep.a.b	CH	A	192.0.2.101
+`
+
+func loadExampleZone(t *testing.T) []parser.Answer {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "example.zone"), []byte(exampleZone), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub.zone"), []byte(subZone), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	records, err := parser.ParseZoneFile(filepath.Join(dir, "example.zone"), nil)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	return records
+}
+
+func TestZoneFile(t *testing.T) {
+	records := loadExampleZone(t)
+	expect := []string{
+		"example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300",
+		"example.com. 3600 IN NS ns.example.com.",
+		"example.com. 3600 IN MX 10 mail.example.com.",
+		"ns.example.com. 3600 IN A 192.0.2.53",
+		"www.example.com. 60 IN A 192.0.2.1",
+		"www.example.com. 3600 IN AAAA 2001:db8::1",
+		"alias.example.com. 3600 IN CNAME www.example.com.",
+		`dot\.ted.example.com. 3600 IN TXT "a \"quoted\" text" "more"`,
+		"sub.example.com. 300 IN A 192.0.2.100",
+		"deep.a.b.sub.example.com. 300 CH A 192.0.2.101",
+		// owner, class and TTL are the ones from before the $INCLUDE
+		`dot\.ted.example.com. 3600 IN TXT "after include"`,
+		"mail.example.com. 3600 IN A 192.0.2.25",
+	}
+	if len(records) != len(expect) {
+		t.Fatalf("record count dont match is %d want %d", len(records), len(expect))
+	}
+	for i, record := range records {
+		if record.String() != expect[i] {
+			t.Fatalf("record dont match is %s want %s", record, expect[i])
+		}
+	}
+
+	// without $TTL every record with a TTL sets it for the following ones
+	records, err := parser.ParseZone("a. 30 A 192.0.2.1\nb. A 192.0.2.2\n", "", nil)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if records[1].TTL != 30 {
+		t.Fatalf("ttl dont match is %d want 30", records[1].TTL)
+	}
+}
+
+func TestZoneErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		line int
+	}{
+		{"a. 1 A 192.0.2.1\n\nb. 1 A 192.0.2.300\n", 3},
+		{"a. A 192.0.2.1\n", 1},
+		{" 1 A 192.0.2.1\n", 1},
+		{"$ORIGIN example.\n$GENERATE 1-2 a$ A 192.0.2.$\n", 2},
+		{"a. 1 TXT ( \"x\"\n\"y\"\n", 3},
+		{"a. 1 TXT \"x\n\ny\n", 1},
+		{"$TTL\n", 1},
+		{"$INCLUDE missing.zone\n", 1},
+	}
+	for _, test := range tests {
+		_, err := parser.ParseZone(test.text, "test.zone", nil)
+		var zoneError *parser.ZoneError
+		if !errors.As(err, &zoneError) {
+			t.Fatalf("%q: should be a zone error, is %v", test.text, err)
+		}
+		if zoneError.Line != test.line || zoneError.File != "test.zone" {
+			t.Fatalf("%q: position dont match is %s:%d want line %d", test.text, zoneError.File, zoneError.Line, test.line)
+		}
+	}
+
+	dir := t.TempDir()
+	loop := filepath.Join(dir, "loop.zone")
+	if err := os.WriteFile(loop, []byte("$INCLUDE loop.zone\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseZoneFile(loop, nil); !errors.Is(err, parser.ErrIncludeDepth) {
+		t.Fatalf("should be an include depth error, is %v", err)
+	}
+}
+
+func TestZoneAnswer(t *testing.T) {
+	zone := newZone(loadExampleZone(t))
+	tests := []struct {
+		name   string
+		qtype  parser.QType
+		rcode  parser.RCODE
+		expect []string
+	}{
+		{"WWW.Example.com.", parser.A, parser.NO_ERROR, []string{"www.example.com. 60 IN A 192.0.2.1"}},
+		{"www.example.com.", parser.MX, parser.NO_ERROR, []string{}},
+		{"alias.example.com.", parser.AAAA, parser.NO_ERROR, []string{"alias.example.com. 3600 IN CNAME www.example.com.", "www.example.com. 3600 IN AAAA 2001:db8::1"}},
+		{"alias.example.com.", parser.CNAME, parser.NO_ERROR, []string{"alias.example.com. 3600 IN CNAME www.example.com."}},
+		{"a.b.sub.example.com.", parser.A, parser.NO_ERROR, []string{}},
+		{"nope.example.com.", parser.A, parser.NAME_ERROR, []string{}},
+		{"example.org.", parser.A, parser.REFUSED, []string{}},
+	}
+	for _, test := range tests {
+		labels, err := parser.ParseName(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := zone.answer(Query{Question: parser.Question{Name: labels, Type: test.qtype, Class: parser.IN}})
+		if result.ResponseCode != test.rcode {
+			t.Fatalf("%s: response code dont match is %s want %s", test.name, result.ResponseCode, test.rcode)
+		}
+		if len(result.Answers) != len(test.expect) {
+			t.Fatalf("%s: answers dont match is %v want %v", test.name, result.Answers, test.expect)
+		}
+		for i, answer := range result.Answers {
+			if answer.String() != test.expect[i] {
+				t.Fatalf("%s: answer dont match is %s want %s", test.name, answer, test.expect[i])
+			}
+		}
+		// negative answers carry the SOA with the negative TTL
+		soa := "example.com. 300 IN SOA ns.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300"
+		if len(test.expect) == 0 && test.rcode != parser.REFUSED {
+			if len(result.Authority) != 1 || result.Authority[0].String() != soa {
+				t.Fatalf("%s: authority dont match is %v want %s", test.name, result.Authority, soa)
+			}
+		} else if len(result.Authority) != 0 {
+			t.Fatalf("%s: authority should be empty, is %v", test.name, result.Authority)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	name, err := parser.ParseName(`www.Ex\.ample\032.com`)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if !reflect.DeepEqual(name, parser.Name{"www", "Ex.ample ", "com"}) {
+		t.Fatalf("name dont match is %#v", name)
+	}
+	if name.String() != `www.Ex\.ample\032.com.` {
+		t.Fatalf("presentation dont match is %s", name)
+	}
+	parent := parser.Name{"EX.AMPLE ", "COM"}
+	if !name.Parent().Equal(parent) || !name.IsSubdomainOf(parent) || !name.IsSubdomainOf(parser.Name{}) {
+		t.Fatalf("%s should be below %s", name, parent)
+	}
+	if parent.IsSubdomainOf(name) || name.IsSubdomainOf(parser.Name{"ample ", "com"}) {
+		t.Fatalf("%s should not be below", parent)
+	}
+	child, err := parent.Child("www")
+	if err != nil || !child.Equal(name) {
+		t.Fatalf("child dont match is %s (%v)", child, err)
+	}
+	if root := (parser.Name{}); root.Parent().String() != "." {
+		t.Fatalf("root should be its own parent")
+	}
+
+	// the example of RFC 4034 section 6.1
+	ordered := []string{
+		"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.",
+		`zABC.a.EXAMPLE.`, "z.example.", `\001.z.example.`, "*.z.example.", `\200.z.example.`,
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := parser.ParseName(ordered[i])
+			b, _ := parser.ParseName(ordered[j])
+			expect := 0
+			if i < j {
+				expect = -1
+			} else if i > j {
+				expect = 1
+			}
+			if is := a.Compare(b); is != expect {
+				t.Fatalf("compare %s %s is %d want %d", a, b, is, expect)
+			}
+		}
+	}
+	if is := (parser.Name{"A", "Example"}).Canonical(); !reflect.DeepEqual(is, parser.Name{"a", "example"}) {
+		t.Fatalf("canonical name dont match is %s", is)
+	}
+
+	long := strings.Repeat("a", 64)
+	if _, err := parser.NewName(long); !errors.Is(err, parser.ErrLabelTooLong) {
+		t.Fatalf("should be a label error, is %v", err)
+	}
+	if _, err := parser.NewName("a", "", "b"); !errors.Is(err, parser.ErrEmptyLabel) {
+		t.Fatalf("should be an empty label error, is %v", err)
+	}
+	if _, err := parser.ParseName(strings.Repeat("abcdefg.", 32)); !errors.Is(err, parser.ErrNameTooLong) {
+		t.Fatalf("should be a name length error, is %v", err)
+	}
+	for _, text := range []string{`abc\`, `www.abc\`, `\`} {
+		if name, err := parser.ParseName(text); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("%s: should be a syntax error, is %s (%v)", text, name, err)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	query := parser.NewQuery(parser.Name{"example", "com"}, parser.AAAA)
+	query.Header.RecursionDesired = true
+	query.SetEDNS(parser.EDNS{UDPSize: 1232})
+	if !query.Header.IsQuery || query.Header.QuestionCount != 1 || query.Header.ARCount != 1 {
+		t.Fatalf("query header dont match is %s", query.Header)
+	}
+	if q := query.Questions[0]; q.Class != parser.IN || q.Type != parser.AAAA {
+		t.Fatalf("question dont match is %s", q)
+	}
+
+	answer := parser.Answer{Name: parser.Name{"example", "com"}, Type: parser.AAAA, Class: parser.IN, TTL: 60, Data: parser.AAAARecord{Addr: netip.MustParseAddr("2001:db8::1")}}
+	reply := query.Reply().AddAnswer(answer, answer).SetRcode(parser.BADCOOKIE).SetEDNS(parser.EDNS{UDPSize: 1232})
+	header := reply.Header
+	if header.IsQuery || header.ID != query.Header.ID || !header.RecursionDesired || header.QuestionCount != 1 || header.AnswerCount != 2 || header.ARCount != 1 {
+		t.Fatalf("reply header dont match is %s", header)
+	}
+	reply.RemoveEDNS()
+	if reply.Header.ARCount != 0 {
+		t.Fatalf("additional count should follow the section, is %d", reply.Header.ARCount)
+	}
+	reply.SetEDNS(parser.EDNS{UDPSize: 1232})
+
+	buf, err := reply.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	is, err := parser.Parse(buf)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareHeaders(t, is.Header, reply.Header)
+	if len(is.Answers) != 2 {
+		t.Fatalf("answers dont match is %v", is.Answers)
+	}
+}
+
+func TestAllocations(t *testing.T) {
+	message, err := parser.Parse(euReferral)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	buf := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := message.AppendPack(buf[:0]); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("packing into a large enough buffer should not allocate, did %.0f times", allocs)
+	}
+
+	// one slice per section, the name and the rdata of each record
+	allocs = testing.AllocsPerRun(100, func() {
+		parser.Parse(euReferral)
+	})
+	records := len(message.Questions) + len(message.Answers) + len(message.Authority) + len(message.Additional)
+	if limit := float64(3 + 4*records); allocs > limit {
+		t.Fatalf("parsing allocated %.0f times, more than %.0f", allocs, limit)
+	}
+}
+
+func TestAppendPackPrefix(t *testing.T) {
+	message, err := parser.Parse(euReferral)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	expect, err := message.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	// e.g. the length prefix of DNS over TCP, pointers must not count it
+	is, err := message.AppendPack([]byte{0, 0})
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	CompareBytes(t, is[2:], expect)
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := parser.Parse(euReferral); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendPack(b *testing.B) {
+	message, err := parser.Parse(euReferral)
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := message.AppendPack(buf[:0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHandle(b *testing.B) {
+	request, err := parser.NewQuery(parser.Name{"example", "com"}, parser.A).SetEDNS(parser.EDNS{UDPSize: 1232}).Pack()
+	if err != nil {
+		b.Fatal(err)
+	}
+	server := newServer(fixedAnswer)
+	b.ReportAllocs()
+	for range b.N {
+		server.handle(request, clientAddr)
+	}
+}
+
+func TestPackRepeatedLabels(t *testing.T) {
+	name := parser.Name{"8", "8", "8", "8", "in-addr", "arpa"}
+	buf, err := parser.NewQuery(name, parser.PTR).Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	// a name can't point into itself before it is complete
+	CompareBytes(t, buf[12:], []byte{1, '8', 1, '8', 1, '8', 1, '8', 7, 'i', 'n', '-', 'a', 'd', 'd', 'r', 4, 'a', 'r', 'p', 'a', 0, 0, 12, 0, 1})
+}
+
+func TestMultipleQuestions(t *testing.T) {
+	request := parser.NewQuery(parser.Name{"example", "com"}, parser.A).
+		AddQuestion(parser.Question{Name: parser.Name{"www", "example", "com"}, Type: parser.AAAA, Class: parser.IN})
+	buf, err := request.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	is, err := parser.Parse(buf)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if is.Header.QuestionCount != 2 || len(is.Questions) != 2 {
+		t.Fatalf("questions dont match is %v", is.Questions)
+	}
+	for i := range is.Questions {
+		compareQuestion(t, is.Questions[i], request.Questions[i])
+	}
+	// the second name is compressed against the first
+	if len(buf) != 12+17+10 {
+		t.Fatalf("length dont match is %d", len(buf))
+	}
+
+	response := queryMessage(t, newServer(fixedAnswer), *request)
+	if response.Header.ResponseCode != parser.FORMAT_ERROR || len(response.Answers) != 0 {
+		t.Fatalf("more than one question should be a format error: %s", response)
+	}
+
+	empty := parser.Message{Header: parser.Header{ID: 7, IsQuery: true}}
+	response = queryMessage(t, newServer(fixedAnswer), empty)
+	if response.Header.ResponseCode != parser.FORMAT_ERROR {
+		t.Fatalf("no question should be a format error: %s", response)
+	}
+
+	// asking for a server cookie only, RFC 7873 section 5.4
+	option, _ := parser.Cookie{Client: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}.Option()
+	empty.SetEDNS(parser.EDNS{UDPSize: 1232, Options: []parser.EDNSOption{option}})
+	response = queryMessage(t, newServer(fixedAnswer), empty)
+	if response.Header.ResponseCode != parser.NO_ERROR || len(response.Questions) != 0 {
+		t.Fatalf("cookie only query should be answered: %s", response)
+	}
+	edns, _ := response.EDNS()
+	option, ok := edns.Option(parser.COOKIE)
+	if cookie, err := parser.ParseCookie(option); !ok || err != nil || len(cookie.Server) == 0 {
+		t.Fatalf("response should carry a server cookie: %v", edns)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	message, err := parser.Parse(euReferral)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	members := map[string]any{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	for _, member := range []string{"ID", "QR", "Opcode", "RCODE", "QDCOUNT", "NSCOUNT", "questionRRs", "authorityRRs", "messageOctetsHEX"} {
+		if _, ok := members[member]; !ok {
+			t.Fatalf("member %s is missing in %s", member, data)
+		}
+	}
+	packed, err := message.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if members["messageOctetsHEX"] != fmt.Sprintf("%X", packed) {
+		t.Fatalf("message octets dont match is %s", members["messageOctetsHEX"])
+	}
+
+	is := parser.Message{}
+	if err := json.Unmarshal(data, &is); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if !reflect.DeepEqual(is, message) {
+		t.Fatalf("message dont match is %s want %s", is, message)
+	}
+
+	// without the octets the message is put together from its members
+	delete(members, "messageOctetsHEX")
+	data, _ = json.Marshal(members)
+	is = parser.Message{}
+	if err := json.Unmarshal(data, &is); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	buf, err := is.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	CompareBytes(t, buf, packed)
+
+	answer, _ := parser.ParseRecord("www.example.com. 60 IN MX 10 mail.example.com.")
+	data, err = json.Marshal(answer)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	expect := `{"CLASS":1,"CLASSname":"IN","NAME":"www.example.com.","RDATAHEX":"000A046D61696C076578616D706C6503636F6D00","RDLENGTH":20,"TTL":60,"TYPE":15,"TYPEname":"MX","rdataMX":"10 mail.example.com."}`
+	if string(data) != expect {
+		t.Fatalf("json dont match is %s want %s", data, expect)
+	}
+
+	// records can also be written by hand with names and presentation RDATA
+	for _, text := range []string{
+		`{"NAME":"www.example.com","TYPEname":"MX","CLASSname":"IN","TTL":60,"rdataMX":"10 mail.example.com."}`,
+		`{"NAME":"www.example.com.","TYPE":15,"CLASS":1,"TTL":60,"RDATAHEX":"000a046d61696c076578616d706c6503636f6d00"}`,
+	} {
+		is := parser.Answer{}
+		if err := json.Unmarshal([]byte(text), &is); err != nil {
+			t.Fatalf("%s: should not error: %s", text, err)
+		}
+		compareAnswer(t, is, answer)
+	}
+	for _, text := range []string{
+		`{"NAME":"www.example.com.","TYPE":15,"CLASS":1,"RDATAHEX":"zz"}`,
+		`{"NAME":"www.example.com.","TYPE":15,"CLASS":1,"RDLENGTH":4}`,
+		`{"NAME":"www..example.com.","TYPE":15,"CLASS":1}`,
+	} {
+		if err := json.Unmarshal([]byte(text), &parser.Answer{}); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("%s: should be a syntax error, is %v", text, err)
+		}
+	}
+
+	question := parser.Question{}
+	if err := json.Unmarshal([]byte(`{"QNAME":"example.com.","QTYPEname":"AAAA","QCLASS":1}`), &question); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareQuestion(t, question, parser.Question{Name: parser.Name{"example", "com"}, Type: parser.AAAA, Class: parser.IN})
+	out, err := json.Marshal(question)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if expect := `{"QNAME":"example.com.","QTYPE":28,"QTYPEname":"AAAA","QCLASS":1,"QCLASSname":"IN"}`; string(out) != expect {
+		t.Fatalf("json dont match is %s want %s", out, expect)
+	}
+}
+
+func TestEncodingInterfaces(t *testing.T) {
+	var _ encoding.BinaryMarshaler = parser.Message{}
+	var _ encoding.BinaryUnmarshaler = &parser.Message{}
+	var _ encoding.TextMarshaler = parser.Header{}
+	var _ encoding.TextUnmarshaler = &parser.Answer{}
+
+	message, err := parser.Parse(euReferral)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	message.SetRcode(parser.BADCOOKIE).SetEDNS(parser.EDNS{UDPSize: 1232, Options: []parser.EDNSOption{{Code: parser.COOKIE, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}}})
+	message.Header.AuthenticData = true
+
+	// through gob, which uses MarshalBinary
+	var network bytes.Buffer
+	if err := gob.NewEncoder(&network).Encode(message); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	is := parser.Message{}
+	if err := gob.NewDecoder(&network).Decode(&is); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	// the OPT record now carries the upper bits of the rcode, as after Parse
+	packed, _ := message.Pack()
+	want, _ := parser.Parse(packed)
+	if !reflect.DeepEqual(is, want) {
+		t.Fatalf("message dont match is %s want %s", is, want)
+	}
+
+	text, err := message.MarshalText()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	is = parser.Message{}
+	if err := is.UnmarshalText(text); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if !reflect.DeepEqual(is, message) {
+		t.Fatalf("message dont match is %s want %s", is, message)
+	}
+
+	header := parser.Header{}
+	if err := header.UnmarshalText([]byte("id: 7, opcode: 4, rcode: RCODE3000, flags: qr tc cd, qcount: 1, acount: 0, nscount: 0, arcount: 0")); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareHeaders(t, header, parser.Header{ID: 7, OPCODE: parser.NOTIFY, ResponseCode: 3000, TrunCation: true, CheckingDisabled: true, QuestionCount: 1})
+	for _, opcode := range []string{"16", "300"} {
+		text := "id: 7, opcode: " + opcode + ", rcode: NOERROR, flags: qr, qcount: 0, acount: 0, nscount: 0, arcount: 0"
+		if err := header.UnmarshalText([]byte(text)); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("opcode %s: should be a syntax error, is %v", opcode, err)
+		}
+	}
+
+	headerBuf, _ := message.Header.MarshalBinary()
+	questionBuf, _ := message.Questions[0].MarshalBinary()
+	answerBuf, _ := message.Authority[0].MarshalBinary()
+	for _, test := range []struct {
+		value encoding.BinaryUnmarshaler
+		buf   []byte
+	}{
+		{&parser.Header{}, headerBuf},
+		{&parser.Question{}, questionBuf},
+		{&parser.Answer{}, answerBuf},
+		{&parser.Message{}, packed},
+	} {
+		if err := test.value.UnmarshalBinary(test.buf); err != nil {
+			t.Fatalf("%T: should not error: %s", test.value, err)
+		}
+		if err := test.value.UnmarshalBinary(append(test.buf, 0)); !errors.Is(err, parser.ErrTrailingData) {
+			t.Fatalf("%T: should be a trailing data error, is %v", test.value, err)
+		}
+	}
+
+	question := parser.Question{}
+	if err := question.UnmarshalText([]byte("example.com. AAAA")); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareQuestion(t, question, parser.Question{Name: parser.Name{"example", "com"}, Type: parser.AAAA, Class: parser.IN})
+	for _, text := range []string{"example.com.", "example.com. IN A extra", "example.com. IN BOGUS"} {
+		if err := question.UnmarshalText([]byte(text)); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("%q: should be a syntax error, is %v", text, err)
+		}
+	}
+	err = is.UnmarshalText([]byte("id: 1, opcode: 0, rcode: NOERROR, flags: qr, qcount: 0, acount: 0, nscount: 0, arcount: 0\n;; ANSWER SECTION:\nexample. 60 IN A bogus"))
+	var zoneError *parser.ZoneError
+	if !errors.As(err, &zoneError) || zoneError.Line != 3 {
+		t.Fatalf("should be an error on line 3, is %v", err)
+	}
+}
+
+// the helpers below build captures the way tcpdump would write them
+
+func ipv4Packet(protocol byte, src, dst netip.Addr, payload []byte) []byte {
+	packet := binary.BigEndian.AppendUint16(nil, 0x4500)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(20+len(payload)))
+	packet = append(packet, 0, 0, 0x40, 0, 64, protocol, 0, 0)
+	packet = append(packet, src.AsSlice()...)
+	packet = append(packet, dst.AsSlice()...)
+	return append(packet, payload...)
+}
+
+func ipv6Packet(protocol byte, src, dst netip.Addr, payload []byte) []byte {
+	packet := []byte{0x60, 0, 0, 0}
+	packet = binary.BigEndian.AppendUint16(packet, uint16(len(payload)))
+	packet = append(packet, protocol, 64)
+	packet = append(packet, src.AsSlice()...)
+	packet = append(packet, dst.AsSlice()...)
+	return append(packet, payload...)
+}
+
+func udpDatagram(src, dst uint16, payload []byte) []byte {
+	datagram := binary.BigEndian.AppendUint16(nil, src)
+	datagram = binary.BigEndian.AppendUint16(datagram, dst)
+	datagram = binary.BigEndian.AppendUint16(datagram, uint16(8+len(payload)))
+	datagram = append(datagram, 0, 0)
+	return append(datagram, payload...)
+}
+
+func tcpSegment(src, dst uint16, seq uint32, flags byte, payload []byte) []byte {
+	segment := binary.BigEndian.AppendUint16(nil, src)
+	segment = binary.BigEndian.AppendUint16(segment, dst)
+	segment = binary.BigEndian.AppendUint32(segment, seq)
+	segment = append(segment, 0, 0, 0, 0, 5<<4, flags, 0xff, 0xff, 0, 0, 0, 0)
+	return append(segment, payload...)
+}
+
+func ethernetFrame(etherType uint16, packet []byte) []byte {
+	frame := []byte{2, 0, 0, 0, 0, 1, 2, 0, 0, 0, 0, 2}
+	frame = binary.BigEndian.AppendUint16(frame, etherType)
+	return append(frame, packet...)
+}
+
+// pcapFile writes a little endian pcap file with microsecond timestamps.
+func pcapFile(linkType uint32, start time.Time, packets ...[]byte) []byte {
+	file := binary.LittleEndian.AppendUint32(nil, 0xa1b2c3d4)
+	file = binary.LittleEndian.AppendUint16(file, 2)
+	file = binary.LittleEndian.AppendUint16(file, 4)
+	file = append(file, make([]byte, 8)...)
+	file = binary.LittleEndian.AppendUint32(file, 65535)
+	file = binary.LittleEndian.AppendUint32(file, linkType)
+	for i, packet := range packets {
+		ts := start.Add(time.Duration(i) * time.Millisecond)
+		file = binary.LittleEndian.AppendUint32(file, uint32(ts.Unix()))
+		file = binary.LittleEndian.AppendUint32(file, uint32(ts.Nanosecond()/1000))
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(packet)))
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(packet)))
+		file = append(file, packet...)
+	}
+	return file
+}
+
+func pcapngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	block := binary.BigEndian.AppendUint32(nil, blockType)
+	block = binary.BigEndian.AppendUint32(block, uint32(12+len(body)))
+	block = append(block, body...)
+	return binary.BigEndian.AppendUint32(block, uint32(12+len(body)))
+}
+
+// pcapngFile writes a big endian pcapng file with one interface using
+// nanosecond timestamps.
+func pcapngFile(linkType uint16, start time.Time, packets ...[]byte) []byte {
+	section := binary.BigEndian.AppendUint32(nil, 0x1a2b3c4d)
+	section = append(section, 0, 1, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	file := pcapngBlock(0x0a0d0d0a, section)
+	// if_tsresol 9, then the end of options
+	iface := binary.BigEndian.AppendUint16(nil, linkType)
+	iface = append(iface, 0, 0, 0, 0, 0xff, 0xff, 0, 9, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	file = append(file, pcapngBlock(1, iface)...)
+	for i, packet := range packets {
+		ts := uint64(start.Add(time.Duration(i) * time.Millisecond).UnixNano())
+		body := binary.BigEndian.AppendUint32(nil, 0)
+		body = binary.BigEndian.AppendUint32(body, uint32(ts>>32))
+		body = binary.BigEndian.AppendUint32(body, uint32(ts))
+		body = binary.BigEndian.AppendUint32(body, uint32(len(packet)))
+		body = binary.BigEndian.AppendUint32(body, uint32(len(packet)))
+		file = append(file, pcapngBlock(6, append(body, packet...))...)
+	}
+	return file
+}
+
+func TestPcap(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	client := netip.MustParseAddr("192.0.2.1")
+	resolver := netip.MustParseAddr("192.0.2.53")
+	client6 := netip.MustParseAddr("2001:db8::1")
+	resolver6 := netip.MustParseAddr("2001:db8::53")
+	query, _ := parser.NewQuery(parser.Name{"example", "com"}, parser.A).Pack()
+	response, _ := parser.Parse(euReferral)
+	responseBuf, _ := response.Pack()
+
+	// a response over TCP, split in three segments that arrive out of order
+	// with a retransmission
+	stream := binary.BigEndian.AppendUint16(nil, uint16(len(responseBuf)))
+	stream = append(stream, responseBuf...)
+	stream = append(binary.BigEndian.AppendUint16(stream, uint16(len(query))), query...)
+	isn := uint32(0xfffffff0)
+	segments := [][]byte{
+		ethernetFrame(0x0800, ipv4Packet(6, resolver, client, tcpSegment(53, 40000, isn, 0x12, nil))),
+		ethernetFrame(0x0800, ipv4Packet(6, resolver, client, tcpSegment(53, 40000, isn+1+100, 0x10, stream[100:300]))),
+		ethernetFrame(0x0800, ipv4Packet(6, resolver, client, tcpSegment(53, 40000, isn+1, 0x10, stream[:150]))),
+		ethernetFrame(0x0800, ipv4Packet(6, resolver, client, tcpSegment(53, 40000, isn+1, 0x10, stream[:150]))),
+		ethernetFrame(0x0800, ipv4Packet(6, resolver, client, tcpSegment(53, 40000, isn+1+300, 0x11, stream[300:]))),
+	}
+
+	udp := ethernetFrame(0x0800, ipv4Packet(17, client, resolver, udpDatagram(40000, 53, query)))
+	vlan := append([]byte{2, 0, 0, 0, 0, 1, 2, 0, 0, 0, 0, 2, 0x81, 0, 0, 7, 0x86, 0xdd},
+		ipv6Packet(17, resolver6, client6, udpDatagram(53, 40001, responseBuf))...)
+	notDNS := ethernetFrame(0x0800, ipv4Packet(17, client, resolver, udpDatagram(40000, 123, query)))
+	malformed := ethernetFrame(0x0800, ipv4Packet(17, client, resolver, udpDatagram(40000, 53, query[:14])))
+
+	for name, file := range map[string][]byte{
+		"pcap":   pcapFile(1, start, append([][]byte{udp, notDNS, vlan, malformed}, segments...)...),
+		"pcapng": pcapngFile(1, start, append([][]byte{udp, notDNS, vlan, malformed}, segments...)...),
+	} {
+		messages, err := pcap.ReadAll(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", name, err)
+		}
+		if len(messages) != 5 {
+			t.Fatalf("%s: should find 5 messages, found %d", name, len(messages))
+		}
+
+		first := messages[0]
+		if first.Transport != "udp" || first.Src != netip.AddrPortFrom(client, 40000) || first.Dst != netip.AddrPortFrom(resolver, 53) {
+			t.Fatalf("%s: wrong endpoints %s %s -> %s", name, first.Transport, first.Src, first.Dst)
+		}
+		want := start.Truncate(time.Microsecond)
+		if name == "pcapng" {
+			want = start
+		}
+		if !first.Time.Equal(want) {
+			t.Fatalf("%s: time is %s want %s", name, first.Time, want)
+		}
+		if !bytes.Equal(first.Raw, query) || first.Err != nil || first.Message.Questions[0].Type != parser.A {
+			t.Fatalf("%s: wrong query %s", name, first.Message)
+		}
+		if messages[1].Src != netip.AddrPortFrom(resolver6, 53) || !reflect.DeepEqual(messages[1].Message, response) {
+			t.Fatalf("%s: wrong IPv6 response %s", name, messages[1].Message)
+		}
+		if !errors.Is(messages[2].Err, parser.ErrTruncatedMessage) {
+			t.Fatalf("%s: should be a short message, is %v", name, messages[2].Err)
+		}
+		for _, message := range messages[3:] {
+			if message.Transport != "tcp" || message.Err != nil {
+				t.Fatalf("%s: wrong TCP message %s %v", name, message.Transport, message.Err)
+			}
+		}
+		if !reflect.DeepEqual(messages[3].Message, response) || !bytes.Equal(messages[4].Raw, query) {
+			t.Fatalf("%s: TCP stream not reassembled: %s", name, messages[3].Message)
+		}
+	}
+
+	// Linux cooked capture, with the message of the query
+	sll := append(make([]byte, 14), 0x08, 0x00)
+	sll = append(sll, ipv4Packet(17, client, resolver, udpDatagram(40000, 53, query))...)
+	messages, err := pcap.ReadAll(bytes.NewReader(pcapFile(113, start, sll)))
+	if err != nil || len(messages) != 1 || !bytes.Equal(messages[0].Raw, query) {
+		t.Fatalf("SLL capture should have the query, is %v %v", messages, err)
+	}
+
+	if _, err := pcap.ReadAll(strings.NewReader("not a capture")); !errors.Is(err, pcap.ErrUnknownFormat) {
+		t.Fatalf("should be an unknown format error, is %v", err)
+	}
+	file := pcapFile(1, start, udp, udp)
+	messages, err = pcap.ReadAll(bytes.NewReader(file[:len(file)-10]))
+	if !errors.Is(err, pcap.ErrBadCapture) || len(messages) != 1 {
+		t.Fatalf("should be a bad capture error after one message, is %d %v", len(messages), err)
+	}
+}
+
+func TestSVCB(t *testing.T) {
+	// the test vectors of RFC 9460 appendix D
+	for _, test := range []struct {
+		t     parser.QType
+		text  string
+		wire  string
+		canon string
+	}{
+		{parser.HTTPS, "0 foo.example.com.", "000003666f6f076578616d706c6503636f6d00", ""},
+		{parser.SVCB, "1 .", "000100", ""},
+		{parser.SVCB, "16 foo.example.com. port=53", "0010" + "03666f6f076578616d706c6503636f6d00" + "000300020035", ""},
+		{parser.SVCB, "1 foo.example.com. key667=hello", "0001" + "03666f6f076578616d706c6503636f6d00" + "029b000568656c6c6f", `1 foo.example.com. key667="hello"`},
+		{parser.SVCB, `1 foo.example.com. key667="hello\210qoo"`, "0001" + "03666f6f076578616d706c6503636f6d00" + "029b000968656c6c6fd2716f6f", ""},
+		{parser.SVCB, `1 foo.example.com. ipv6hint="2001:db8::1,2001:db8::53:1"`, "0001" + "03666f6f076578616d706c6503636f6d00" + "0006002020010db800000000000000000000000120010db8000000000000000000530001", "1 foo.example.com. ipv6hint=2001:db8::1,2001:db8::53:1"},
+		{parser.SVCB, "16 foo.example.org. alpn=h2,h3-19 mandatory=ipv4hint,alpn ipv4hint=192.0.2.1", "0010" + "03666f6f076578616d706c65036f726700" + "000000040001000400010009026832056833" + "2d3139" + "00040004c0000201", `16 foo.example.org. mandatory=alpn,ipv4hint alpn="h2,h3-19" ipv4hint=192.0.2.1`},
+		{parser.SVCB, `16 foo.example.org. alpn="f\\\\oo\\,bar,h2"`, "0010" + "03666f6f076578616d706c65036f726700" + "0001000c08665c6f6f2c62617202" + "6832", ""},
+		{parser.SVCB, `16 foo.example.org. alpn=f\\\092oo\092,bar,h2`, "0010" + "03666f6f076578616d706c65036f726700" + "0001000c08665c6f6f2c62617202" + "6832", `16 foo.example.org. alpn="f\\\\oo\\,bar,h2"`},
+		{parser.HTTPS, "1 . alpn=h3 no-default-alpn ech=AEn+DQBFKwAgACABWIHUGj4u+PIggYXcR5JF0gYk3dCRioBW8uJq9H4mKAAIAAEAAQABAANAEnB1YmxpYy50bHMtZWNoLmRldgAA", "", `1 . alpn="h3" no-default-alpn ech=AEn+DQBFKwAgACABWIHUGj4u+PIggYXcR5JF0gYk3dCRioBW8uJq9H4mKAAIAAEAAQABAANAEnB1YmxpYy50bHMtZWNoLmRldgAA`},
+	} {
+		data, err := parser.ParseRData(test.t, strings.Fields(test.text), nil)
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", test.text, err)
+		}
+		if data.Type() != test.t {
+			t.Fatalf("%s: type dont match is %s want %s", test.text, data.Type(), test.t)
+		}
+		if test.wire != "" {
+			length := fmt.Sprint(len(test.wire) / 2)
+			fromWire, err := parser.ParseGenericRData(test.t, []string{`\#`, length, test.wire})
+			if err != nil {
+				t.Fatalf("%s: should not error: %s", test.text, err)
+			}
+			if !reflect.DeepEqual(fromWire, data) {
+				t.Fatalf("%s: rdata dont match is %v want %v", test.text, fromWire, data)
+			}
+		}
+		canon := test.canon
+		if canon == "" {
+			canon = test.text
+		}
+		if is := data.String(); is != canon {
+			t.Fatalf("presentation dont match is %s want %s", is, canon)
+		}
+		// presentation format goes through the lexer in a record
+		answer, err := parser.ParseRecord("example.com. 60 IN " + test.t.String() + " " + data.String())
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", test.text, err)
+		}
+		if !reflect.DeepEqual(answer.Data, data) {
+			t.Fatalf("%s: rdata dont match is %v want %v", test.text, answer.Data, data)
+		}
+	}
+
+	record, _ := parser.ParseRData(parser.SVCB, []string{"1", ".", "port=8443", "alpn=h2"}, nil)
+	svcb := record.(parser.SVCBRecord)
+	if port, ok := svcb.Param(parser.SVCB_PORT); !ok || !bytes.Equal(port.Value, []byte{0x20, 0xfb}) {
+		t.Fatalf("port dont match is %v", port)
+	}
+	answer := parser.Answer{Name: parser.Name{"example", "com"}, Type: parser.SVCB, Class: parser.IN, Data: svcb}
+	buf, err := parser.Message{Answers: []parser.Answer{answer, answer}}.Pack()
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	message, err := parser.Parse(buf)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	compareAnswer(t, message.Answers[1], answer)
+
+	// the failure cases of RFC 9460 appendix D.3 and more
+	for _, text := range []string{
+		"1 foo.example.com. key123=abc key123=def",
+		"1 foo.example.com. mandatory",
+		"1 foo.example.com. alpn",
+		"1 foo.example.com. port",
+		"1 foo.example.com. ipv4hint",
+		"1 foo.example.com. ipv6hint",
+		"1 foo.example.com. no-default-alpn=abc",
+		"1 foo.example.com. mandatory=key123",
+		"1 foo.example.com. mandatory=mandatory",
+		"1 foo.example.com. mandatory=alpn,alpn alpn=h2",
+		"1 foo.example.com. no-default-alpn",
+		"1 foo.example.com. ipv4hint=2001:db8::1",
+		"1 foo.example.com. ipv6hint=192.0.2.1",
+		"1 foo.example.com. port=65536",
+		"1 foo.example.com. alpn=h2,,h3",
+		"1 foo.example.com. key065=a",
+		"1 foo.example.com. key65535=a",
+		"1 foo.example.com. bogus=a",
+		"1",
+	} {
+		if _, err := parser.ParseRData(parser.SVCB, strings.Fields(text), nil); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("%s: should be a syntax error, is %v", text, err)
+		}
+	}
+
+	for _, wire := range []string{
+		// keys out of order
+		"000100" + "0003000201bb" + "000100030268 32",
+		// repeated key
+		"000100" + "0003000201bb" + "0003000201bb",
+		// port of three octets
+		"000100" + "000300030001bb",
+		// alpn id longer than the value
+		"000100" + "000100030568 32",
+		// mandatory keys out of order
+		"000100" + "0000000400030001",
+	} {
+		data, _ := hex.DecodeString(strings.ReplaceAll(wire, " ", ""))
+		_, err := parser.ParseGenericRData(parser.SVCB, []string{`\#`, fmt.Sprint(len(data)), hex.EncodeToString(data)})
+		if !errors.Is(err, parser.ErrBadSvcParams) {
+			t.Fatalf("%s: should be a SvcParams error, is %v", wire, err)
+		}
+	}
+	// self-consistency is only checked by Validate, the wire format is fine
+	inconsistent := parser.SVCBRecord{Priority: 1, Params: []parser.SvcParam{{Key: parser.SVCB_MANDATORY, Value: []byte{0, 3}}}}
+	if err := inconsistent.Validate(); !errors.Is(err, parser.ErrBadSvcParams) {
+		t.Fatalf("should be a SvcParams error, is %v", err)
+	}
+	unordered := parser.Answer{Name: parser.Name{"example", "com"}, Type: parser.SVCB, Class: parser.IN, Data: parser.SVCBRecord{
+		Priority: 1,
+		Params:   []parser.SvcParam{{Key: parser.SVCB_PORT, Value: []byte{0, 53}}, {Key: parser.SVCB_ALPN, Value: []byte{2, 'h', '2'}}},
+	}}
+	if _, err := (parser.Message{Answers: []parser.Answer{unordered}}).Pack(); !errors.Is(err, parser.ErrBadSvcParams) {
+		t.Fatalf("should be a SvcParams error, is %v", err)
+	}
+}
+
+func TestDNSSEC(t *testing.T) {
+	// the examples of RFC 4034 sections 2.3, 3.3, 4.3 and 5.4 and RFC 5155
+	// appendix A
+	for _, text := range []string{
+		"dskey.example.com. 86400 IN DNSKEY 256 3 5 ( AQOeiiR0GOMYkDshWoSKz9Xz\n fwJr1AYtsmx3TGkJaNXVbfi/2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvxegXd/M5+X7OrzKBaMbCVdFLUUh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw== )",
+		"dskey.example.com. 86400 IN DS 60485 5 1 ( 2BB183AF5F22588179A53B0A98631FAD1A292118 )",
+		"host.example.com. 86400 IN RRSIG A 5 3 86400 20030322173103 ( 20030220173103 2642 example.com. oJB1W6WNGv+ldvQ3WDG0MQkg5IEhjRip8WTrPYGv07h108dUKGMeDPKijVCHX3DDKdfb+v6oB9wfuh3DTJXUAfI/M0zmO/zz8bW0Rznl8O3tGNazPwQKkRN20XPXV6nwwfoXmJQbsLNrLfkGJ5D6fwFm8nN+6pBzeDQfsS3Ap3o= )",
+		"alfa.example.com. 86400 IN NSEC host.example.com. ( A MX RRSIG NSEC TYPE1234 )",
+		"0p9mhaveqvm6t7vbl5lop2u3t2rp3tom.example. 3600 IN NSEC3 1 1 12 aabbccdd ( 2t7b4g4vsa5smi47k61mv5bv1a22bojr MX DNSKEY NS SOA NSEC3PARAM RRSIG )",
+		"example. 3600 IN NSEC3PARAM 1 0 12 aabbccdd",
+		"example. 3600 IN NSEC3PARAM 1 0 0 -",
+		"empty.example. 3600 IN NSEC3 1 0 0 - 2t7b4g4vsa5smi47k61mv5bv1a22bojr",
+	} {
+		answer, err := parser.ParseRecord(text)
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", text, err)
+		}
+		// back through presentation and wire format
+		again, err := parser.ParseRecord(answer.String())
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", answer, err)
+		}
+		compareAnswer(t, again, answer)
+		buf, err := answer.ToBinary()
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", text, err)
+		}
+		parsed, err := parser.ParseAnswer(parser.NewLookBackBuffer(buf))
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", text, err)
+		}
+		compareAnswer(t, parsed, answer)
+	}
+
+	nsec, _ := parser.ParseRecord("alfa.example.com. 86400 IN NSEC host.example.com. ( A MX RRSIG NSEC TYPE1234 )")
+	buf, _ := nsec.ToBinary()
+	want, _ := hex.DecodeString("04686f7374076578616d706c6503636f6d00" + "0006400100000003" + "041b" + strings.Repeat("00", 26) + "20")
+	CompareBytes(t, buf[len(buf)-len(want):], want)
+	if record := nsec.Data.(parser.NSECRecord); !record.HasType(parser.MX) || record.HasType(parser.AAAA) {
+		t.Fatalf("types dont match is %v", record.Types)
+	}
+	if is := nsec.Data.String(); is != "host.example.com. A MX RRSIG NSEC TYPE1234" {
+		t.Fatalf("presentation dont match is %s", is)
+	}
+
+	rrsig, _ := parser.ParseRecord("host.example.com. 86400 IN RRSIG A 5 3 86400 1048354263 20030220173103 2642 example.com. AAAA")
+	if record := rrsig.Data.(parser.RRSIGRecord); record.Expiration != 1048354263 || record.Inception != 1045762263 {
+		t.Fatalf("times dont match is %d %d", record.Expiration, record.Inception)
+	}
+	if !strings.Contains(rrsig.Data.String(), " 20030322173103 20030220173103 ") {
+		t.Fatalf("times dont match is %s", rrsig.Data)
+	}
+
+	// key tags and DS digests
+	for _, test := range []struct {
+		owner  parser.Name
+		key    string
+		tag    uint16
+		digest parser.DigestType
+		ds     string
+	}{
+		{
+			parser.Name{"dskey", "example", "com"},
+			"256 3 5 AQOeiiR0GOMYkDshWoSKz9XzfwJr1AYtsmx3TGkJaNXVbfi/2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvxegXd/M5+X7OrzKBaMbCVdFLUUh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw==",
+			60485, parser.SHA1, "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118",
+		},
+		{
+			// RFC 6605 section 6.1
+			parser.Name{"example", "net"},
+			"257 3 13 GojIhhXUN/u4v54ZQqGSnyhWJwaubCvTmeexv7bR6edbkrSqQpF64cYbcB7wNcP+e+MAnLr+Wi9xMWyQLc8NAA==",
+			55648, parser.SHA256, "55648 13 2 B4C8C1FE2E7477127B27115656AD6256F424625BF5C1E2770CE6D6E37DF61D17",
+		},
+	} {
+		data, err := parser.ParseRData(parser.DNSKEY, strings.Fields(test.key), nil)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		key := data.(parser.DNSKEYRecord)
+		if is := key.KeyTag(); is != test.tag {
+			t.Fatalf("key tag dont match is %d want %d", is, test.tag)
+		}
+		ds, err := key.DS(test.owner, test.digest)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		if is := ds.String(); is != test.ds {
+			t.Fatalf("DS dont match is %s want %s", is, test.ds)
+		}
+		// the owner is hashed in canonical form
+		upper := parser.Name{}
+		for _, label := range test.owner {
+			upper = append(upper, strings.ToUpper(label))
+		}
+		if again, _ := key.DS(upper, test.digest); !reflect.DeepEqual(again, ds) {
+			t.Fatalf("DS dont match is %s want %s", again, ds)
+		}
+		if _, err := key.DS(test.owner, 3); !errors.Is(err, parser.ErrUnknownDigest) {
+			t.Fatalf("should be an unknown digest error, is %v", err)
+		}
+	}
+
+	for _, wire := range []string{
+		// windows out of order
+		"00 00020040 0001 40",
+		// empty window
+		"00 0000",
+		// trailing zero octet
+		"00 00024000",
+		// window longer than 32 octets
+		"00 0021" + strings.Repeat("01", 33),
+	} {
+		data, _ := hex.DecodeString(strings.ReplaceAll(wire, " ", ""))
+		_, err := parser.ParseGenericRData(parser.NSEC, []string{`\#`, fmt.Sprint(len(data)), hex.EncodeToString(data)})
+		if !errors.Is(err, parser.ErrBadTypeBitmap) {
+			t.Fatalf("%s: should be a type bitmap error, is %v", wire, err)
+		}
+	}
+	for _, text := range []string{
+		"example. 60 IN DS 1 8 2 XYZ",
+		"example. 60 IN DNSKEY 257 3 8 !!!",
+		"example. 60 IN RRSIG A 8 1 60 20241301000000 20240101000000 1 example. AAAA",
+		"example. 60 IN NSEC3 1 0 0 - notbase32!",
+		"example. 60 IN NSEC3PARAM 1 0 0 xyz",
+		"example. 60 IN NSEC next.example. BOGUS",
+	} {
+		if _, err := parser.ParseRecord(text); !errors.Is(err, parser.ErrSyntax) {
+			t.Fatalf("%s: should be a syntax error, is %v", text, err)
+		}
+	}
+}
+
+// signingKey signs the records of the validation tests, the keys are made
+// up for every run.
+type signingKey struct {
+	zone   parser.Name
+	dnskey parser.DNSKEYRecord
+	signer crypto.Signer
+}
+
+func newSigningKey(t *testing.T, zone string, flags uint16, algorithm parser.Algorithm) signingKey {
+	name, err := parser.ParseName(zone)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	key := signingKey{zone: name, dnskey: parser.DNSKEYRecord{Flags: flags, Protocol: 3, Algorithm: algorithm}}
+	switch algorithm {
+	case parser.ED25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		key.dnskey.PublicKey, key.signer = public, private
+	default:
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		x, y := private.X.FillBytes(make([]byte, 32)), private.Y.FillBytes(make([]byte, 32))
+		key.dnskey.PublicKey, key.signer = append(x, y...), private
+	}
+	return key
+}
+
+func (key signingKey) record() parser.Answer {
+	return parser.Answer{Name: key.zone, Type: parser.DNSKEY, Class: parser.IN, TTL: 3600, Data: key.dnskey}
+}
+
+func (key signingKey) ds(t *testing.T) parser.Answer {
+	ds, err := key.dnskey.DS(key.zone, parser.SHA256)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	return parser.Answer{Name: key.zone, Type: parser.DS, Class: parser.IN, TTL: 3600, Data: ds}
+}
+
+// sign returns the RRSIG of rrset, valid for an hour around now.
+func (key signingKey) sign(t *testing.T, rrset []parser.Answer) parser.Answer {
+	labels := len(rrset[0].Name)
+	if labels > 0 && rrset[0].Name[0] == "*" {
+		labels--
+	}
+	now := uint32(time.Now().Unix())
+	sig := parser.RRSIGRecord{
+		TypeCovered: rrset[0].Type,
+		Algorithm:   key.dnskey.Algorithm,
+		Labels:      uint8(labels),
+		OriginalTTL: rrset[0].TTL,
+		Expiration:  now + 3600,
+		Inception:   now - 3600,
+		KeyTag:      key.dnskey.KeyTag(),
+		SignerName:  key.zone,
+	}
+	data, err := parser.SignedData(sig, rrset)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	switch signer := key.signer.(type) {
+	case ed25519.PrivateKey:
+		sig.Signature = ed25519.Sign(signer, data)
+	case *ecdsa.PrivateKey:
+		hash := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, signer, hash[:])
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		sig.Signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return parser.Answer{Name: rrset[0].Name, Type: parser.RRSIG, Class: parser.IN, TTL: rrset[0].TTL, Data: sig}
+}
+
+// signZone adds the keys and signatures to the records of a zone. The KSK
+// signs the DNSKEY RRset, the ZSK everything else but delegations.
+func signZone(t *testing.T, ksk signingKey, zsk signingKey, texts ...string) []parser.Answer {
+	records := []parser.Answer{ksk.record()}
+	if zsk.dnskey.Flags != ksk.dnskey.Flags {
+		records = append(records, zsk.record())
+	}
+	for _, text := range texts {
+		record, err := parser.ParseRecord(text)
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", text, err)
+		}
+		records = append(records, record)
+	}
+	signed := append([]parser.Answer{}, records...)
+	done := map[string]bool{}
+	for _, record := range records {
+		key := record.Name.Canonical().String() + " " + record.Type.String()
+		if done[key] || record.Type == parser.NS && !record.Name.Equal(ksk.zone) {
+			continue
+		}
+		done[key] = true
+		rrset := []parser.Answer{}
+		for _, other := range records {
+			if other.Name.Equal(record.Name) && other.Type == record.Type {
+				rrset = append(rrset, other)
+			}
+		}
+		if record.Type == parser.DNSKEY {
+			signed = append(signed, ksk.sign(t, rrset))
+		} else {
+			signed = append(signed, zsk.sign(t, rrset))
+		}
+	}
+	return signed
+}
+
+// signedHierarchy returns the records of a signed root and its zones
+// example., signed with NSEC and containing the unsigned delegation
+// insecure.example., and org., signed with NSEC3. The anchor is the DS of the
+// root KSK.
+func signedHierarchy(t *testing.T) (root []parser.Answer, example []parser.Answer, org []parser.Answer, anchor parser.Answer) {
+	rootKey := newSigningKey(t, ".", parser.DNSKEY_ZONE|parser.DNSKEY_SEP, parser.ECDSAP256SHA256)
+	exampleKSK := newSigningKey(t, "example.", parser.DNSKEY_ZONE|parser.DNSKEY_SEP, parser.ED25519)
+	exampleZSK := newSigningKey(t, "example.", parser.DNSKEY_ZONE, parser.ECDSAP256SHA256)
+	orgKey := newSigningKey(t, "org.", parser.DNSKEY_ZONE|parser.DNSKEY_SEP, parser.ECDSAP256SHA256)
+
+	root = signZone(t, rootKey, rootKey,
+		". 3600 IN SOA ns.root. hostmaster.root. 1 7200 3600 1209600 3600",
+		". 3600 IN NSEC example. NS SOA RRSIG NSEC DNSKEY",
+		"example. 3600 IN NS ns.example.",
+		exampleKSK.ds(t).String(),
+		"example. 3600 IN NSEC org. NS DS RRSIG NSEC",
+		"org. 3600 IN NS ns.org.",
+		orgKey.ds(t).String(),
+		"org. 3600 IN NSEC . NS DS RRSIG NSEC",
+	)
+	example = signZone(t, exampleKSK, exampleZSK,
+		"example. 3600 IN SOA ns.example. hostmaster.example. 1 7200 3600 1209600 3600",
+		"example. 3600 IN NSEC insecure.example. SOA RRSIG NSEC DNSKEY",
+		"insecure.example. 3600 IN NS ns.insecure.example.",
+		"insecure.example. 3600 IN NSEC *.wild.example. NS RRSIG NSEC",
+		"*.wild.example. 3600 IN A 192.0.2.2",
+		"*.wild.example. 3600 IN NSEC www.example. A RRSIG NSEC",
+		"www.example. 3600 IN A 192.0.2.1",
+		"www.example. 3600 IN NSEC example. A RRSIG NSEC",
+	)
+	// below the unsigned delegation
+	example = append(example, parser.Answer{
+		Name: parser.Name{"host", "insecure", "example"}, Type: parser.A, Class: parser.IN, TTL: 3600,
+		Data: parser.ARecord{Addr: netip.MustParseAddr("192.0.2.3")},
+	})
+
+	// NSEC3 without salt and iterations, RFC 9276 section 3.1
+	apexHash := dnssec.HashName(parser.Name{"org"}, nil, 0)
+	hostHash := dnssec.HashName(parser.Name{"host", "org"}, nil, 0)
+	hashed := func(hash []byte) string {
+		return strings.ToLower(base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(hash))
+	}
+	org = signZone(t, orgKey, orgKey,
+		"org. 3600 IN SOA ns.org. hostmaster.org. 1 7200 3600 1209600 3600",
+		"org. 0 IN NSEC3PARAM 1 0 0 -",
+		"host.org. 3600 IN A 192.0.2.4",
+		hashed(apexHash)+".org. 3600 IN NSEC3 1 0 0 - "+hashed(hostHash)+" SOA RRSIG DNSKEY NSEC3PARAM",
+		hashed(hostHash)+".org. 3600 IN NSEC3 1 0 0 - "+hashed(apexHash)+" A RRSIG",
+	)
+	return root, example, org, rootKey.ds(t)
+}
+
+// hierarchy looks up records in the zone they belong to, DS records in the
+// parent.
+type hierarchy []*zone
+
+func (h hierarchy) Lookup(name parser.Name, t parser.QType) (parser.Message, error) {
+	owner := name
+	if t == parser.DS && len(name) > 0 {
+		owner = name.Parent()
+	}
+	var closest *zone
+	var closestApex parser.Name
+	for _, z := range h {
+		if apex, ok := z.apex(owner); ok && (closest == nil || len(apex) > len(closestApex)) {
+			closest, closestApex = z, apex
+		}
+	}
+	if closest == nil {
+		return parser.Message{}, fmt.Errorf("no zone for %s", name)
+	}
+	return closest.Lookup(name, t)
+}
+
+// countingResolver counts the lookups of the validator.
+type countingResolver struct {
+	dnssec.Resolver
+	lookups int
+}
+
+func (c *countingResolver) Lookup(name parser.Name, t parser.QType) (parser.Message, error) {
+	c.lookups++
+	return c.Resolver.Lookup(name, t)
+}
+
+// pick returns the records of the given types at name, with their
+// signatures.
+func pick(records []parser.Answer, name string, types ...parser.QType) []parser.Answer {
+	owner, _ := parser.ParseName(name)
+	picked := []parser.Answer{}
+	for _, record := range records {
+		if !record.Name.Equal(owner) {
+			continue
+		}
+		for _, t := range types {
+			sig, ok := record.Data.(parser.RRSIGRecord)
+			if record.Type == t || ok && sig.TypeCovered == t {
+				picked = append(picked, record)
+			}
+		}
+	}
+	return picked
+}
+
+func validatorResponse(name string, t parser.QType, rcode parser.RCODE, answers []parser.Answer, authority ...[]parser.Answer) parser.Message {
+	owner, _ := parser.ParseName(name)
+	response := parser.NewQuery(owner, t).Reply().SetRcode(rcode).AddAnswer(answers...)
+	for _, records := range authority {
+		response.AddAuthority(records...)
+	}
+	return *response
+}
+
+func TestValidate(t *testing.T) {
+	root, example, org, anchor := signedHierarchy(t)
+	resolver := hierarchy{newZone(root), newZone(example), newZone(org)}
+	anchors := []parser.Answer{anchor}
+
+	// the wildcard answer with the owner it was expanded to
+	expanded := pick(example, "*.wild.example.", parser.A)
+	for i := range expanded {
+		expanded[i].Name = parser.Name{"a", "wild", "example"}
+	}
+	tampered := pick(example, "www.example.", parser.A)
+	tampered[0].Data = parser.ARecord{Addr: netip.MustParseAddr("192.0.2.99")}
+	soa := pick(example, "example.", parser.SOA)
+	nxdomain := newZone(org).proofs(parser.Name{"org"}, parser.Name{"nope", "org"})
+	nodata := newZone(org).proofs(parser.Name{"org"}, parser.Name{"host", "org"})
+
+	for _, test := range []struct {
+		name     string
+		response parser.Message
+		status   dnssec.Status
+		err      error
+	}{
+		{"answer", validatorResponse("www.example.", parser.A, parser.NO_ERROR, pick(example, "www.example.", parser.A)), dnssec.Secure, nil},
+		{"tampered", validatorResponse("www.example.", parser.A, parser.NO_ERROR, tampered), dnssec.Bogus, dnssec.ErrBadSignature},
+		{"unsigned", validatorResponse("www.example.", parser.A, parser.NO_ERROR, pick(example, "www.example.", parser.A)[:1]), dnssec.Bogus, dnssec.ErrNoSignature},
+		{"unsigned delegation", validatorResponse("host.insecure.example.", parser.A, parser.NO_ERROR, pick(example, "host.insecure.example.", parser.A)), dnssec.Insecure, nil},
+		{"nxdomain", validatorResponse("nope.example.", parser.A, parser.NAME_ERROR, nil, soa, pick(example, "example.", parser.NSEC), pick(example, "insecure.example.", parser.NSEC)), dnssec.Secure, nil},
+		{"nxdomain without wildcard proof", validatorResponse("nope.example.", parser.A, parser.NAME_ERROR, nil, soa, pick(example, "insecure.example.", parser.NSEC)), dnssec.Bogus, dnssec.ErrNoDenial},
+		{"nodata", validatorResponse("www.example.", parser.AAAA, parser.NO_ERROR, nil, soa, pick(example, "www.example.", parser.NSEC)), dnssec.Secure, nil},
+		{"nodata for existing type", validatorResponse("www.example.", parser.A, parser.NO_ERROR, nil, soa, pick(example, "www.example.", parser.NSEC)), dnssec.Bogus, dnssec.ErrNoDenial},
+		{"wildcard", validatorResponse("a.wild.example.", parser.A, parser.NO_ERROR, expanded, pick(example, "*.wild.example.", parser.NSEC)), dnssec.Secure, nil},
+		{"wildcard without proof", validatorResponse("a.wild.example.", parser.A, parser.NO_ERROR, expanded, pick(example, "www.example.", parser.NSEC)), dnssec.Bogus, dnssec.ErrNoDenial},
+		{"referral", validatorResponse("www.example.", parser.A, parser.NO_ERROR, nil, pick(root, "example.", parser.NS, parser.DS)), dnssec.Secure, nil},
+		{"nsec3 nxdomain", validatorResponse("nope.org.", parser.A, parser.NAME_ERROR, nil, nxdomain), dnssec.Secure, nil},
+		{"nsec3 nodata", validatorResponse("host.org.", parser.AAAA, parser.NO_ERROR, nil, nodata), dnssec.Secure, nil},
+		{"nsec3 nodata for existing type", validatorResponse("host.org.", parser.A, parser.NO_ERROR, nil, nodata), dnssec.Bogus, dnssec.ErrNoDenial},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			result := dnssec.NewValidator(resolver, anchors).Validate(test.response)
+			if result.Status != test.status {
+				t.Fatalf("status dont match is %s (%v) expect %s", result.Status, result.Err, test.status)
+			}
+			if test.err != nil && !errors.Is(result.Err, test.err) {
+				t.Fatalf("error dont match is %v expect %v", result.Err, test.err)
+			}
+		})
+	}
+
+	answer := validatorResponse("www.example.", parser.A, parser.NO_ERROR, pick(example, "www.example.", parser.A))
+	validator := dnssec.NewValidator(resolver, anchors)
+	validator.Now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if result := validator.Validate(answer); result.Status != dnssec.Bogus || !errors.Is(result.Err, dnssec.ErrSignatureTime) {
+		t.Fatalf("expired signature should be bogus, is %s (%v)", result.Status, result.Err)
+	}
+	// keys are kept as long as their signatures and TTLs allow
+	counting := &countingResolver{Resolver: resolver}
+	now := time.Now()
+	validator = dnssec.NewValidator(counting, anchors)
+	validator.Now = func() time.Time { return now }
+	result := validator.Validate(answer)
+	if result.Status != dnssec.Secure || result.Expires.After(now.Add(time.Hour)) || result.Expires.Before(now.Add(time.Hour-time.Minute)) {
+		t.Fatalf("result dont match is %s until %s", result.Status, result.Expires)
+	}
+	lookups := counting.lookups
+	now = now.Add(30 * time.Minute)
+	if validator.Validate(answer); counting.lookups != lookups {
+		t.Fatalf("keys should be cached, lookups went from %d to %d", lookups, counting.lookups)
+	}
+	now = now.Add(time.Hour)
+	if validator.Validate(answer); counting.lookups == lookups {
+		t.Fatalf("expired keys should be looked up again")
+	}
+
+	orgAnchor := pick(org, "org.", parser.DNSKEY)[:1]
+	if result := dnssec.NewValidator(resolver, orgAnchor).Validate(answer); result.Status != dnssec.Indeterminate || !errors.Is(result.Err, dnssec.ErrNoTrustAnchor) {
+		t.Fatalf("answer outside the anchor should be indeterminate, is %s (%v)", result.Status, result.Err)
+	}
+
+	// RFC 5155 appendix A
+	hash := dnssec.HashName(parser.Name{"example"}, []byte{0xaa, 0xbb, 0xcc, 0xdd}, 12)
+	if encoded := strings.ToLower(base32.HexEncoding.EncodeToString(hash)); encoded != "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom" {
+		t.Fatalf("hash dont match is %s", encoded)
+	}
+}
+
+func TestHandleAuthenticData(t *testing.T) {
+	_, example, _, _ := signedHierarchy(t)
+	z := newZone(example)
+	// the zone trusts its own KSK
+	z.validator = dnssec.NewValidator(z, pick(example, "example.", parser.DNSKEY)[:1])
+	server := newServer(z.answer)
+
+	for _, test := range []struct {
+		name          string
+		qname         parser.Name
+		edns          *parser.EDNS
+		authenticData bool
+		expect        bool
+	}{
+		{"dnssec ok", parser.Name{"www", "example"}, &parser.EDNS{UDPSize: 1232, DNSSECOK: true}, false, true},
+		{"ad", parser.Name{"www", "example"}, nil, true, true},
+		{"not asked for", parser.Name{"www", "example"}, &parser.EDNS{UDPSize: 1232}, false, false},
+		{"nxdomain", parser.Name{"nope", "example"}, &parser.EDNS{UDPSize: 1232, DNSSECOK: true}, false, true},
+		{"insecure", parser.Name{"host", "insecure", "example"}, &parser.EDNS{UDPSize: 1232, DNSSECOK: true}, false, false},
+		{"wildcard", parser.Name{"a", "wild", "example"}, &parser.EDNS{UDPSize: 1232, DNSSECOK: true}, false, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			request := parser.NewQuery(test.qname, parser.A)
+			request.Header.AuthenticData = test.authenticData
+			if test.edns != nil {
+				request.SetEDNS(*test.edns)
+			}
+			response := queryMessage(t, server, *request)
+			if response.Header.AuthenticData != test.expect {
+				t.Fatalf("ad dont match is %v expect %v", response.Header.AuthenticData, test.expect)
+			}
+		})
+	}
+
+	// the presigned zone hands out its own signatures and proofs
+	request := parser.NewQuery(parser.Name{"nope", "example"}, parser.A).SetEDNS(parser.EDNS{UDPSize: 1232, DNSSECOK: true})
+	response := queryMessage(t, server, *request)
+	types := map[parser.QType]int{}
+	for _, record := range response.Authority {
+		types[record.Type]++
+	}
+	// the SOA, the NSEC covering the name and the one covering the wildcard,
+	// each with its RRSIG
+	if types[parser.SOA] != 1 || types[parser.NSEC] != 2 || types[parser.RRSIG] != 3 {
+		t.Fatalf("authority dont match is %v", response.Authority)
+	}
+}
+
+func TestZoneReferral(t *testing.T) {
+	root, example, _, anchor := signedHierarchy(t)
+	glue, err := parser.ParseRecord("ns.insecure.example. 3600 IN A 192.0.2.53")
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	z := newZone(append(example, glue))
+	types := func(answers []parser.Answer) []parser.QType {
+		found := []parser.QType{}
+		for _, answer := range answers {
+			found = append(found, answer.Type)
+		}
+		return found
+	}
+
+	for _, test := range []struct {
+		name       string
+		zone       *zone
+		qname      parser.Name
+		qtype      parser.QType
+		dnssecOK   bool
+		authority  []parser.QType
+		additional int
+	}{
+		{"unsigned", z, parser.Name{"host", "insecure", "example"}, parser.A, false, []parser.QType{parser.NS}, 1},
+		{"unsigned with proof", z, parser.Name{"host", "insecure", "example"}, parser.A, true, []parser.QType{parser.NS, parser.NSEC, parser.RRSIG}, 1},
+		{"at the cut", z, parser.Name{"insecure", "example"}, parser.NS, false, []parser.QType{parser.NS}, 1},
+		{"signed", newZone(root), parser.Name{"www", "example"}, parser.A, true, []parser.QType{parser.NS, parser.DS, parser.RRSIG}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			result := test.zone.answer(Query{Question: parser.Question{Name: test.qname, Type: test.qtype, Class: parser.IN}, DNSSECOK: test.dnssecOK})
+			if !result.Referral || result.ResponseCode != parser.NO_ERROR || len(result.Answers) != 0 {
+				t.Fatalf("should be a referral, is %v", result)
+			}
+			if is := types(result.Authority); !slices.Equal(is, test.authority) {
+				t.Fatalf("authority dont match is %v want %v", is, test.authority)
+			}
+			if len(result.Additional) != test.additional {
+				t.Fatalf("additional dont match is %v", result.Additional)
+			}
+		})
+	}
+
+	// the DS records are answered by the parent
+	result := z.answer(Query{Question: parser.Question{Name: parser.Name{"insecure", "example"}, Type: parser.DS, Class: parser.IN}, DNSSECOK: true})
+	if result.Referral || len(result.Answers) != 0 || !slices.Contains(types(result.Authority), parser.SOA) {
+		t.Fatalf("DS should be a negative answer, is %v", result)
+	}
+
+	// the signed referral validates, and AA is clear
+	server := newServer(newZone(root).answer)
+	request := parser.NewQuery(parser.Name{"www", "example"}, parser.A).SetEDNS(parser.EDNS{UDPSize: 1232, DNSSECOK: true})
+	response := queryMessage(t, server, *request)
+	if response.Header.AuthoritativeAnswer {
+		t.Fatalf("referral should not be authoritative")
+	}
+	resolver := hierarchy{newZone(root), newZone(example)}
+	if status := dnssec.NewValidator(resolver, []parser.Answer{anchor}).Validate(response).Status; status != dnssec.Secure {
+		t.Fatalf("status dont match is %s want %s", status, dnssec.Secure)
+	}
+}
+
+// writeKeyFiles stores key like dnssec-keygen and returns the prefix of the
+// files.
+func writeKeyFiles(t *testing.T, key signingKey) string {
+	prefix := filepath.Join(t.TempDir(), fmt.Sprintf("K%s+%03d+%05d", key.zone, key.dnskey.Algorithm, key.dnskey.KeyTag()))
+	var private []byte
+	switch signer := key.signer.(type) {
+	case ed25519.PrivateKey:
+		private = signer.Seed()
+	case *ecdsa.PrivateKey:
+		private = signer.D.FillBytes(make([]byte, 32))
+	}
+	public := fmt.Sprintf("; This is a zone key for %s\n%s IN DNSKEY %s\n", key.zone, key.zone, key.dnskey)
+	privateText := fmt.Sprintf("Private-key-format: v1.3\nAlgorithm: %d (%s)\nPrivateKey: %s\n", key.dnskey.Algorithm, key.dnskey.Algorithm, base64.StdEncoding.EncodeToString(private))
+	if err := os.WriteFile(prefix+".key", []byte(public), 0o600); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if err := os.WriteFile(prefix+".private", []byte(privateText), 0o600); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	return prefix
+}
+
+func TestSigner(t *testing.T) {
+	ksk := newSigningKey(t, "example.", parser.DNSKEY_ZONE|parser.DNSKEY_SEP, parser.ED25519)
+	zsk := newSigningKey(t, "example.", parser.DNSKEY_ZONE, parser.ECDSAP256SHA256)
+	keys := []dnssec.Key{}
+	for _, key := range []signingKey{ksk, zsk} {
+		loaded, err := dnssec.LoadKey(writeKeyFiles(t, key))
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		keys = append(keys, loaded)
+	}
+	other := newSigningKey(t, "example.", parser.DNSKEY_ZONE, parser.ECDSAP256SHA256)
+	mismatched := writeKeyFiles(t, other)
+	if err := os.WriteFile(mismatched+".key", []byte("example. IN DNSKEY "+zsk.dnskey.String()), 0o600); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if _, err := dnssec.LoadKey(mismatched); !errors.Is(err, dnssec.ErrBadKey) {
+		t.Fatalf("expect ErrBadKey for a private key of another DNSKEY, is %v", err)
+	}
+
+	records := []parser.Answer{}
+	for _, text := range []string{
+		"example. 3600 IN SOA ns.example. hostmaster.example. 1 7200 3600 1209600 300",
+		"example. 3600 IN NS ns.example.",
+		"ns.example. 3600 IN A 192.0.2.53",
+		"www.example. 3600 IN A 192.0.2.1",
+		"a.b.example. 3600 IN A 192.0.2.2",
+		"insecure.example. 3600 IN NS ns.insecure.example.",
+		"ns.insecure.example. 3600 IN A 192.0.2.3",
+		"*.w.example. 3600 IN A 192.0.2.9",
+	} {
+		record, err := parser.ParseRecord(text)
+		if err != nil {
+			t.Fatalf("%s: should not error: %s", text, err)
+		}
+		records = append(records, record)
+	}
+	anchors := []parser.Answer{ksk.ds(t)}
+
+	for _, nsec3 := range []*parser.NSEC3PARAMRecord{nil, {HashAlgorithm: parser.NSEC3_SHA1, Salt: []byte{0xaa, 0xbb}, Iterations: 1}} {
+		signer, err := dnssec.NewSigner(parser.Name{"example"}, keys, records, nsec3)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		z := newZone(slices.Clone(records))
+		z.sign(signer)
+		if nsec3 != nil {
+			params := pick(z.records, "example.", parser.NSEC3PARAM)
+			if len(params) != 1 || params[0].TTL != 300 {
+				t.Fatalf("expect NSEC3PARAM with the negative TTL 300, is %v", params)
+			}
+		}
+		z.validator = dnssec.NewValidator(z, anchors)
+		server := newServer(z.answer)
+
+		for _, test := range []struct {
+			name   parser.Name
+			t      parser.QType
+			rcode  parser.RCODE
+			status dnssec.Status
+		}{
+			{parser.Name{"www", "example"}, parser.A, parser.NO_ERROR, dnssec.Secure},
+			{parser.Name{"example"}, parser.DNSKEY, parser.NO_ERROR, dnssec.Secure},
+			{parser.Name{"www", "example"}, parser.AAAA, parser.NO_ERROR, dnssec.Secure},
+			{parser.Name{"b", "example"}, parser.A, parser.NO_ERROR, dnssec.Secure},
+			{parser.Name{"nope", "example"}, parser.A, parser.NAME_ERROR, dnssec.Secure},
+			{parser.Name{"nope", "b", "example"}, parser.TXT, parser.NAME_ERROR, dnssec.Secure},
+			{parser.Name{"ns", "insecure", "example"}, parser.A, parser.NO_ERROR, dnssec.Insecure},
+			{parser.Name{"a", "w", "example"}, parser.A, parser.NO_ERROR, dnssec.Secure},
+			{parser.Name{"a", "b", "w", "example"}, parser.A, parser.NO_ERROR, dnssec.Secure},
+			{parser.Name{"a", "w", "example"}, parser.AAAA, parser.NO_ERROR, dnssec.Secure},
+			{parser.Name{"*", "w", "example"}, parser.A, parser.NO_ERROR, dnssec.Secure},
+		} {
+			request := parser.NewQuery(test.name, test.t).SetEDNS(parser.EDNS{UDPSize: 1232, DNSSECOK: true})
+			response := queryMessage(t, server, *request)
+			if response.Header.ResponseCode != test.rcode {
+				t.Fatalf("%s %s: rcode dont match is %s expect %s", test.name, test.t, response.Header.ResponseCode, test.rcode)
+			}
+			// the response as served has to validate on its own
+			result := dnssec.NewValidator(z, anchors).Validate(response)
+			if result.Status != test.status {
+				t.Fatalf("%s %s: status dont match is %s (%v) expect %s", test.name, test.t, result.Status, result.Err, test.status)
+			}
+			if response.Header.AuthenticData != (test.status == dnssec.Secure) {
+				t.Fatalf("%s %s: ad dont match is %v", test.name, test.t, response.Header.AuthenticData)
+			}
+		}
+
+		// no signatures for clients that don't ask for them
+		response := queryMessage(t, server, *parser.NewQuery(parser.Name{"www", "example"}, parser.A))
+		if len(response.Answers) != 1 || len(response.Authority) != 0 {
+			t.Fatalf("expect the plain answer, is %s", response)
+		}
+		response = queryMessage(t, server, *parser.NewQuery(parser.Name{"nope", "example"}, parser.A))
+		if response.Header.ResponseCode != parser.NAME_ERROR || len(response.Authority) != 1 || response.Authority[0].Type != parser.SOA {
+			t.Fatalf("expect only the SOA in the authority section, is %s", response)
+		}
+		// the wildcard answers with the name asked for
+		response = queryMessage(t, server, *parser.NewQuery(parser.Name{"a", "w", "example"}, parser.A))
+		if len(response.Answers) != 1 || response.Answers[0].String() != "a.w.example. 3600 IN A 192.0.2.9" {
+			t.Fatalf("expect the expanded wildcard, is %s", response)
+		}
+	}
+
+	// signatures are reused until they are close to expiring
+	signer, err := dnssec.NewSigner(parser.Name{"example"}, keys, records, nil)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	now := time.Now()
+	signer.Now = func() time.Time { return now }
+	www := records[3:4]
+	first, err := signer.Sign(www)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	again, _ := signer.Sign(www)
+	if len(first) != 1 || first[0].String() != again[0].String() {
+		t.Fatalf("expect the cached signature, is %s and %s", first, again)
+	}
+	now = now.Add(6 * 24 * time.Hour)
+	later, _ := signer.Sign(www)
+	if later[0].Data.(parser.RRSIGRecord).Expiration <= first[0].Data.(parser.RRSIGRecord).Expiration {
+		t.Fatalf("expect a new signature, is %s", later[0])
+	}
+	// the renewed signature replaced the old one, and a later sweep drops
+	// the RRsets that are no longer asked for
+	if cached := signer.Cached(); cached != 1 {
+		t.Fatalf("cached dont match is %d want 1", cached)
+	}
+	now = now.Add(6 * 24 * time.Hour)
+	if _, err := signer.Sign(records[2:3]); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if cached := signer.Cached(); cached != 1 {
+		t.Fatalf("cached dont match is %d want 1", cached)
+	}
+	if sigs, _ := signer.Sign(records[6:7]); len(sigs) != 0 {
+		t.Fatalf("glue should not be signed, is %s", sigs)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/pascal-sochacki/dns/internal/dnssec"
+	"github.com/pascal-sochacki/dns/internal/parser"
+)
+
+const (
+	// how many CNAMEs are followed inside the zone before giving up
+	maxCNAMEChain = 8
+	// how many validation results are kept before the cache is emptied
+	maxAuthenticated = 10000
+)
+
+// zone answers authoritatively from the records of a master file.
+type zone struct {
+	records []parser.Answer
+	// checks the signatures of the answers, nil without trust anchors
+	validator *dnssec.Validator
+	// the validation results by question, until they expire
+	authenticated map[string]dnssec.Result
+	// signs answers for clients that ask for DNSSEC, nil for an unsigned
+	// zone
+	signer *dnssec.Signer
+}
+
+func newZone(records []parser.Answer) *zone {
+	return &zone{records: records, authenticated: map[string]dnssec.Result{}}
+}
+
+// sign makes the zone signed by signer, its DNSKEY and NSEC3PARAM records
+// are added unless the master file has them.
+func (z *zone) sign(signer *dnssec.Signer) {
+	z.signer = signer
+	for _, record := range signer.ApexRecords() {
+		if !slices.ContainsFunc(z.records, func(other parser.Answer) bool { return other.String() == record.String() }) {
+			z.records = append(z.records, record)
+		}
+	}
+}
+
+func (z *zone) answer(query Query) Result {
+	question := query.Question
+	if question.Class != parser.IN {
+		return refusedClass
+	}
+	apex, ok := z.apex(question.Name)
+	if !ok {
+		return Result{
+			ResponseCode: parser.REFUSED,
+			Error: &parser.ExtendedError{
+				InfoCode:  parser.EDE_NOT_AUTHORITATIVE,
+				ExtraText: "not authoritative for this name",
+			},
+		}
+	}
+
+	found := z.resolve(question, apex)
+	result := found.Result
+	answers := z.withSignatures(result.Answers)
+	var authority []parser.Answer
+	switch {
+	case found.cut != nil:
+		// the servers of the child zone with their addresses, RFC 1034
+		// section 4.3.2
+		result.Referral = true
+		result.Authority = z.rrset(found.cut, parser.NS)
+		result.Additional = z.glue(apex, result.Authority)
+		authority = append(slices.Clone(result.Authority), z.delegationProof(apex, found.cut)...)
+	case found.missing != nil:
+		// every client gets the SOA to cache the negative answer with, RFC
+		// 2308 section 3
+		result.Authority = z.soa(apex)
+		authority = z.negative(apex, found.missing)
+	}
+	// answers expanded from a wildcard need the proof that the name itself
+	// doesn't exist, RFC 4035 section 3.1.3.3
+	for _, name := range found.expanded {
+		for _, record := range z.proofs(apex, name) {
+			if !slices.ContainsFunc(authority, func(other parser.Answer) bool { return other.String() == record.String() }) {
+				authority = append(authority, record)
+			}
+		}
+	}
+	// signatures and proofs only go to clients that ask for them, RFC 4035
+	// section 3.1
+	if query.DNSSECOK {
+		result.Answers, result.Authority = answers, authority
+	}
+	// the NS records of a referral are not signed, so it is never
+	// authentic
+	if !result.Referral {
+		result.Authentic = z.authentic(question, result.ResponseCode, answers, authority)
+	}
+	return result
+}
+
+// resolution is what resolve found for a question.
+type resolution struct {
+	Result
+	// the name that has no records of the type asked for, nil if the
+	// answer is complete or leaves the zone
+	missing parser.Name
+	// the delegation the answer continues below, nil if the zone is
+	// authoritative for it
+	cut parser.Name
+	// the names whose records were expanded from a wildcard
+	expanded []parser.Name
+}
+
+// resolve follows CNAMEs inside the zone until records of the type asked
+// for are found, or a delegation is reached.
+func (z *zone) resolve(question parser.Question, apex parser.Name) (found resolution) {
+	name := question.Name
+	for range maxCNAMEChain {
+		// the DS records of a delegation are in the parent, RFC 4035
+		// section 3.1.4.1
+		if cut := z.delegation(apex, name); cut != nil && !(question.Type == parser.DS && cut.Equal(name)) {
+			found.cut = cut
+			return found
+		}
+		records := z.lookup(name)
+		if len(records) == 0 && !z.exists(name) {
+			wildcard, ok := z.wildcard(name)
+			if !ok {
+				found.ResponseCode = parser.NAME_ERROR
+				found.missing = name
+				return found
+			}
+			// the records of the wildcard with the owner asked for, RFC
+			// 4592 section 3.3.1
+			for _, record := range z.lookup(wildcard) {
+				record.Name = name
+				records = append(records, record)
+			}
+			found.expanded = append(found.expanded, name)
+		}
+		if len(records) == 0 {
+			found.missing = name
+			return found
+		}
+		matched := false
+		var cname *parser.Answer
+		for _, record := range records {
+			if record.Type == question.Type || question.Type == parser.ALL {
+				found.Answers = append(found.Answers, record)
+				matched = true
+			} else if record.Type == parser.CNAME {
+				cname = &record
+			}
+		}
+		if matched {
+			return found
+		}
+		if cname == nil {
+			found.missing = name
+			return found
+		}
+		found.Answers = append(found.Answers, *cname)
+		name = cname.Data.(parser.CNAMERecord).Target
+		if !name.IsSubdomainOf(apex) {
+			return found
+		}
+	}
+	return found
+}
+
+// delegation returns the topmost name with NS records between apex and
+// name, everything at or below it belongs to another zone.
+func (z *zone) delegation(apex parser.Name, name parser.Name) parser.Name {
+	var cut parser.Name
+	for _, record := range z.records {
+		if record.Type == parser.NS && len(record.Name) > len(apex) && name.IsSubdomainOf(record.Name) && record.Name.IsSubdomainOf(apex) && (cut == nil || len(record.Name) < len(cut)) {
+			cut = record.Name
+		}
+	}
+	return cut
+}
+
+// glue returns the address records of the name servers in ns that lie in
+// the zone, the resolver couldn't find them otherwise, RFC 9471.
+func (z *zone) glue(apex parser.Name, ns []parser.Answer) []parser.Answer {
+	glue := []parser.Answer{}
+	for _, record := range ns {
+		host := record.Data.(parser.NSRecord).Host
+		if !host.IsSubdomainOf(apex) {
+			continue
+		}
+		glue = append(glue, z.rrset(host, parser.A)...)
+		glue = append(glue, z.rrset(host, parser.AAAA)...)
+	}
+	return glue
+}
+
+// delegationProof returns the signed DS records of a delegation, or the
+// proof that it has none, RFC 4035 section 3.1.4.
+func (z *zone) delegationProof(apex parser.Name, cut parser.Name) []parser.Answer {
+	if ds := z.rrset(cut, parser.DS); len(ds) > 0 {
+		return append(ds, z.signatures(cut, parser.DS)...)
+	}
+	return z.proofs(apex, cut)
+}
+
+// apex returns the owner of the closest SOA record at or above name.
+func (z *zone) apex(name parser.Name) (parser.Name, bool) {
+	var apex parser.Name
+	found := false
+	for _, record := range z.records {
+		if record.Type == parser.SOA && name.IsSubdomainOf(record.Name) && (!found || len(record.Name) > len(apex)) {
+			apex = record.Name
+			found = true
+		}
+	}
+	return apex, found
+}
+
+// rrset returns the records of type t at name.
+func (z *zone) rrset(name parser.Name, t parser.QType) []parser.Answer {
+	rrset := []parser.Answer{}
+	for _, record := range z.lookup(name) {
+		if record.Type == t {
+			rrset = append(rrset, record)
+		}
+	}
+	return rrset
+}
+
+func (z *zone) lookup(name parser.Name) []parser.Answer {
+	found := []parser.Answer{}
+	for _, record := range z.records {
+		if record.Name.Equal(name) {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+// wildcard returns the wildcard that matches name: the one below the
+// closest encloser of a name that doesn't exist, RFC 4592 section 3.3.1.
+func (z *zone) wildcard(name parser.Name) (parser.Name, bool) {
+	if z.exists(name) {
+		return nil, false
+	}
+	for i := 1; i <= len(name); i++ {
+		if !z.exists(name[i:]) {
+			continue
+		}
+		wildcard, err := name[i:].Child("*")
+		return wildcard, err == nil && len(z.lookup(wildcard)) > 0
+	}
+	return nil, false
+}
+
+// exists tells whether there are records at or below name, so that empty
+// non-terminals don't get NXDOMAIN, RFC 8020.
+func (z *zone) exists(name parser.Name) bool {
+	for _, record := range z.records {
+		if record.Name.IsSubdomainOf(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// authentic tells whether the answer validates as secure, RFC 4035 section
+// 3.2.3. The response is rebuilt with the signatures and denial proofs of
+// the zone, it is validated again once the result expires.
+func (z *zone) authentic(question parser.Question, rcode parser.RCODE, answers []parser.Answer, authority []parser.Answer) bool {
+	if z.validator == nil {
+		return false
+	}
+	key := parser.Question{Name: question.Name.Canonical(), Type: question.Type, Class: question.Class}.String()
+	if known, ok := z.authenticated[key]; ok && z.validator.Now().Before(known.Expires) {
+		return known.Status == dnssec.Secure
+	}
+	response := parser.Message{Questions: []parser.Question{question}, Answers: answers, Authority: authority}
+	response.Header.ResponseCode = rcode
+	result := z.validator.Validate(response)
+	// names that don't exist are endless, the cache must not be
+	if len(z.authenticated) >= maxAuthenticated {
+		clear(z.authenticated)
+	}
+	z.authenticated[key] = result
+	return result.Status == dnssec.Secure
+}
+
+// withSignatures adds the signatures of each RRset after its first record.
+func (z *zone) withSignatures(answers []parser.Answer) []parser.Answer {
+	signed := []parser.Answer{}
+	for _, answer := range answers {
+		if answer.Type == parser.RRSIG {
+			continue
+		}
+		signed = append(signed, answer)
+		if !containsSignatures(signed, answer) {
+			signed = append(signed, z.signatures(answer.Name, answer.Type)...)
+		}
+	}
+	return signed
+}
+
+func containsSignatures(answers []parser.Answer, answer parser.Answer) bool {
+	for _, record := range answers {
+		if sig, ok := record.Data.(parser.RRSIGRecord); ok && sig.TypeCovered == answer.Type && record.Name.Equal(answer.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// signatures returns the RRSIG records covering the RRset of type t at name,
+// made by the signer or from the master file. The signatures of a wildcard
+// are returned with name as owner, their labels field tells the validator
+// about the expansion, RFC 4035 section 5.3.4.
+func (z *zone) signatures(name parser.Name, t parser.QType) []parser.Answer {
+	owner := name
+	if wildcard, ok := z.wildcard(name); ok {
+		owner = wildcard
+	}
+	var sigs []parser.Answer
+	if z.signer != nil {
+		var err error
+		sigs, err = z.signer.Sign(z.rrset(owner, t))
+		if err != nil {
+			slog.Error("signing", "name", owner, "type", t, "err", err)
+		}
+	} else {
+		for _, record := range z.records {
+			if sig, ok := record.Data.(parser.RRSIGRecord); ok && sig.TypeCovered == t && record.Name.Equal(owner) {
+				sigs = append(sigs, record)
+			}
+		}
+	}
+	expanded := make([]parser.Answer, 0, len(sigs))
+	for _, sig := range sigs {
+		sig.Name = name
+		expanded = append(expanded, sig)
+	}
+	return expanded
+}
+
+// proofs returns the NSEC or NSEC3 records with their signatures that show
+// what is missing at name, made by the signer or picked from the master
+// file.
+func (z *zone) proofs(apex parser.Name, name parser.Name) []parser.Answer {
+	if z.signer != nil {
+		proofs, err := z.signer.Deny(name)
+		if err != nil {
+			slog.Error("denying", "name", name, "err", err)
+		}
+		return proofs
+	}
+	return dnssec.Proof(z.records, apex, name)
+}
+
+// negative returns the authority section of a negative answer for name:
+// the SOA record and the proofs, RFC 4035 section 3.1.3.
+func (z *zone) negative(apex parser.Name, name parser.Name) []parser.Answer {
+	authority := append(z.soa(apex), z.signatures(apex, parser.SOA)...)
+	return append(authority, z.proofs(apex, name)...)
+}
+
+// soa returns the SOA record of a negative answer, with the smaller of its
+// TTL and minimum field as TTL, RFC 2308 section 3.
+func (z *zone) soa(apex parser.Name) []parser.Answer {
+	soa := []parser.Answer{}
+	for _, record := range z.lookup(apex) {
+		if data, ok := record.Data.(parser.SOARecord); ok {
+			record.TTL = min(record.TTL, data.Minimum)
+			soa = append(soa, record)
+		}
+	}
+	return soa
+}
+
+// Lookup lets the validator fetch DNSKEY and DS records from the zone
+// itself.
+func (z *zone) Lookup(name parser.Name, t parser.QType) (parser.Message, error) {
+	response := parser.NewQuery(name, t).Reply()
+	response.AddAnswer(z.rrset(name, t)...)
+	if len(response.Answers) > 0 {
+		return *response.AddAnswer(z.signatures(name, t)...), nil
+	}
+	if !z.exists(name) {
+		response.SetRcode(parser.NAME_ERROR)
+	}
+	if apex, ok := z.apex(name); ok {
+		response.AddAuthority(z.proofs(apex, name)...)
+	}
+	return *response, nil
+}